@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,20 +16,142 @@ import (
 	"github.com/knadh/koanf/providers/structs"
 )
 
+// The names of the supported storage backends.
+const (
+	StorageVictoriaMetrics       = "victoriametrics"
+	StorageInfluxDB              = "influxdb"
+	StoragePrometheus            = "prometheus"
+	StoragePrometheusRemoteWrite = "prometheus_remote_write"
+	StoragePushgateway           = "pushgateway"
+	StorageOTLP                  = "otlp"
+	StorageSQLite                = "sqlite"
+	StoragePostgres              = "postgres"
+	StorageClickHouse            = "clickhouse"
+	StorageFile                  = "file"
+	StorageOpenMetrics           = "openmetrics"
+	StorageGraphite              = "graphite"
+	StorageStatsD                = "statsd"
+)
+
+// fixedSchemaStorages are the storages whose tables or files have a fixed schema, which have a
+// column or field for each label.
+var fixedSchemaStorages = []string{StorageSQLite, StoragePostgres, StorageClickHouse, StorageFile}
+
+// fixedSchemaColumns are the columns and fields of the storages with a fixed schema, which
+// cannot be used as label names with them.
+var fixedSchemaColumns = []string{
+	"timestamp", "time", "labels", "count", "note_count", "link_count", "word_count", "backlink_count",
+	"open_count", "completed_count", "broken_heading_count", "broken_block_count", "average_note_age_seconds",
+	"created_last_day", "created_last_week", "created_last_month", "modified_last_day", "modified_last_week", "modified_last_month",
+}
+
+// staleSeriesStorages are the storages ending the series of deleted notes with the `stale`
+// DeletedNotesMode, either with staleness markers or by no longer exposing them.
+var staleSeriesStorages = []string{StoragePrometheus, StoragePrometheusRemoteWrite, StoragePushgateway, StorageOTLP}
+
+// The supported ways of reporting notes that were deleted since the previous collection.
+const (
+	DeletedNotesModeNone  = "none"
+	DeletedNotesModeStale = "stale"
+	DeletedNotesModeZero  = "zero"
+	DeletedNotesModeEvent = "event"
+)
+
+// measurementNames are the default names of the measurements written to the storages.
+var measurementNames = []string{"notes", "total", "deleted_notes", "tags", "tag_links", "activity", "tasks", "note_tasks", "anchors", "note_anchors"}
+
+// reservedLabels are the label names used by the exporter itself, which cannot be used as static labels.
+var reservedLabels = []string{"name", "db", "tag", "source", "target"}
+
+// invalidLabelCharacters matches the characters not allowed in label names.
+var invalidLabelCharacters = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// metricNamePattern matches the names allowed for metrics and labels.
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 type Config struct {
-	ZettelkastenDirectory    string        `koanf:"zettelkasten_directory" validate:"requiredWithout:ZettelkastenGitURL"`
-	ZettelkastenGitURL       string        `koanf:"zettelkasten_git_url" validate:"requiredWithout:ZettelkastenDirectory|url"`
-	ZettelkastenGitBranch    string        `koanf:"zettelkasten_git_branch"`
-	ZettelkastenGitToken     string        `koanf:"zettelkasten_git_token"`
-	LogLevel                 slog.Level    `koanf:"log_level"`
-	IgnoreFiles              []string      `koanf:"ignore_files"`
-	CollectionInterval       time.Duration `koanf:"collection_interval"`
-	CollectHistoricalMetrics bool          `koanf:"collect_historical_metrics"`
-	VictoriaMetricsURL       string        `koanf:"victoriametrics_url" validate:"fullUrl"`
-	InfluxDBURL              string        `koanf:"influxdb_url" validate:"fullUrl"`
-	InfluxDBToken            string        `koanf:"influxdb_token" validate:"requiredWith:InfluxDBURL"`
-	InfluxDBOrg              string        `koanf:"influxdb_org" validate:"requiredWith:InfluxDBURL"`
-	InfluxDBBucket           string        `koanf:"influxdb_bucket" validate:"requiredWith:InfluxDBURL"`
+	ZettelkastenDirectory                   string        `koanf:"zettelkasten_directory" validate:"requiredWithout:ZettelkastenGitURL"`
+	ZettelkastenGitURL                      string        `koanf:"zettelkasten_git_url" validate:"requiredWithout:ZettelkastenDirectory|url"`
+	ZettelkastenGitBranch                   string        `koanf:"zettelkasten_git_branch"`
+	ZettelkastenGitToken                    string        `koanf:"zettelkasten_git_token"`
+	LogLevel                                slog.Level    `koanf:"log_level"`
+	IgnoreFiles                             []string      `koanf:"ignore_files"`
+	CollectionInterval                      time.Duration `koanf:"collection_interval"`
+	CollectHistoricalMetrics                bool          `koanf:"collect_historical_metrics"`
+	RunOnce                                 bool          `koanf:"run_once"`
+	DeletedNotesMode                        string        `koanf:"deleted_notes_mode" validate:"in:none,stale,zero,event"`
+	MetricPrefix                            string        `koanf:"metric_prefix"`
+	MetricMeasurementNames                  []string      `koanf:"metric_measurement_names"`
+	MetricLabels                            []string      `koanf:"metric_labels"`
+	NoteSeries                              bool          `koanf:"note_series"`
+	NoteSeriesLimit                         int           `koanf:"note_series_limit" validate:"min:0"`
+	NoteNameHash                            bool          `koanf:"note_name_hash"`
+	NoteNameHashSalt                        string        `koanf:"note_name_hash_salt"`
+	FrontmatterLabels                       []string      `koanf:"frontmatter_labels"`
+	NoteCreatedKey                          string        `koanf:"note_created_key"`
+	VictoriaMetricsURL                      string        `koanf:"victoriametrics_url" validate:"fullUrl"`
+	VictoriaMetricsUsername                 string        `koanf:"victoriametrics_username"`
+	VictoriaMetricsPassword                 string        `koanf:"victoriametrics_password"`
+	VictoriaMetricsBearerToken              string        `koanf:"victoriametrics_bearer_token"`
+	VictoriaMetricsTimeout                  time.Duration `koanf:"victoriametrics_timeout"`
+	VictoriaMetricsCAFile                   string        `koanf:"victoriametrics_ca_file"`
+	VictoriaMetricsInsecureSkipVerify       bool          `koanf:"victoriametrics_insecure_skip_verify"`
+	VictoriaMetricsGzip                     bool          `koanf:"victoriametrics_gzip"`
+	VictoriaMetricsExtraLabels              []string      `koanf:"victoriametrics_extra_labels"`
+	VictoriaMetricsDB                       string        `koanf:"victoriametrics_db"`
+	VictoriaMetricsFormat                   string        `koanf:"victoriametrics_format" validate:"in:influx,json"`
+	VictoriaMetricsBatchSize                int           `koanf:"victoriametrics_batch_size"`
+	InfluxDBURL                             string        `koanf:"influxdb_url" validate:"fullUrl"`
+	InfluxDBVersion                         int           `koanf:"influxdb_version" validate:"in:1,2,3"`
+	InfluxDBToken                           string        `koanf:"influxdb_token"`
+	InfluxDBOrg                             string        `koanf:"influxdb_org"`
+	InfluxDBBucket                          string        `koanf:"influxdb_bucket"`
+	InfluxDBDatabase                        string        `koanf:"influxdb_database"`
+	InfluxDBRetentionPolicy                 string        `koanf:"influxdb_retention_policy"`
+	InfluxDBUsername                        string        `koanf:"influxdb_username"`
+	InfluxDBPassword                        string        `koanf:"influxdb_password"`
+	PrometheusListenAddress                 string        `koanf:"prometheus_listen_address"`
+	PrometheusRemoteWriteURL                string        `koanf:"prometheus_remote_write_url" validate:"fullUrl"`
+	PrometheusRemoteWriteUsername           string        `koanf:"prometheus_remote_write_username"`
+	PrometheusRemoteWritePassword           string        `koanf:"prometheus_remote_write_password"`
+	PrometheusRemoteWriteBearerToken        string        `koanf:"prometheus_remote_write_bearer_token"`
+	PrometheusRemoteWriteHeaders            []string      `koanf:"prometheus_remote_write_headers"`
+	PrometheusRemoteWriteTimeout            time.Duration `koanf:"prometheus_remote_write_timeout"`
+	PrometheusRemoteWriteCAFile             string        `koanf:"prometheus_remote_write_ca_file"`
+	PrometheusRemoteWriteInsecureSkipVerify bool          `koanf:"prometheus_remote_write_insecure_skip_verify"`
+	PushgatewayURL                          string        `koanf:"pushgateway_url" validate:"fullUrl"`
+	PushgatewayJob                          string        `koanf:"pushgateway_job"`
+	PushgatewayGroupingKey                  []string      `koanf:"pushgateway_grouping_key"`
+	PushgatewayUsername                     string        `koanf:"pushgateway_username"`
+	PushgatewayPassword                     string        `koanf:"pushgateway_password"`
+	OTLPEndpoint                            string        `koanf:"otlp_endpoint" validate:"fullUrl"`
+	OTLPProtocol                            string        `koanf:"otlp_protocol" validate:"in:http/protobuf,grpc"`
+	OTLPHeaders                             []string      `koanf:"otlp_headers"`
+	OTLPTimeout                             time.Duration `koanf:"otlp_timeout"`
+	OTLPCAFile                              string        `koanf:"otlp_ca_file"`
+	OTLPInsecureSkipVerify                  bool          `koanf:"otlp_insecure_skip_verify"`
+	SQLitePath                              string        `koanf:"sqlite_path"`
+	PostgresURL                             string        `koanf:"postgres_url"`
+	PostgresTimescaleDB                     bool          `koanf:"postgres_timescaledb"`
+	ClickHouseURL                           string        `koanf:"clickhouse_url" validate:"fullUrl"`
+	ClickHouseDatabase                      string        `koanf:"clickhouse_database"`
+	ClickHouseUsername                      string        `koanf:"clickhouse_username"`
+	ClickHousePassword                      string        `koanf:"clickhouse_password"`
+	FileDirectory                           string        `koanf:"file_directory"`
+	FileFormat                              string        `koanf:"file_format" validate:"in:jsonl,csv,parquet"`
+	FileDailyRotation                       bool          `koanf:"file_daily_rotation"`
+	OpenMetricsPath                         string        `koanf:"openmetrics_path"`
+	GraphiteAddress                         string        `koanf:"graphite_address"`
+	GraphiteProtocol                        string        `koanf:"graphite_protocol" validate:"in:tcp,udp"`
+	GraphitePrefix                          string        `koanf:"graphite_prefix"`
+	StatsDAddress                           string        `koanf:"statsd_address"`
+	StatsDPrefix                            string        `koanf:"statsd_prefix"`
+	StorageBestEffort                       []string      `koanf:"storage_best_effort"`
+	StorageWriteTimeout                     time.Duration `koanf:"storage_write_timeout" validate:"min:0"`
+	StorageBufferDirectory                  string        `koanf:"storage_buffer_directory"`
+	StorageBufferMaxRetries                 int           `koanf:"storage_buffer_max_retries" validate:"min:0"`
+	StorageBufferInitialBackoff             time.Duration `koanf:"storage_buffer_initial_backoff"`
+	StorageBufferMaxBackoff                 time.Duration `koanf:"storage_buffer_max_backoff"`
 }
 
 func LoadConfig() (Config, error) {
@@ -34,11 +159,27 @@ func LoadConfig() (Config, error) {
 
 	// Set default values
 	err := k.Load(structs.Provider(Config{
-		LogLevel:                 slog.LevelInfo,
-		IgnoreFiles:              []string{".git", ".obsidian", ".trash", "README.md"},
-		ZettelkastenGitBranch:    "main",
-		CollectionInterval:       time.Minute * 5,
-		CollectHistoricalMetrics: true,
+		LogLevel:                     slog.LevelInfo,
+		IgnoreFiles:                  []string{".git", ".obsidian", ".trash", "README.md"},
+		ZettelkastenGitBranch:        "main",
+		CollectionInterval:           time.Minute * 5,
+		CollectHistoricalMetrics:     true,
+		DeletedNotesMode:             DeletedNotesModeNone,
+		NoteSeries:                   true,
+		VictoriaMetricsFormat:        "influx",
+		VictoriaMetricsBatchSize:     1,
+		InfluxDBVersion:              2,
+		PrometheusRemoteWriteTimeout: 30 * time.Second,
+		PushgatewayJob:               "zettelkasten-exporter",
+		OTLPProtocol:                 "http/protobuf",
+		OTLPTimeout:                  30 * time.Second,
+		FileFormat:                   "jsonl",
+		GraphiteProtocol:             "tcp",
+		GraphitePrefix:               "zettelkasten",
+		StatsDPrefix:                 "zettelkasten",
+		StorageBufferMaxRetries:      5,
+		StorageBufferInitialBackoff:  time.Second,
+		StorageBufferMaxBackoff:      time.Minute,
 	}, "koanf"), nil)
 	if err != nil {
 		return Config{}, fmt.Errorf("error loading default config values: %w", err)
@@ -76,11 +217,61 @@ func LoadConfig() (Config, error) {
 	if cfg.ZettelkastenGitURL != "" && cfg.ZettelkastenDirectory != "" {
 		return Config{}, errors.New("ZettelkastenGitURL and ZettelkastenDirectory cannot be provided together")
 	}
-	if cfg.VictoriaMetricsURL != "" && cfg.InfluxDBURL != "" {
-		return Config{}, errors.New("InfluxDBURL and VictoriaMetricsURL cannot be provided together")
+	storages := cfg.Storages()
+	if len(storages) == 0 {
+		return Config{}, errors.New("at least one of InfluxDBURL, VictoriaMetricsURL, PrometheusListenAddress, PrometheusRemoteWriteURL, PushgatewayURL, OTLPEndpoint, SQLitePath, PostgresURL, ClickHouseURL, FileDirectory, OpenMetricsPath, GraphiteAddress or StatsDAddress must be provided")
 	}
-	if cfg.VictoriaMetricsURL == "" && cfg.InfluxDBURL == "" {
-		return Config{}, errors.New("either InfluxDBURL or VictoriaMetricsURL must be provided")
+	for _, name := range cfg.StorageBestEffort {
+		if !slices.Contains(storages, name) {
+			return Config{}, fmt.Errorf("invalid StorageBestEffort: storage %q is not configured", name)
+		}
+	}
+	if cfg.DeletedNotesMode == DeletedNotesModeStale {
+		for _, name := range storages {
+			if !slices.Contains(staleSeriesStorages, name) {
+				return Config{}, fmt.Errorf("DeletedNotesMode %q is not supported by the %s storage", DeletedNotesModeStale, name)
+			}
+		}
+	}
+	if cfg.InfluxDBURL != "" {
+		switch cfg.InfluxDBVersion {
+		case 1:
+			if cfg.InfluxDBDatabase == "" {
+				return Config{}, errors.New("InfluxDBDatabase must be provided for InfluxDB 1.x")
+			}
+		case 2:
+			if cfg.InfluxDBToken == "" || cfg.InfluxDBOrg == "" || cfg.InfluxDBBucket == "" {
+				return Config{}, errors.New("InfluxDBToken, InfluxDBOrg and InfluxDBBucket must be provided for InfluxDB 2.x")
+			}
+		case 3:
+			if cfg.InfluxDBToken == "" || cfg.InfluxDBDatabase == "" {
+				return Config{}, errors.New("InfluxDBToken and InfluxDBDatabase must be provided for InfluxDB 3")
+			}
+		}
+	}
+	if cfg.VictoriaMetricsBearerToken != "" && (cfg.VictoriaMetricsUsername != "" || cfg.VictoriaMetricsPassword != "") {
+		return Config{}, errors.New("VictoriaMetricsBearerToken and VictoriaMetricsUsername/VictoriaMetricsPassword cannot be provided together")
+	}
+	if _, err := ParseKeyValues(cfg.VictoriaMetricsExtraLabels); err != nil {
+		return Config{}, fmt.Errorf("invalid VictoriaMetricsExtraLabels: %w", err)
+	}
+	if cfg.VictoriaMetricsBatchSize < 1 {
+		return Config{}, errors.New("VictoriaMetricsBatchSize must be at least 1")
+	}
+	if cfg.PrometheusRemoteWriteBearerToken != "" && (cfg.PrometheusRemoteWriteUsername != "" || cfg.PrometheusRemoteWritePassword != "") {
+		return Config{}, errors.New("PrometheusRemoteWriteBearerToken and PrometheusRemoteWriteUsername/PrometheusRemoteWritePassword cannot be provided together")
+	}
+	if _, err := ParseKeyValues(cfg.PrometheusRemoteWriteHeaders); err != nil {
+		return Config{}, fmt.Errorf("invalid PrometheusRemoteWriteHeaders: %w", err)
+	}
+	if err := validatePushgatewayGroupingKey(cfg.PushgatewayGroupingKey); err != nil {
+		return Config{}, err
+	}
+	if _, err := ParseKeyValues(cfg.OTLPHeaders); err != nil {
+		return Config{}, fmt.Errorf("invalid OTLPHeaders: %w", err)
+	}
+	if err := validateMetricNaming(cfg); err != nil {
+		return Config{}, err
 	}
 
 	return cfg, nil
@@ -96,14 +287,219 @@ func (c Config) LogValue() slog.Value {
 		slog.Any("IgnoreFiles", c.IgnoreFiles),
 		slog.Duration("CollectionInterval", c.CollectionInterval),
 		slog.Bool("CollectHistoricalMetrics", c.CollectHistoricalMetrics),
+		slog.Bool("RunOnce", c.RunOnce),
+		slog.String("DeletedNotesMode", c.DeletedNotesMode),
+		slog.String("MetricPrefix", c.MetricPrefix),
+		slog.Any("MetricMeasurementNames", c.MetricMeasurementNames),
+		slog.Any("MetricLabels", c.MetricLabels),
+		slog.Bool("NoteSeries", c.NoteSeries),
+		slog.Int("NoteSeriesLimit", c.NoteSeriesLimit),
+		slog.Bool("NoteNameHash", c.NoteNameHash),
+		slog.String("NoteNameHashSalt", "[REDACTED]"),
+		slog.Any("FrontmatterLabels", c.FrontmatterLabels),
+		slog.String("NoteCreatedKey", c.NoteCreatedKey),
 		slog.String("VictoriaMetricsURL", c.VictoriaMetricsURL),
+		slog.String("VictoriaMetricsUsername", c.VictoriaMetricsUsername),
+		slog.String("VictoriaMetricsPassword", "[REDACTED]"),
+		slog.String("VictoriaMetricsBearerToken", "[REDACTED]"),
+		slog.Duration("VictoriaMetricsTimeout", c.VictoriaMetricsTimeout),
+		slog.String("VictoriaMetricsCAFile", c.VictoriaMetricsCAFile),
+		slog.Bool("VictoriaMetricsInsecureSkipVerify", c.VictoriaMetricsInsecureSkipVerify),
+		slog.Bool("VictoriaMetricsGzip", c.VictoriaMetricsGzip),
+		slog.Any("VictoriaMetricsExtraLabels", c.VictoriaMetricsExtraLabels),
+		slog.String("VictoriaMetricsDB", c.VictoriaMetricsDB),
+		slog.String("VictoriaMetricsFormat", c.VictoriaMetricsFormat),
+		slog.Int("VictoriaMetricsBatchSize", c.VictoriaMetricsBatchSize),
 		slog.String("InfluxDBURL", c.InfluxDBURL),
+		slog.Int("InfluxDBVersion", c.InfluxDBVersion),
 		slog.String("InfluxDBToken", "[REDACTED]"),
 		slog.String("InfluxDBOrg", c.InfluxDBOrg),
 		slog.String("InfluxDBBucket", c.InfluxDBBucket),
+		slog.String("InfluxDBDatabase", c.InfluxDBDatabase),
+		slog.String("InfluxDBRetentionPolicy", c.InfluxDBRetentionPolicy),
+		slog.String("InfluxDBUsername", c.InfluxDBUsername),
+		slog.String("InfluxDBPassword", "[REDACTED]"),
+		slog.String("PrometheusListenAddress", c.PrometheusListenAddress),
+		slog.String("PrometheusRemoteWriteURL", c.PrometheusRemoteWriteURL),
+		slog.String("PrometheusRemoteWriteUsername", c.PrometheusRemoteWriteUsername),
+		slog.String("PrometheusRemoteWritePassword", "[REDACTED]"),
+		slog.String("PrometheusRemoteWriteBearerToken", "[REDACTED]"),
+		slog.String("PrometheusRemoteWriteHeaders", "[REDACTED]"),
+		slog.Duration("PrometheusRemoteWriteTimeout", c.PrometheusRemoteWriteTimeout),
+		slog.String("PrometheusRemoteWriteCAFile", c.PrometheusRemoteWriteCAFile),
+		slog.Bool("PrometheusRemoteWriteInsecureSkipVerify", c.PrometheusRemoteWriteInsecureSkipVerify),
+		slog.String("PushgatewayURL", c.PushgatewayURL),
+		slog.String("PushgatewayJob", c.PushgatewayJob),
+		slog.Any("PushgatewayGroupingKey", c.PushgatewayGroupingKey),
+		slog.String("PushgatewayUsername", c.PushgatewayUsername),
+		slog.String("PushgatewayPassword", "[REDACTED]"),
+		slog.String("OTLPEndpoint", c.OTLPEndpoint),
+		slog.String("OTLPProtocol", c.OTLPProtocol),
+		slog.String("OTLPHeaders", "[REDACTED]"),
+		slog.Duration("OTLPTimeout", c.OTLPTimeout),
+		slog.String("OTLPCAFile", c.OTLPCAFile),
+		slog.Bool("OTLPInsecureSkipVerify", c.OTLPInsecureSkipVerify),
+		slog.String("SQLitePath", c.SQLitePath),
+		slog.String("PostgresURL", "[REDACTED]"),
+		slog.Bool("PostgresTimescaleDB", c.PostgresTimescaleDB),
+		slog.String("ClickHouseURL", c.ClickHouseURL),
+		slog.String("ClickHouseDatabase", c.ClickHouseDatabase),
+		slog.String("ClickHouseUsername", c.ClickHouseUsername),
+		slog.String("ClickHousePassword", "[REDACTED]"),
+		slog.String("FileDirectory", c.FileDirectory),
+		slog.String("FileFormat", c.FileFormat),
+		slog.Bool("FileDailyRotation", c.FileDailyRotation),
+		slog.String("OpenMetricsPath", c.OpenMetricsPath),
+		slog.String("GraphiteAddress", c.GraphiteAddress),
+		slog.String("GraphiteProtocol", c.GraphiteProtocol),
+		slog.String("GraphitePrefix", c.GraphitePrefix),
+		slog.String("StatsDAddress", c.StatsDAddress),
+		slog.String("StatsDPrefix", c.StatsDPrefix),
+		slog.Any("StorageBestEffort", c.StorageBestEffort),
+		slog.Duration("StorageWriteTimeout", c.StorageWriteTimeout),
+		slog.String("StorageBufferDirectory", c.StorageBufferDirectory),
+		slog.Int("StorageBufferMaxRetries", c.StorageBufferMaxRetries),
+		slog.Duration("StorageBufferInitialBackoff", c.StorageBufferInitialBackoff),
+		slog.Duration("StorageBufferMaxBackoff", c.StorageBufferMaxBackoff),
 	)
 }
 
+// validateMetricNaming validates the metric prefix, measurement names and labels of `cfg`.
+//
+// The labels are also rejected when their names are used by the columns of a storage with a
+// fixed schema, since they are added as columns to it.
+func validateMetricNaming(cfg Config) error {
+	if cfg.MetricPrefix != "" && !metricNamePattern.MatchString(cfg.MetricPrefix) {
+		return fmt.Errorf("invalid MetricPrefix %q: must contain only letters, digits and underscores", cfg.MetricPrefix)
+	}
+	measurements, err := ParseKeyValues(cfg.MetricMeasurementNames)
+	if err != nil {
+		return fmt.Errorf("invalid MetricMeasurementNames: %w", err)
+	}
+	for measurement, name := range measurements {
+		if !slices.Contains(measurementNames, measurement) {
+			return fmt.Errorf("invalid MetricMeasurementNames: unknown measurement %q, expected one of %s", measurement, strings.Join(measurementNames, ", "))
+		}
+		if !metricNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid MetricMeasurementNames: name %q must contain only letters, digits and underscores", name)
+		}
+	}
+	labels, err := ParseKeyValues(cfg.MetricLabels)
+	if err != nil {
+		return fmt.Errorf("invalid MetricLabels: %w", err)
+	}
+	for name := range labels {
+		if !metricNamePattern.MatchString(name) || strings.HasPrefix(name, "__") || slices.Contains(reservedLabels, name) {
+			return fmt.Errorf("invalid MetricLabels: label name %q is invalid or reserved", name)
+		}
+	}
+	frontmatterLabels := make(map[string]string, len(cfg.FrontmatterLabels))
+	for _, key := range cfg.FrontmatterLabels {
+		name := FrontmatterLabelName(key)
+		if !metricNamePattern.MatchString(name) || strings.HasPrefix(name, "__") || slices.Contains(reservedLabels, name) {
+			return fmt.Errorf("invalid FrontmatterLabels: label name %q of key %q is invalid or reserved", name, key)
+		}
+		if _, ok := labels[name]; ok {
+			return fmt.Errorf("invalid FrontmatterLabels: label name %q of key %q is already a metric label", name, key)
+		}
+		if other, ok := frontmatterLabels[name]; ok {
+			return fmt.Errorf("invalid FrontmatterLabels: keys %q and %q have the same label name %q", other, key, name)
+		}
+		frontmatterLabels[name] = key
+	}
+
+	for _, storage := range cfg.Storages() {
+		if !slices.Contains(fixedSchemaStorages, storage) {
+			continue
+		}
+		// SQL identifiers are case insensitive
+		for _, name := range slices.Concat(slices.Collect(maps.Keys(labels)), slices.Collect(maps.Keys(frontmatterLabels))) {
+			if slices.Contains(fixedSchemaColumns, strings.ToLower(name)) {
+				return fmt.Errorf("invalid label name %q: it's a column of the %s storage", name, storage)
+			}
+		}
+	}
+	return nil
+}
+
+// FrontmatterLabelName returns the name of the label for the frontmatter `key`, with the
+// characters not allowed in label names replaced by underscores.
+func FrontmatterLabelName(key string) string {
+	return invalidLabelCharacters.ReplaceAllString(key, "_")
+}
+
+// validatePushgatewayGroupingKey validates the label names of the Pushgateway grouping key.
+func validatePushgatewayGroupingKey(groupingKey []string) error {
+	labels, err := ParseKeyValues(groupingKey)
+	if err != nil {
+		return fmt.Errorf("invalid PushgatewayGroupingKey: %w", err)
+	}
+	for name := range labels {
+		if !metricNamePattern.MatchString(name) || strings.HasPrefix(name, "__") || name == "job" {
+			return fmt.Errorf("invalid PushgatewayGroupingKey: label name %q is invalid or reserved", name)
+		}
+	}
+	return nil
+}
+
+// ParseKeyValues parses a list of `key=value` pairs into a map.
+func ParseKeyValues(values []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, found := strings.Cut(value, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid key value pair %q, expected key=value", value)
+		}
+		parsed[key] = val
+	}
+	return parsed, nil
+}
+
+// Storages returns the names of all storage backends configured.
+func (c Config) Storages() []string {
+	storages := make([]string, 0)
+	if c.VictoriaMetricsURL != "" {
+		storages = append(storages, StorageVictoriaMetrics)
+	}
+	if c.InfluxDBURL != "" {
+		storages = append(storages, StorageInfluxDB)
+	}
+	if c.PrometheusListenAddress != "" {
+		storages = append(storages, StoragePrometheus)
+	}
+	if c.PrometheusRemoteWriteURL != "" {
+		storages = append(storages, StoragePrometheusRemoteWrite)
+	}
+	if c.PushgatewayURL != "" {
+		storages = append(storages, StoragePushgateway)
+	}
+	if c.OTLPEndpoint != "" {
+		storages = append(storages, StorageOTLP)
+	}
+	if c.SQLitePath != "" {
+		storages = append(storages, StorageSQLite)
+	}
+	if c.PostgresURL != "" {
+		storages = append(storages, StoragePostgres)
+	}
+	if c.ClickHouseURL != "" {
+		storages = append(storages, StorageClickHouse)
+	}
+	if c.FileDirectory != "" {
+		storages = append(storages, StorageFile)
+	}
+	if c.OpenMetricsPath != "" {
+		storages = append(storages, StorageOpenMetrics)
+	}
+	if c.GraphiteAddress != "" {
+		storages = append(storages, StorageGraphite)
+	}
+	if c.StatsDAddress != "" {
+		storages = append(storages, StorageStatsD)
+	}
+	return storages
+}
+
 func parseCollectionInterval(value string) (time.Duration, error) {
 	parsed, err := time.ParseDuration(value)
 	if err != nil {
@@ -0,0 +1,115 @@
+package exporter
+
+import (
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/luissimas/zettelkasten-exporter/internal/zettelkasten"
+)
+
+// frontmatterDateLayouts are the layouts accepted for dates in the frontmatter, from the most to
+// the least precise.
+var frontmatterDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	time.DateOnly,
+}
+
+// Periods before the collection in which the created and modified notes are counted.
+const (
+	day   = 24 * time.Hour
+	week  = 7 * day
+	month = 30 * day
+)
+
+// noteDates returns when the note at `path` in `root` was created and last modified.
+//
+// The dates come from `root` when it's a `zettelkasten.DatedFS` that knows them, and from the
+// modification time of the file otherwise. The creation date is overridden by the frontmatter
+// field `createdKey`, when set.
+func noteDates(root fs.FS, path string, frontmatter map[string]any, createdKey string) (time.Time, time.Time) {
+	var created, modified time.Time
+	if dated, ok := root.(zettelkasten.DatedFS); ok {
+		if dates, ok := dated.FileDates(path); ok {
+			created, modified = dates.Created, dates.Modified
+		}
+	}
+	if modified.IsZero() {
+		info, err := fs.Stat(root, path)
+		if err != nil {
+			slog.Warn("Error getting file modification time", slog.Any("error", err), slog.String("path", path))
+		} else {
+			created, modified = info.ModTime(), info.ModTime()
+		}
+	}
+
+	if createdKey != "" {
+		if value, ok := frontmatter[createdKey]; ok {
+			date, ok := frontmatterDate(value)
+			if ok {
+				created = date
+			} else {
+				slog.Warn("Invalid note creation date in frontmatter", slog.String("path", path), slog.Any("value", value))
+			}
+		}
+	}
+
+	return created, modified
+}
+
+// frontmatterDate returns the date of a frontmatter value, reporting whether it is one.
+//
+// Dates without a timezone are taken in the local timezone.
+func frontmatterDate(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range frontmatterDateLayouts {
+			date, err := time.ParseInLocation(layout, v, time.Local)
+			if err == nil {
+				return date, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// aggregateActivity aggregates the creation and modification dates of the notes relative to `collectionTime`.
+func aggregateActivity(noteMetrics map[string]metrics.NoteMetrics, collectionTime time.Time) metrics.ActivityMetrics {
+	var activity metrics.ActivityMetrics
+	var totalAge time.Duration
+	for _, note := range noteMetrics {
+		if !note.Created.IsZero() {
+			age := max(collectionTime.Sub(note.Created), 0)
+			totalAge += age
+			activity.NoteCount += 1
+			activity.CreatedLastDay += countWithin(age, day)
+			activity.CreatedLastWeek += countWithin(age, week)
+			activity.CreatedLastMonth += countWithin(age, month)
+		}
+		if !note.Modified.IsZero() {
+			age := max(collectionTime.Sub(note.Modified), 0)
+			activity.ModifiedLastDay += countWithin(age, day)
+			activity.ModifiedLastWeek += countWithin(age, week)
+			activity.ModifiedLastMonth += countWithin(age, month)
+		}
+	}
+	if activity.NoteCount > 0 {
+		activity.AverageNoteAge = totalAge / time.Duration(activity.NoteCount)
+	}
+	return activity
+}
+
+// countWithin returns 1 when `age` is within `period`, and 0 otherwise.
+func countWithin(age, period time.Duration) uint {
+	if age < period {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package exporter
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/luissimas/zettelkasten-exporter/internal/zettelkasten"
+	"github.com/stretchr/testify/assert"
+)
+
+// datedFS is a `zettelkasten.DatedFS` with fixed file dates.
+type datedFS struct {
+	fstest.MapFS
+	dates map[string]zettelkasten.FileDates
+}
+
+func (d datedFS) FileDates(name string) (zettelkasten.FileDates, bool) {
+	dates, ok := d.dates[name]
+	return dates, ok
+}
+
+func TestNoteDates(t *testing.T) {
+	modTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	created := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	modified := time.Date(2024, 5, 20, 18, 0, 0, 0, time.UTC)
+	mapFS := fstest.MapFS{"one.md": {Data: []byte("One"), ModTime: modTime}}
+	data := []struct {
+		name             string
+		dates            map[string]zettelkasten.FileDates
+		frontmatter      map[string]any
+		createdKey       string
+		expectedCreated  time.Time
+		expectedModified time.Time
+	}{
+		{
+			name:             "modification time",
+			expectedCreated:  modTime,
+			expectedModified: modTime,
+		},
+		{
+			name:             "file dates",
+			dates:            map[string]zettelkasten.FileDates{"one.md": {Created: created, Modified: modified}},
+			expectedCreated:  created,
+			expectedModified: modified,
+		},
+		{
+			name:             "unknown file dates",
+			dates:            map[string]zettelkasten.FileDates{"two.md": {Created: created, Modified: modified}},
+			expectedCreated:  modTime,
+			expectedModified: modTime,
+		},
+		{
+			name:             "frontmatter date",
+			dates:            map[string]zettelkasten.FileDates{"one.md": {Created: created, Modified: modified}},
+			frontmatter:      map[string]any{"created-at": "2024-04-10T08:30:00Z"},
+			createdKey:       "created-at",
+			expectedCreated:  time.Date(2024, 4, 10, 8, 30, 0, 0, time.UTC),
+			expectedModified: modified,
+		},
+		{
+			name:             "frontmatter date without key",
+			frontmatter:      map[string]any{"created-at": "2024-04-10T08:30:00Z"},
+			expectedCreated:  modTime,
+			expectedModified: modTime,
+		},
+		{
+			name:             "invalid frontmatter date",
+			frontmatter:      map[string]any{"created-at": "yesterday"},
+			createdKey:       "created-at",
+			expectedCreated:  modTime,
+			expectedModified: modTime,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			var created, modified time.Time
+			if d.dates != nil {
+				created, modified = noteDates(datedFS{MapFS: mapFS, dates: d.dates}, "one.md", d.frontmatter, d.createdKey)
+			} else {
+				created, modified = noteDates(mapFS, "one.md", d.frontmatter, d.createdKey)
+			}
+			assert.True(t, d.expectedCreated.Equal(created), "expected created %s, got %s", d.expectedCreated, created)
+			assert.True(t, d.expectedModified.Equal(modified), "expected modified %s, got %s", d.expectedModified, modified)
+		})
+	}
+}
+
+func TestFrontmatterDate(t *testing.T) {
+	data := []struct {
+		name     string
+		value    any
+		expected time.Time
+		ok       bool
+	}{
+		{name: "time", value: time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC), expected: time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC), ok: true},
+		{name: "RFC3339", value: "2024-05-29T10:30:00-03:00", expected: time.Date(2024, 5, 29, 13, 30, 0, 0, time.UTC), ok: true},
+		{name: "date and time", value: "2024-05-29 10:30", expected: time.Date(2024, 5, 29, 10, 30, 0, 0, time.Local), ok: true},
+		{name: "date", value: "2024-05-29", expected: time.Date(2024, 5, 29, 0, 0, 0, 0, time.Local), ok: true},
+		{name: "invalid string", value: "May 29", ok: false},
+		{name: "number", value: 20240529, ok: false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			result, ok := frontmatterDate(d.value)
+			assert.Equal(t, d.ok, ok)
+			assert.True(t, d.expected.Equal(result), "expected %s, got %s", d.expected, result)
+		})
+	}
+}
+
+func TestAggregateActivity(t *testing.T) {
+	collectionTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	noteMetrics := map[string]metrics.NoteMetrics{
+		"today":     {Created: collectionTime.Add(-time.Hour), Modified: collectionTime.Add(-time.Hour)},
+		"this-week": {Created: collectionTime.Add(-3 * 24 * time.Hour), Modified: collectionTime.Add(-2 * time.Hour)},
+		"old":       {Created: collectionTime.Add(-100 * 24 * time.Hour), Modified: collectionTime.Add(-20 * 24 * time.Hour)},
+		"future":    {Created: collectionTime.Add(time.Hour), Modified: collectionTime.Add(time.Hour)},
+		"unknown":   {},
+	}
+
+	result := aggregateActivity(noteMetrics, collectionTime)
+
+	assert.Equal(t, metrics.ActivityMetrics{
+		NoteCount:         4,
+		AverageNoteAge:    (time.Hour + 3*24*time.Hour + 100*24*time.Hour) / 4,
+		CreatedLastDay:    2,
+		CreatedLastWeek:   3,
+		CreatedLastMonth:  3,
+		ModifiedLastDay:   3,
+		ModifiedLastWeek:  3,
+		ModifiedLastMonth: 4,
+	}, result)
+}
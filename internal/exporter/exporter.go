@@ -2,11 +2,15 @@ package exporter
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"path/filepath"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/luissimas/zettelkasten-exporter/internal/config"
@@ -21,6 +25,8 @@ type Exporter struct {
 	storage      storage.Storage
 	zettelkasten zettelkasten.Zettelkasten
 	ticker       *time.Ticker
+	// notes holds the metrics of the notes in the previous collection by name.
+	notes map[string]metrics.NoteMetrics
 }
 
 // NewExporter creates a new exporter.
@@ -34,6 +40,9 @@ func NewExporter(cfg config.Config, zettelkasten zettelkasten.Zettelkasten, stor
 }
 
 // Start starts the exporter loop.
+//
+// The history walk, the collections and the writes in flight are interrupted when `ctx` is
+// cancelled, in which case the exporter stops without an error.
 func (e *Exporter) Start(ctx context.Context) error {
 	// Collect historical data
 	if e.config.CollectHistoricalMetrics {
@@ -45,32 +54,56 @@ func (e *Exporter) Start(ctx context.Context) error {
 			return err
 		}
 
-		slog.Info("Walking zettelkasten history")
-		err = e.zettelkasten.WalkHistory(e.collectMetrics)
+		since := e.latestStoredTimestamp(ctx)
+		slog.Info("Walking zettelkasten history", slog.Time("since", since))
+		err = e.zettelkasten.WalkHistory(ctx, since, func(root fs.FS, timestamp time.Time) error {
+			if !timestamp.After(since) {
+				return e.restoreCollection(root, timestamp)
+			}
+			return e.collectMetrics(ctx, root, timestamp)
+		})
+		if err == nil {
+			err = storage.Flush(ctx, e.storage)
+		}
+		if ctx.Err() != nil {
+			slog.Info("Stopping metrics collection", slog.Duration("duration", time.Since(start)))
+			return nil
+		}
 		if err != nil {
-			slog.Error("Error walking history", slog.Any("error", err))
+			slog.Error("Error collecting historical metrics", slog.Any("error", err))
 			return err
 		}
 
 		slog.Info("Collected historical metrics", slog.Duration("duration", time.Since(start)))
 	}
 
+	if e.config.RunOnce {
+		start := time.Now()
+		err := e.collect(ctx, start)
+		if ctx.Err() != nil {
+			slog.Info("Stopping metrics collection")
+			return nil
+		}
+		if err != nil {
+			slog.Error("Error collecting metrics", slog.Any("error", err))
+			return err
+		}
+		slog.Info("Collected metrics", slog.Duration("duration", time.Since(start)))
+		return nil
+	}
+
 	for {
 		select {
 		case t := <-e.ticker.C:
-			slog.Info("Starting metrics collection")
-			err := e.zettelkasten.Ensure()
-			if err != nil {
-				slog.Error("Error ensuring that zettelkasten is ready", slog.Any("error", err))
-				return err
+			err := e.collect(ctx, t)
+			if ctx.Err() != nil {
+				slog.Info("Stopping metrics collection")
+				return nil
 			}
-
-			err = e.collectMetrics(e.zettelkasten.GetRoot(), t)
 			if err != nil {
 				slog.Error("Error collecting metrics", slog.Any("error", err))
 				return err
 			}
-
 			slog.Info("Collected metrics", slog.Duration("duration", time.Since(t)), slog.Time("next_run", time.Now().Add(e.config.CollectionInterval)))
 		case <-ctx.Done():
 			slog.Info("Stopping metrics collection")
@@ -79,16 +112,54 @@ func (e *Exporter) Start(ctx context.Context) error {
 	}
 }
 
+// collect collects the current metrics of the Zettelkasten with a timestamp of `t` and flushes them to the storage.
+func (e *Exporter) collect(ctx context.Context, t time.Time) error {
+	slog.Info("Starting metrics collection")
+	err := e.zettelkasten.Ensure()
+	if err != nil {
+		return fmt.Errorf("error ensuring that zettelkasten is ready: %w", err)
+	}
+
+	err = e.collectMetrics(ctx, e.zettelkasten.GetRoot(), t)
+	if err != nil {
+		return err
+	}
+	err = storage.Flush(ctx, e.storage)
+	if err != nil {
+		return fmt.Errorf("error flushing metrics: %w", err)
+	}
+	return nil
+}
+
+// latestStoredTimestamp returns the timestamp of the latest metrics in the storage, so that
+// only newer points in the history are collected.
+//
+// A zero time is returned when the storage cannot tell which metrics it has, in which case the
+// whole history is collected again.
+func (e *Exporter) latestStoredTimestamp(ctx context.Context) time.Time {
+	latest, err := e.storage.LatestTimestamp(ctx)
+	if errors.Is(err, errors.ErrUnsupported) {
+		slog.Info("Storage does not report its latest metrics, collecting the whole history")
+		return time.Time{}
+	}
+	if err != nil {
+		slog.Warn("Error getting latest metrics from storage, collecting the whole history", slog.Any("error", err))
+		return time.Time{}
+	}
+	return latest
+}
+
 // collectMetrics collects all metrics from a Zettelkasten rooted in `root` and writes them to the storage with a timestamp of `collectionTime`.
-func (c *Exporter) collectMetrics(root fs.FS, collectionTime time.Time) error {
+func (c *Exporter) collectMetrics(ctx context.Context, root fs.FS, collectionTime time.Time) error {
 	slog.Debug("Collecting metrics", slog.Time("collection_time", collectionTime))
 	start := time.Now()
-	collected, err := c.scrapeMetrics(root)
+	collected, err := c.scrapeMetrics(root, collectionTime)
 	if err != nil {
 		return err
 	}
+	c.reportDeletedNotes(&collected)
 
-	err = c.storage.WriteMetrics(collected, collectionTime)
+	err = c.storage.WriteMetrics(ctx, collected, collectionTime)
 	if err != nil {
 		return err
 	}
@@ -98,8 +169,51 @@ func (c *Exporter) collectMetrics(root fs.FS, collectionTime time.Time) error {
 	return nil
 }
 
-// scrapeMetrics collects all metrics from a Zettelkasten rooted in `root`.
-func (c *Exporter) scrapeMetrics(root fs.FS) (metrics.ZettelkastenMetrics, error) {
+// restoreCollection restores the state of the collection of a Zettelkasten rooted in `root` at
+// `collectionTime`, whose metrics are already in the storage, so that the notes deleted after it
+// are reported.
+func (c *Exporter) restoreCollection(root fs.FS, collectionTime time.Time) error {
+	slog.Debug("Restoring collection", slog.Time("collection_time", collectionTime))
+	collected, err := c.scrapeMetrics(root, collectionTime)
+	if err != nil {
+		return err
+	}
+	c.reportDeletedNotes(&collected)
+	return nil
+}
+
+// reportDeletedNotes adds the notes of the previous collection that are missing in `collected`
+// to it, according to the configured mode.
+func (c *Exporter) reportDeletedNotes(collected *metrics.ZettelkastenMetrics) {
+	previous := c.notes
+	c.notes = maps.Clone(collected.Notes)
+
+	deleted := make(map[string]metrics.NoteMetrics)
+	for name, note := range previous {
+		if _, ok := collected.Notes[name]; !ok {
+			deleted[name] = note
+		}
+	}
+	if len(deleted) == 0 {
+		return
+	}
+	slog.Debug("Found deleted notes", slog.Any("notes", slices.Sorted(maps.Keys(deleted))))
+
+	switch c.config.DeletedNotesMode {
+	case config.DeletedNotesModeStale:
+		collected.StaleNotes = deleted
+	case config.DeletedNotesModeZero:
+		// Keeping the frontmatter, so that the zero values have the same labels as the note series
+		for name, note := range deleted {
+			collected.Notes[name] = metrics.NoteMetrics{Links: make(map[string]uint), Frontmatter: note.Frontmatter}
+		}
+	case config.DeletedNotesModeEvent:
+		collected.DeletedNotes = deleted
+	}
+}
+
+// scrapeMetrics collects all metrics from a Zettelkasten rooted in `root` at `collectionTime`.
+func (c *Exporter) scrapeMetrics(root fs.FS, collectionTime time.Time) (metrics.ZettelkastenMetrics, error) {
 	noteMetrics := make(map[string]metrics.NoteMetrics)
 
 	err := fs.WalkDir(root, ".", func(path string, dir fs.DirEntry, err error) error {
@@ -139,7 +253,9 @@ func (c *Exporter) scrapeMetrics(root fs.FS) (metrics.ZettelkastenMetrics, error
 			return nil
 		}
 
-		noteMetrics[nameFromFilename(path)] = CollectNoteMetrics(content)
+		metric := CollectNoteMetrics(content)
+		metric.Created, metric.Modified = noteDates(root, path, metric.Frontmatter, c.config.NoteCreatedKey)
+		noteMetrics[nameFromFilename(path)] = metric
 		slog.Debug("collected metrics from file", slog.String("path", path), slog.Any("d", dir), slog.Any("err", err))
 
 		return nil
@@ -150,17 +266,25 @@ func (c *Exporter) scrapeMetrics(root fs.FS) (metrics.ZettelkastenMetrics, error
 		return metrics.ZettelkastenMetrics{}, err
 	}
 
-	zettelkastenMetrics := aggregateMetrics(noteMetrics)
+	zettelkastenMetrics := aggregateMetrics(noteMetrics, collectionTime)
 	return zettelkastenMetrics, nil
 }
 
-// aggregateMetrics aggregates all individual note metrics into metrics in the context of a full Zettelkasten.
-func aggregateMetrics(noteMetrics map[string]metrics.NoteMetrics) metrics.ZettelkastenMetrics {
+// aggregateMetrics aggregates all individual note metrics into metrics in the context of a full
+// Zettelkasten collected at `collectionTime`.
+func aggregateMetrics(noteMetrics map[string]metrics.NoteMetrics, collectionTime time.Time) metrics.ZettelkastenMetrics {
 	zettelkastenMetrics := metrics.ZettelkastenMetrics{
 		NoteCount: 0,
 		LinkCount: 0,
 		WordCount: 0,
 		Notes:     make(map[string]metrics.NoteMetrics),
+		Tags:      make(map[string]metrics.TagMetrics),
+		Activity:  aggregateActivity(noteMetrics, collectionTime),
+	}
+
+	noteTags := make(map[string][]string, len(noteMetrics))
+	for name, metric := range noteMetrics {
+		noteTags[name] = expandTags(metric.Tags)
 	}
 
 	for name, metric := range noteMetrics {
@@ -168,12 +292,79 @@ func aggregateMetrics(noteMetrics map[string]metrics.NoteMetrics) metrics.Zettel
 		zettelkastenMetrics.NoteCount += 1
 		zettelkastenMetrics.LinkCount += metric.LinkCount
 		zettelkastenMetrics.WordCount += metric.WordCount
+		zettelkastenMetrics.OpenTaskCount += metric.OpenTaskCount
+		zettelkastenMetrics.CompletedTaskCount += metric.CompletedTaskCount
 		// Collect backlinks
 		for _, n := range noteMetrics {
 			metric.BacklinkCount += n.Links[name]
 		}
+		// Check the anchors of the links
+		metric = resolveAnchorLinks(name, metric, noteMetrics)
+		zettelkastenMetrics.AnchorLinkCount += metric.AnchorLinkCount
+		zettelkastenMetrics.BrokenHeadingLinkCount += metric.BrokenHeadingLinkCount
+		zettelkastenMetrics.BrokenBlockLinkCount += metric.BrokenBlockLinkCount
 		zettelkastenMetrics.Notes[name] = metric
+		// Aggregate tags
+		for _, tag := range noteTags[name] {
+			tagMetrics, ok := zettelkastenMetrics.Tags[tag]
+			if !ok {
+				tagMetrics.Links = make(map[string]uint)
+			}
+			tagMetrics.NoteCount += 1
+			tagMetrics.WordCount += metric.WordCount
+			tagMetrics.LinkCount += metric.LinkCount
+			for target, count := range metric.Links {
+				for _, targetTag := range noteTags[target] {
+					tagMetrics.Links[targetTag] += count
+				}
+			}
+			zettelkastenMetrics.Tags[tag] = tagMetrics
+		}
 	}
 
 	return zettelkastenMetrics
 }
+
+// resolveAnchorLinks counts the links of the note `name` to anchors of the notes in `noteMetrics`,
+// and how many of them point to a heading or block that doesn't exist in the linked note.
+//
+// Links to missing notes are broken regardless of their anchor, so they're not accounted for.
+func resolveAnchorLinks(name string, note metrics.NoteMetrics, noteMetrics map[string]metrics.NoteMetrics) metrics.NoteMetrics {
+	for target, anchors := range note.AnchorLinks {
+		if target == "" {
+			target = name
+		}
+		targetNote, ok := noteMetrics[target]
+		if !ok {
+			continue
+		}
+		for anchor, count := range anchors {
+			note.AnchorLinkCount += count
+			if slices.Contains(targetNote.Anchors, anchor) {
+				continue
+			}
+			if strings.HasPrefix(anchor, "^") {
+				note.BrokenBlockLinkCount += count
+			} else {
+				note.BrokenHeadingLinkCount += count
+			}
+		}
+	}
+	return note
+}
+
+// expandTags returns `tags` with the parents of the nested tags added, so that `project/alpha`
+// also counts as `project`.
+func expandTags(tags []string) []string {
+	expanded := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		for i, r := range tag {
+			if r == '/' {
+				expanded = append(expanded, tag[:i])
+			}
+		}
+		expanded = append(expanded, tag)
+	}
+	slices.Sort(expanded)
+	return slices.Compact(expanded)
+}
@@ -2,6 +2,8 @@ package exporter
 
 import (
 	"context"
+	"errors"
+	"io/fs"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -19,6 +21,7 @@ func TestStart(t *testing.T) {
 		"zettel/one.md": {Data: []byte(`
 ---
 created-at: "2024-05-29"
+tags: [project/alpha]
 ---
 
 Testing a note with no links. But there's a [markdown link](./dir1/two.md)
@@ -39,6 +42,7 @@ This note links to [[one]]
 		"zettel/dir1/dir2/three.md": {Data: []byte(`
 ---
 created-at: "2024-05-29"
+tags: project
 ---
 
 Links to [[one]] but also to [[two|two with an alias]]
@@ -49,9 +53,9 @@ created-at: "2024-05-29"
 ---
 Link to [one](one.md) and also a full link [[./dir1/dir2/three]] and a [[dir1/two.md|full link with .md]]
 
-- item
+- [ ] item
 -
-- another item
+- [x] another item
 		`)},
 		"ignoredir/foo":         {Data: []byte("Foo contents")},
 		"ignoredir/bar":         {Data: []byte("Bar contents")},
@@ -61,33 +65,57 @@ Link to [one](one.md) and also a full link [[./dir1/dir2/three]] and a [[dir1/tw
 	fakeStorage := storage.NewFakeStorage()
 	exporter := NewExporter(config.Config{IgnoreFiles: []string{"ignore.md", "ignoredir"}, CollectionInterval: time.Millisecond * 10}, zettelkasten.NewFakeZettelkasten(fs), &fakeStorage)
 	expected := metrics.ZettelkastenMetrics{
-		NoteCount: 4,
-		LinkCount: 8,
-		WordCount: 47,
+		NoteCount:          4,
+		LinkCount:          8,
+		WordCount:          47,
+		OpenTaskCount:      1,
+		CompletedTaskCount: 1,
 		Notes: map[string]metrics.NoteMetrics{
 			"one": {
 				Links:         map[string]uint{"two": 2},
 				LinkCount:     2,
 				WordCount:     13,
 				BacklinkCount: 3,
+				Frontmatter:   map[string]any{"created-at": "2024-05-29", "tags": []any{"project/alpha"}},
+				Tags:          []string{"project/alpha"},
 			},
 			"two": {
 				Links:         map[string]uint{"one": 1},
 				LinkCount:     1,
 				WordCount:     6,
 				BacklinkCount: 4,
+				Frontmatter:   map[string]any{"created-at": "2024-05-29"},
 			},
 			"three": {
 				Links:         map[string]uint{"one": 1, "two": 1},
 				LinkCount:     2,
 				WordCount:     10,
 				BacklinkCount: 1,
+				Frontmatter:   map[string]any{"created-at": "2024-05-29", "tags": "project"},
+				Tags:          []string{"project"},
 			},
 			"four": {
-				Links:         map[string]uint{"one": 1, "three": 1, "two": 1},
-				LinkCount:     3,
-				WordCount:     18,
-				BacklinkCount: 0,
+				Links:              map[string]uint{"one": 1, "three": 1, "two": 1},
+				LinkCount:          3,
+				WordCount:          18,
+				BacklinkCount:      0,
+				Frontmatter:        map[string]any{"created-at": "2024-05-29"},
+				OpenTaskCount:      1,
+				CompletedTaskCount: 1,
+			},
+		},
+		Tags: map[string]metrics.TagMetrics{
+			"project": {
+				NoteCount: 2,
+				WordCount: 23,
+				LinkCount: 4,
+				Links:     map[string]uint{"project": 1, "project/alpha": 1},
+			},
+			"project/alpha": {
+				NoteCount: 1,
+				WordCount: 13,
+				LinkCount: 2,
+				Links:     map[string]uint{},
 			},
 		},
 	}
@@ -101,3 +129,238 @@ Link to [one](one.md) and also a full link [[./dir1/dir2/three]] and a [[dir1/tw
 		assert.Equal(t, expected, metric)
 	}
 }
+
+// recoveringStorage is a storage holding writes until it's flushed, whose first flushes fail.
+type recoveringStorage struct {
+	storage.FakeStorage
+	failures int
+	flushed  int
+}
+
+func (r *recoveringStorage) Flush(ctx context.Context) error {
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("storage unavailable")
+	}
+	r.flushed = len(r.Metrics)
+	return nil
+}
+
+func TestStart_StorageRecovers(t *testing.T) {
+	fs := fstest.MapFS{"one.md": {Data: []byte("A note linking to [[two]]")}}
+	backend := &recoveringStorage{failures: 3}
+	buffered, err := storage.NewBufferedStorage(backend, t.TempDir(), 0, time.Millisecond, time.Millisecond)
+	require.NoError(t, err)
+	exporter := NewExporter(config.Config{CollectionInterval: time.Millisecond * 10}, zettelkasten.NewFakeZettelkasten(fs), buffered)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err = exporter.Start(ctx)
+
+	// Failed flushes are retried on the next collections instead of stopping the exporter
+	require.NoError(t, err)
+	assert.Zero(t, backend.failures)
+	assert.Greater(t, len(backend.Metrics), 3)
+	assert.Positive(t, backend.flushed)
+}
+
+func TestStart_HistoricalMetrics(t *testing.T) {
+	fs := fstest.MapFS{"one.md": {Data: []byte("A note linking to [[two]]")}}
+	data := []struct {
+		name     string
+		latest   time.Time
+		expected int
+	}{
+		{name: "empty storage", latest: time.Time{}, expected: 1},
+		{name: "history already in storage", latest: time.Now().Add(time.Hour), expected: 0},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			fakeStorage := storage.NewFakeStorage()
+			fakeStorage.Latest = d.latest
+			exporter := NewExporter(config.Config{CollectHistoricalMetrics: true, CollectionInterval: time.Hour}, zettelkasten.NewFakeZettelkasten(fs), &fakeStorage)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+			defer cancel()
+			err := exporter.Start(ctx)
+
+			require.NoError(t, err)
+			assert.Len(t, fakeStorage.Metrics, d.expected)
+			assert.Equal(t, 1, fakeStorage.Flushes)
+		})
+	}
+}
+
+func TestStart_CancelledHistoricalMetrics(t *testing.T) {
+	fs := fstest.MapFS{"one.md": {Data: []byte("A note linking to [[two]]")}}
+	fakeStorage := storage.NewFakeStorage()
+	exporter := NewExporter(config.Config{CollectHistoricalMetrics: true, CollectionInterval: time.Hour}, zettelkasten.NewFakeZettelkasten(fs), &fakeStorage)
+
+	// Shutting down during the history walk isn't an error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := exporter.Start(ctx)
+
+	require.NoError(t, err)
+	assert.Empty(t, fakeStorage.Metrics)
+}
+
+func TestStart_RunOnce(t *testing.T) {
+	fs := fstest.MapFS{"one.md": {Data: []byte("A note linking to [[two]]")}}
+	fakeStorage := storage.NewFakeStorage()
+	exporter := NewExporter(config.Config{RunOnce: true, CollectionInterval: time.Hour}, zettelkasten.NewFakeZettelkasten(fs), &fakeStorage)
+
+	// Returns without waiting for the collection interval or the context
+	err := exporter.Start(context.Background())
+
+	require.NoError(t, err)
+	assert.Len(t, fakeStorage.Metrics, 1)
+	assert.Equal(t, 1, fakeStorage.Flushes)
+}
+
+func TestCollectMetrics_DeletedNotes(t *testing.T) {
+	before := fstest.MapFS{
+		"one.md": {Data: []byte("A note linking to [[two]]")},
+		"two.md": {Data: []byte("---\ntype: idea\n---\nAnother note")},
+	}
+	after := fstest.MapFS{"one.md": {Data: []byte("A note linking to [[two]]")}}
+	data := []struct {
+		name     string
+		mode     string
+		expected func(collected *metrics.ZettelkastenMetrics)
+	}{
+		{name: "none", mode: config.DeletedNotesModeNone, expected: func(collected *metrics.ZettelkastenMetrics) {}},
+		{name: "stale", mode: config.DeletedNotesModeStale, expected: func(collected *metrics.ZettelkastenMetrics) {
+			collected.StaleNotes = map[string]metrics.NoteMetrics{"two": {Links: map[string]uint{}, WordCount: 2, BacklinkCount: 1, Frontmatter: map[string]any{"type": "idea"}}}
+		}},
+		{name: "zero", mode: config.DeletedNotesModeZero, expected: func(collected *metrics.ZettelkastenMetrics) {
+			collected.Notes["two"] = metrics.NoteMetrics{Links: map[string]uint{}, Frontmatter: map[string]any{"type": "idea"}}
+		}},
+		{name: "event", mode: config.DeletedNotesModeEvent, expected: func(collected *metrics.ZettelkastenMetrics) {
+			collected.DeletedNotes = map[string]metrics.NoteMetrics{"two": {Links: map[string]uint{}, WordCount: 2, BacklinkCount: 1, Frontmatter: map[string]any{"type": "idea"}}}
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			fakeStorage := storage.NewFakeStorage()
+			exporter := NewExporter(config.Config{DeletedNotesMode: d.mode, CollectionInterval: time.Hour}, zettelkasten.NewFakeZettelkasten(before), &fakeStorage)
+
+			require.NoError(t, exporter.collectMetrics(context.Background(), before, time.Now()))
+			require.NoError(t, exporter.collectMetrics(context.Background(), after, time.Now()))
+			require.NoError(t, exporter.collectMetrics(context.Background(), after, time.Now()))
+
+			expected := metrics.ZettelkastenMetrics{
+				NoteCount: 1,
+				LinkCount: 1,
+				WordCount: 5,
+				Notes: map[string]metrics.NoteMetrics{
+					"one": {Links: map[string]uint{"two": 1}, LinkCount: 1, WordCount: 5},
+				},
+				Tags: map[string]metrics.TagMetrics{},
+			}
+			d.expected(&expected)
+			require.Len(t, fakeStorage.Metrics, 3)
+			assert.Equal(t, expected, fakeStorage.Metrics[1])
+			// Deleted notes are only reported once
+			assert.Empty(t, fakeStorage.Metrics[2].StaleNotes)
+			assert.Empty(t, fakeStorage.Metrics[2].DeletedNotes)
+			assert.Len(t, fakeStorage.Metrics[2].Notes, 1)
+		})
+	}
+}
+
+// historyZettelkasten is a Zettelkasten walking a fixed history regardless of the timestamp to walk since.
+type historyZettelkasten struct {
+	zettelkasten.FakeZettelkasten
+	roots      []fs.FS
+	timestamps []time.Time
+}
+
+func (h historyZettelkasten) WalkHistory(ctx context.Context, since time.Time, walkFunc zettelkasten.WalkFunc) error {
+	for i, root := range h.roots {
+		err := walkFunc(root, h.timestamps[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestStart_RestoresLastStoredCollection(t *testing.T) {
+	before := fstest.MapFS{
+		"one.md": {Data: []byte("A note linking to [[two]]")},
+		"two.md": {Data: []byte("Another note")},
+	}
+	after := fstest.MapFS{"one.md": {Data: []byte("A note linking to [[two]]")}}
+	since := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	history := historyZettelkasten{
+		FakeZettelkasten: zettelkasten.NewFakeZettelkasten(after),
+		roots:            []fs.FS{before, after},
+		timestamps:       []time.Time{since, since.Add(time.Hour)},
+	}
+	fakeStorage := storage.NewFakeStorage()
+	fakeStorage.Latest = since
+	exporter := NewExporter(config.Config{
+		CollectHistoricalMetrics: true,
+		RunOnce:                  true,
+		DeletedNotesMode:         config.DeletedNotesModeEvent,
+		CollectionInterval:       time.Hour,
+	}, history, &fakeStorage)
+
+	require.NoError(t, exporter.Start(context.Background()))
+
+	// The collection already in storage is not written again, but its notes are compared with the next one
+	require.Len(t, fakeStorage.Metrics, 2)
+	assert.Contains(t, fakeStorage.Metrics[0].DeletedNotes, "two")
+	assert.Len(t, fakeStorage.Metrics[0].DeletedNotes, 1)
+	assert.Empty(t, fakeStorage.Metrics[1].DeletedNotes)
+}
+
+func TestCollectMetrics_NoteDates(t *testing.T) {
+	collectionTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	fs := fstest.MapFS{
+		"one.md": {Data: []byte("---\ncreated-at: 2024-05-31T12:00:00Z\n---\nA recent note"), ModTime: collectionTime.Add(-time.Hour)},
+		"two.md": {Data: []byte("An old note"), ModTime: collectionTime.Add(-60 * 24 * time.Hour)},
+	}
+	fakeStorage := storage.NewFakeStorage()
+	exporter := NewExporter(config.Config{NoteCreatedKey: "created-at", CollectionInterval: time.Hour}, zettelkasten.NewFakeZettelkasten(fs), &fakeStorage)
+
+	require.NoError(t, exporter.collectMetrics(context.Background(), fs, collectionTime))
+
+	require.Len(t, fakeStorage.Metrics, 1)
+	collected := fakeStorage.Metrics[0]
+	assert.True(t, collectionTime.Add(-24*time.Hour).Equal(collected.Notes["one"].Created))
+	assert.True(t, collectionTime.Add(-time.Hour).Equal(collected.Notes["one"].Modified))
+	assert.Equal(t, metrics.ActivityMetrics{
+		NoteCount:         2,
+		AverageNoteAge:    (24*time.Hour + 60*24*time.Hour) / 2,
+		CreatedLastDay:    0,
+		CreatedLastWeek:   1,
+		CreatedLastMonth:  1,
+		ModifiedLastDay:   1,
+		ModifiedLastWeek:  1,
+		ModifiedLastMonth: 1,
+	}, collected.Activity)
+}
+
+func TestCollectMetrics_AnchorLinks(t *testing.T) {
+	fs := fstest.MapFS{
+		"one.md": {Data: []byte("# Intro\n\nA paragraph with a block ^b1\n\n## Local\n\nBack to [the start](#intro) and [nowhere](#nowhere)")},
+		"two.md": {Data: []byte("[[one#Intro]] [[one#Missing]] [[one#^b1]] [[one#^nope]] [[three#Anything]] [[one]]")},
+	}
+	fakeStorage := storage.NewFakeStorage()
+	exporter := NewExporter(config.Config{CollectionInterval: time.Hour}, zettelkasten.NewFakeZettelkasten(fs), &fakeStorage)
+
+	require.NoError(t, exporter.collectMetrics(context.Background(), fs, time.Now()))
+
+	require.Len(t, fakeStorage.Metrics, 1)
+	collected := fakeStorage.Metrics[0]
+	one, two := collected.Notes["one"], collected.Notes["two"]
+	assert.Equal(t, []uint{2, 1, 0}, []uint{one.AnchorLinkCount, one.BrokenHeadingLinkCount, one.BrokenBlockLinkCount})
+	// The link to a missing note is not an anchor link
+	assert.Equal(t, []uint{4, 1, 1}, []uint{two.AnchorLinkCount, two.BrokenHeadingLinkCount, two.BrokenBlockLinkCount})
+	assert.Equal(t, uint(5), two.Links["one"])
+	assert.Equal(t, []uint{6, 2, 1}, []uint{collected.AnchorLinkCount, collected.BrokenHeadingLinkCount, collected.BrokenBlockLinkCount})
+}
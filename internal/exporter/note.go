@@ -1,26 +1,50 @@
 package exporter
 
 import (
+	"bytes"
+	"fmt"
 	"log/slog"
 	"net/url"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"unicode"
 
+	"github.com/BurntSushi/toml"
 	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
 	"github.com/yuin/goldmark/text"
 	"go.abhg.dev/goldmark/wikilink"
+	"gopkg.in/yaml.v3"
 )
 
+const (
+	yamlFrontmatterDelimiter = "---"
+	tomlFrontmatterDelimiter = "+++"
+)
+
+// inlineTagPattern matches the tags in the text of a note, which start with a `#` after a
+// whitespace and may be nested with slashes, such as `#project/alpha`. The `#` may follow
+// emphasis delimiters, such as in `_#idea_`.
+var inlineTagPattern = regexp.MustCompile(`(?:^|\s)[*_~=]*#([\p{L}\p{N}_/-]+)`)
+
+// blockIDPattern matches the ID at the end of a block that can be linked to with `[[note#^block-id]]`.
+var blockIDPattern = regexp.MustCompile(`(?:^|\s)\^([A-Za-z0-9-]+)\s*$`)
+
 var md = goldmark.New(
 	goldmark.WithExtensions(
 		&wikilink.Extender{},
+		extension.TaskList,
 	),
 )
 
 // CollectNoteMetrics collects all note metrics from a note with the given `content`.
+//
+// The frontmatter of the note is not counted as part of its words.
 func CollectNoteMetrics(content []byte) metrics.NoteMetrics {
 	noteMetrics := metrics.NoteMetrics{
 		Links:         make(map[string]uint),
@@ -28,20 +52,61 @@ func CollectNoteMetrics(content []byte) metrics.NoteMetrics {
 		WordCount:     0,
 		BacklinkCount: 0,
 	}
+	frontmatter, content, err := splitFrontmatter(content)
+	if err != nil {
+		slog.Warn("Error parsing note frontmatter", slog.Any("error", err))
+	}
+	noteMetrics.Frontmatter = frontmatter
+	tags := frontmatterTags(frontmatter)
+	var anchors []string
+	headingCounts := make(map[string]int)
 	reader := text.NewReader(content)
 	root := md.Parser().Parse(reader)
-	err := ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+	err = ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
 		if !entering {
 			return ast.WalkContinue, nil
 		}
 
 		linkTarget := ""
+		// The children of links are skipped, so that tags are not parsed in their text
+		status := ast.WalkSkipChildren
 
 		switch v := n.(type) {
+		case *ast.CodeSpan:
+			return ast.WalkSkipChildren, nil
+		case *extast.TaskCheckBox:
+			if v.IsChecked {
+				noteMetrics.CompletedTaskCount += 1
+			} else {
+				noteMetrics.OpenTaskCount += 1
+			}
+			return ast.WalkContinue, nil
+		case *ast.Heading:
+			anchor := headingAnchor(string(v.Lines().Value(content)))
+			// Repeated headings get a numbered suffix, such as `heading-1` for the second one
+			if count := headingCounts[anchor]; count > 0 {
+				headingCounts[anchor]++
+				anchor = fmt.Sprintf("%s-%d", anchor, count)
+			} else {
+				headingCounts[anchor] = 1
+			}
+			anchors = append(anchors, anchor)
+			return ast.WalkContinue, nil
+		case *ast.Text:
+			segment := v.Segment.Value(content)
+			tags = appendInlineTags(tags, content, v.Segment)
+			if match := blockIDPattern.FindSubmatch(segment); match != nil {
+				anchors = append(anchors, "^"+string(match[1]))
+			}
+			return ast.WalkContinue, nil
 		case *ast.Link:
 			linkTarget = string(v.Destination)
 		case *wikilink.Node:
 			linkTarget = string(v.Target)
+			// The wikilink parser splits the target at its last `#`
+			if len(v.Fragment) > 0 {
+				linkTarget = fmt.Sprintf("%s#%s", v.Target, v.Fragment)
+			}
 		case *ast.ListItem:
 			child := n.FirstChild()
 			if child == nil {
@@ -53,38 +118,231 @@ func CollectNoteMetrics(content []byte) metrics.NoteMetrics {
 				return ast.WalkContinue, nil
 			}
 
-			text := string(lines.Value(content))
-			fields := strings.FieldsFunc(string(text), func(r rune) bool { return unicode.IsSpace(r) || r == '\n' })
-			noteMetrics.WordCount += uint(len(fields))
+			text := withoutTaskCheckBox(child, string(lines.Value(content)))
+			noteMetrics.WordCount += countWords(text)
+			status = ast.WalkContinue
 		case *ast.Paragraph:
-			text := string(n.Lines().Value(content))
-			fields := strings.FieldsFunc(string(text), func(r rune) bool { return unicode.IsSpace(r) || r == '\n' })
-			noteMetrics.WordCount += uint(len(fields))
+			text := withoutTaskCheckBox(n, string(n.Lines().Value(content)))
+			noteMetrics.WordCount += countWords(text)
+			status = ast.WalkContinue
 		default:
 			return ast.WalkContinue, nil
 		}
 
-		if !isNoteTarget(linkTarget) {
-			return ast.WalkContinue, nil
+		note, anchor := splitLinkTarget(linkTarget)
+		if anchor != "" && (note == "" || isNoteTarget(note)) {
+			// Links within the note have an empty target name
+			targetName := note
+			if note != "" {
+				targetName = nameFromFilename(note)
+			}
+			if noteMetrics.AnchorLinks == nil {
+				noteMetrics.AnchorLinks = make(map[string]map[string]uint)
+			}
+			if noteMetrics.AnchorLinks[targetName] == nil {
+				noteMetrics.AnchorLinks[targetName] = make(map[string]uint)
+			}
+			noteMetrics.AnchorLinks[targetName][anchor] += 1
 		}
 
-		targetName := nameFromFilename(linkTarget)
+		if !isNoteTarget(note) {
+			return status, nil
+		}
+
+		targetName := nameFromFilename(note)
 		v, ok := noteMetrics.Links[targetName]
 		if !ok {
 			noteMetrics.Links[targetName] = 0
 		}
 		noteMetrics.Links[targetName] = v + 1
-		return ast.WalkContinue, nil
+		return status, nil
 	})
 	if err != nil {
 		slog.Error("Error walking note AST", slog.Any("error", err))
 	}
+	if len(tags) > 0 {
+		slices.Sort(tags)
+		noteMetrics.Tags = slices.Compact(tags)
+	}
+	if len(anchors) > 0 {
+		slices.Sort(anchors)
+		noteMetrics.Anchors = slices.Compact(anchors)
+	}
 	for _, linkCount := range noteMetrics.Links {
 		noteMetrics.LinkCount += linkCount
 	}
 	return noteMetrics
 }
 
+// countWords returns the number of words in `text`, leaving out the block IDs at the end of its lines.
+func countWords(text string) uint {
+	var count uint
+	for line := range strings.Lines(text) {
+		line = blockIDPattern.ReplaceAllString(line, "")
+		count += uint(len(strings.FieldsFunc(line, unicode.IsSpace)))
+	}
+	return count
+}
+
+// withoutTaskCheckBox removes the checkbox of a task list item, such as `[x]`, from the `text` of
+// the block `n`, so that it's not counted as a word.
+func withoutTaskCheckBox(n ast.Node, text string) string {
+	if _, ok := n.FirstChild().(*extast.TaskCheckBox); !ok {
+		return text
+	}
+	_, after, _ := strings.Cut(text, "]")
+	return after
+}
+
+// splitFrontmatter splits the YAML or TOML frontmatter at the start of `content` from the rest
+// of the note, returning its parsed fields and the content that follows it.
+//
+// YAML frontmatter is delimited by `---` lines, and TOML frontmatter by `+++` lines, the first
+// of which may only be preceded by blank lines. When the frontmatter can't be parsed, the error
+// is returned along with the whole `content`, since the delimiters may be thematic breaks.
+func splitFrontmatter(content []byte) (map[string]any, []byte, error) {
+	rest := bytes.TrimLeft(content, "\r\n")
+	opening, rest, _ := bytes.Cut(rest, []byte("\n"))
+	delimiter := string(bytes.TrimRight(opening, " \t\r"))
+	if delimiter != yamlFrontmatterDelimiter && delimiter != tomlFrontmatterDelimiter {
+		return nil, content, nil
+	}
+
+	var frontmatter []byte
+	for len(rest) > 0 {
+		var line []byte
+		line, rest, _ = bytes.Cut(rest, []byte("\n"))
+		if string(bytes.TrimRight(line, " \t\r")) == delimiter {
+			fields, err := parseFrontmatter(delimiter, frontmatter)
+			if err != nil {
+				return nil, content, err
+			}
+			return fields, rest, nil
+		}
+		frontmatter = append(append(frontmatter, line...), '\n')
+	}
+
+	// Without a closing delimiter, the note has no frontmatter
+	return nil, content, nil
+}
+
+// parseFrontmatter parses the fields of the `frontmatter` delimited by `delimiter`.
+func parseFrontmatter(delimiter string, frontmatter []byte) (map[string]any, error) {
+	fields := make(map[string]any)
+	var err error
+	if delimiter == tomlFrontmatterDelimiter {
+		err = toml.Unmarshal(frontmatter, &fields)
+	} else {
+		err = yaml.Unmarshal(frontmatter, &fields)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid frontmatter: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// appendInlineTags appends the tags starting in `segment` of `content` to `tags`.
+//
+// Since emphasis delimiters split the text of a note into several segments, the tags are
+// matched in the whole line, so that `#my_tag` isn't cut at its underscore and a tag glued to
+// emphasised text, such as in `**bold**#tag`, isn't taken. Trailing underscores are left out
+// of the tags, since they close the emphasis in `_#tag_`.
+func appendInlineTags(tags []string, content []byte, segment text.Segment) []string {
+	start := bytes.LastIndexByte(content[:segment.Start], '\n') + 1
+	end := len(content)
+	if i := bytes.IndexByte(content[segment.Stop:], '\n'); i >= 0 {
+		end = segment.Stop + i
+	}
+	line := content[start:end]
+	for _, match := range inlineTagPattern.FindAllSubmatchIndex(line, -1) {
+		// Tags starting in other segments are taken when walking them, or skipped along with
+		// the links and code spans holding them
+		hash := start + match[2] - 1
+		if hash < segment.Start || hash >= segment.Stop {
+			continue
+		}
+		tags = appendTag(tags, strings.TrimRight(string(line[match[2]:match[3]]), "_"))
+	}
+	return tags
+}
+
+// frontmatterTags returns the tags in the `tags` field of `frontmatter`, which is either a list
+// of tags or a string with the tags separated by commas or spaces.
+func frontmatterTags(frontmatter map[string]any) []string {
+	var values []string
+	switch v := frontmatter["tags"].(type) {
+	case string:
+		values = strings.FieldsFunc(v, func(r rune) bool { return unicode.IsSpace(r) || r == ',' })
+	case []any:
+		for _, value := range v {
+			values = append(values, fmt.Sprint(value))
+		}
+	}
+
+	var tags []string
+	for _, value := range values {
+		tags = appendTag(tags, value)
+	}
+	return tags
+}
+
+// appendTag appends `tag` to `tags` without its leading `#` and surrounding slashes, ignoring
+// it when nothing but digits is left, since `#123` is not a tag.
+func appendTag(tags []string, tag string) []string {
+	tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "#"), "/")
+	if strings.TrimFunc(tag, unicode.IsDigit) == "" {
+		return tags
+	}
+	return append(tags, tag)
+}
+
+// splitLinkTarget splits a link target such as `note.md#section` into the linked note and the
+// anchor within it, which is empty for links to a whole note and the note for links within the
+// same note, such as `#section`.
+//
+// Anchors are normalised with `headingAnchor`, except for block IDs such as `^block-id`. Since
+// wikilinks to nested headings like `[[note#Heading#Subheading]]` point to the last heading,
+// only the last part of the anchor is kept.
+func splitLinkTarget(target string) (string, string) {
+	note, anchor, found := strings.Cut(target, "#")
+	if !found {
+		return target, ""
+	}
+	if i := strings.LastIndex(anchor, "#"); i >= 0 {
+		anchor = anchor[i+1:]
+	}
+	// Anchors of markdown links are escaped like URLs, such as `#my%20heading`
+	if unescaped, err := url.PathUnescape(anchor); err == nil {
+		anchor = unescaped
+	}
+	anchor = strings.TrimSpace(anchor)
+	if strings.HasPrefix(anchor, "^") {
+		return note, anchor
+	}
+	return note, headingAnchor(anchor)
+}
+
+// headingAnchor returns the anchor of a heading with the given text, which is lower case with
+// spaces replaced by hyphens and punctuation removed, so that `My Heading!` becomes `my-heading`.
+//
+// This matches both the text of the heading used by wikilinks and the anchors generated for
+// headings by GitHub and most markdown renderers.
+func headingAnchor(heading string) string {
+	var anchor strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(heading)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_' || r == '-':
+			anchor.WriteRune(r)
+		case unicode.IsSpace(r):
+			anchor.WriteRune('-')
+		}
+	}
+	return anchor.String()
+}
+
 // isNoteTarget determines whether a link target points to a markdown note.
 func isNoteTarget(target string) bool {
 	// Empty strings are not valid targets
@@ -101,6 +101,225 @@ Another list:
 				BacklinkCount: 0,
 			},
 		},
+		{
+			name: "yaml frontmatter",
+			content: `---
+type: idea
+status: draft
+
+aliases:
+  - first alias
+  - second alias
+---
+Some words and a [[link]]`,
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{"link": 1},
+				LinkCount:     1,
+				WordCount:     5,
+				BacklinkCount: 0,
+				Frontmatter: map[string]any{
+					"type":    "idea",
+					"status":  "draft",
+					"aliases": []any{"first alias", "second alias"},
+				},
+			},
+		},
+		{
+			name: "toml frontmatter",
+			content: `+++
+type = "idea"
+rating = 3
++++
+
+Some words`,
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     2,
+				BacklinkCount: 0,
+				Frontmatter:   map[string]any{"type": "idea", "rating": int64(3)},
+			},
+		},
+		{
+			name: "invalid frontmatter",
+			content: `---
+type: [idea
+---
+Some words`,
+			// The delimiters are then a thematic break and the underline of a heading
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     2,
+				BacklinkCount: 0,
+				Anchors:       []string{"type-idea"},
+			},
+		},
+		{
+			name:    "thematic breaks around a paragraph",
+			content: "---\n\nText after a rule one two three\n\n---\nmore words here\n",
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     10,
+				BacklinkCount: 0,
+			},
+		},
+		{
+			name:    "frontmatter after a blank line",
+			content: "\n---\ntype: idea\n---\nSome words",
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     2,
+				BacklinkCount: 0,
+				Frontmatter:   map[string]any{"type": "idea"},
+			},
+		},
+		{
+			name: "thematic break without frontmatter",
+			content: `---
+Some words`,
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     2,
+				BacklinkCount: 0,
+			},
+		},
+		{
+			name: "tags",
+			content: `---
+tags: [idea, "#project/alpha"]
+---
+# Heading about #go
+
+Some #project/beta words#not-a-tag and #123 [link #ignored](target.md) ` + "`#code`" + `
+
+- #list-tag item`,
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{"target": 1},
+				LinkCount:     1,
+				WordCount:     10,
+				BacklinkCount: 0,
+				Frontmatter:   map[string]any{"tags": []any{"idea", "#project/alpha"}},
+				Tags:          []string{"go", "idea", "list-tag", "project/alpha", "project/beta"},
+				Anchors:       []string{"heading-about-go"},
+			},
+		},
+		{
+			name:    "tags with emphasis",
+			content: "Some _#my_tag_ and *#emphasis* **#strong** #snake_case_tag but not **bold**#glued or word*#glued*",
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     11,
+				BacklinkCount: 0,
+				Tags:          []string{"emphasis", "my_tag", "snake_case_tag", "strong"},
+			},
+		},
+		{
+			name:    "tags in headings",
+			content: "# #heading-tag title\n\n## Heading #second\n\n#not-a-heading words",
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     2,
+				BacklinkCount: 0,
+				Tags:          []string{"heading-tag", "not-a-heading", "second"},
+				Anchors:       []string{"heading-second", "heading-tag-title"},
+			},
+		},
+		{
+			name:    "URL fragments",
+			content: "See https://example.com/page#fragment, <https://example.com/#anchor> and example.com/#/route",
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     5,
+				BacklinkCount: 0,
+			},
+		},
+		{
+			name: "frontmatter tags string",
+			content: `---
+tags: idea, project/alpha
+---
+Some words #idea`,
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     3,
+				BacklinkCount: 0,
+				Frontmatter:   map[string]any{"tags": "idea, project/alpha"},
+				Tags:          []string{"idea", "project/alpha"},
+			},
+		},
+		{
+			name: "anchors",
+			content: `# Main Heading
+
+Some text with a block ^intro-block
+
+## Sub heading!
+
+- [[other#Some Heading]] and [[other#^block-1|alias]]
+- [[#Main Heading]] [[#^intro-block]]
+- [text](other.md#some-heading) and [text](other#Missing%20Heading)
+- [[other#Top#Nested Heading]]
+- [external](https://example.com/page#section)`,
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{"other": 5},
+				LinkCount:     5,
+				WordCount:     18,
+				BacklinkCount: 0,
+				Anchors:       []string{"^intro-block", "main-heading", "sub-heading"},
+				AnchorLinks: map[string]map[string]uint{
+					"":      {"main-heading": 1, "^intro-block": 1},
+					"other": {"some-heading": 2, "^block-1": 1, "missing-heading": 1, "nested-heading": 1},
+				},
+			},
+		},
+		{
+			name: "repeated headings and block ids",
+			content: `# Notes
+
+First paragraph here ^first
+
+## Notes
+
+- An item with a block ^item
+
+## Notes`,
+			expected: metrics.NoteMetrics{
+				Links:         map[string]uint{},
+				LinkCount:     0,
+				WordCount:     8,
+				BacklinkCount: 0,
+				Anchors:       []string{"^first", "^item", "notes", "notes-1", "notes-2"},
+			},
+		},
+		{
+			name: "tasks",
+			content: `Tasks for today:
+
+- [ ] open task
+- [x] done with [[link]]
+  - [X] nested task
+- plain item
+
+1. [ ] numbered task
+
+Not [ ] a task`,
+			expected: metrics.NoteMetrics{
+				Links:              map[string]uint{"link": 1},
+				LinkCount:          1,
+				WordCount:          19,
+				BacklinkCount:      0,
+				OpenTaskCount:      2,
+				CompletedTaskCount: 2,
+			},
+		},
 		{
 			name: "long note",
 			content: `
@@ -1,11 +1,32 @@
 package metrics
 
+import "time"
+
 // ZettelkastenMetrics represents the aggregated metrics of a Zettelkasten.
 type ZettelkastenMetrics struct {
 	NoteCount uint
 	LinkCount uint
 	WordCount uint
-	Notes     map[string]NoteMetrics
+	// OpenTaskCount and CompletedTaskCount are the number of open and completed tasks in all notes.
+	OpenTaskCount      uint
+	CompletedTaskCount uint
+	// AnchorLinkCount, BrokenHeadingLinkCount and BrokenBlockLinkCount are the number of links to
+	// anchors and of broken links to headings and blocks in all notes.
+	AnchorLinkCount        uint
+	BrokenHeadingLinkCount uint
+	BrokenBlockLinkCount   uint
+	Notes                  map[string]NoteMetrics
+	// Tags holds the aggregated metrics of the notes with each tag. Notes with a nested tag such
+	// as `project/alpha` are also aggregated under its parent tags.
+	Tags map[string]TagMetrics
+	// Activity holds the aggregated creation and modification dates of the notes.
+	Activity ActivityMetrics
+	// StaleNotes holds the metrics of the previous collection of the deleted notes whose series
+	// should be marked as stale, by name.
+	StaleNotes map[string]NoteMetrics
+	// DeletedNotes holds the metrics of the previous collection of the deleted notes for which a
+	// deletion event should be written, by name.
+	DeletedNotes map[string]NoteMetrics
 }
 
 // NoteMetrics represents the metrics of a single Zettelkasten note.
@@ -14,4 +35,56 @@ type NoteMetrics struct {
 	LinkCount     uint
 	WordCount     uint
 	BacklinkCount uint
+	// Frontmatter holds the fields of the YAML or TOML frontmatter of the note, if any.
+	Frontmatter map[string]any
+	// Tags holds the sorted tags of the note, from both its content and its frontmatter.
+	Tags []string
+	// OpenTaskCount and CompletedTaskCount are the number of unchecked and checked items of the
+	// task lists in the note, such as `- [ ] task` and `- [x] task`.
+	OpenTaskCount      uint
+	CompletedTaskCount uint
+	// Anchors holds the sorted anchors that can be linked to in the note, which are its headings
+	// normalised like `my-heading` and its block IDs like `^block-id`.
+	Anchors []string
+	// AnchorLinks holds the number of links to each anchor by the name of the linked note, where
+	// an empty name stands for links within the note itself.
+	AnchorLinks map[string]map[string]uint
+	// AnchorLinkCount is the number of links to anchors of existing notes, and BrokenHeadingLinkCount
+	// and BrokenBlockLinkCount the number of them whose heading or block doesn't exist in the linked note.
+	AnchorLinkCount        uint
+	BrokenHeadingLinkCount uint
+	BrokenBlockLinkCount   uint
+	// Created is when the note was created, or the zero time when it's unknown.
+	Created time.Time
+	// Modified is when the note was last modified, or the zero time when it's unknown.
+	Modified time.Time
+}
+
+// TagMetrics represents the aggregated metrics of the notes with a tag.
+type TagMetrics struct {
+	NoteCount uint
+	WordCount uint
+	LinkCount uint
+	// Links holds the number of links from the notes with the tag to the notes with each tag,
+	// including the tag itself.
+	Links map[string]uint
+}
+
+// ActivityMetrics represents the aggregated creation and modification dates of the notes of a
+// Zettelkasten relative to the collection. Notes with unknown dates are not accounted for.
+type ActivityMetrics struct {
+	// NoteCount is the number of notes with a known creation date.
+	NoteCount uint
+	// AverageNoteAge is the average time between the creation of the notes and the collection.
+	AverageNoteAge time.Duration
+	// CreatedLastDay, CreatedLastWeek and CreatedLastMonth are the number of notes created in
+	// the last 1, 7 and 30 days before the collection.
+	CreatedLastDay   uint
+	CreatedLastWeek  uint
+	CreatedLastMonth uint
+	// ModifiedLastDay, ModifiedLastWeek and ModifiedLastMonth are the number of notes modified in
+	// the last 1, 7 and 30 days before the collection.
+	ModifiedLastDay   uint
+	ModifiedLastWeek  uint
+	ModifiedLastMonth uint
 }
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// The extension of the files holding pending batches.
+const batchExtension = ".json"
+
+// batch represents a single write of metrics pending in the buffer.
+type batch struct {
+	Timestamp time.Time
+	Metrics   metrics.ZettelkastenMetrics
+}
+
+// BufferedStorage represents a storage that persists writes to a local
+// directory before forwarding them to another storage.
+//
+// Pending writes are forwarded in order, retrying with exponential backoff.
+// When the underlying storage is still failing after all retries, the writes
+// are kept on disk and replayed on the following writes, even across restarts.
+// Writes to an underlying storage that can be flushed are kept on disk until it's
+// flushed, since it may hold them in memory until then.
+type BufferedStorage struct {
+	storage        Storage
+	directory      string
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	mu             *sync.Mutex
+	nextSequence   uint64
+	retryAt        time.Time
+	// delivered holds the sequences of the batches written to the underlying storage
+	// that are kept in the buffer until it's flushed.
+	delivered []uint64
+}
+
+// NewBufferedStorage creates a new `BufferedStorage` forwarding writes to
+// `storage` and persisting the pending ones in `directory`.
+func NewBufferedStorage(storage Storage, directory string, maxRetries int, initialBackoff, maxBackoff time.Duration) (*BufferedStorage, error) {
+	err := os.MkdirAll(directory, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating buffer directory: %w", err)
+	}
+	b := &BufferedStorage{
+		storage:        storage,
+		directory:      directory,
+		maxRetries:     maxRetries,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		mu:             &sync.Mutex{},
+	}
+
+	sequences, err := b.pendingSequences()
+	if err != nil {
+		return nil, err
+	}
+	if len(sequences) > 0 {
+		b.nextSequence = sequences[len(sequences)-1] + 1
+		slog.Info("Found pending batches in buffer, will replay them on the next write", slog.Int("batches", len(sequences)), slog.String("directory", directory))
+	}
+	return b, nil
+}
+
+// WriteMetrics persists `zettelkastenMetrics` in the buffer and forwards all
+// pending batches to the underlying storage.
+//
+// An error is only returned if the metrics cannot be persisted in the buffer.
+func (b *BufferedStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.persist(batch{Timestamp: timestamp, Metrics: zettelkastenMetrics})
+	if err != nil {
+		slog.Error("Error persisting metrics in buffer", slog.Any("error", err))
+		return err
+	}
+
+	if time.Now().Before(b.retryAt) {
+		slog.Debug("Storage is unavailable, keeping metrics in buffer", slog.Time("retry_at", b.retryAt))
+		return nil
+	}
+	return b.forwardPending(ctx)
+}
+
+// LatestTimestamp returns the latest timestamp of the underlying storage or of the
+// last pending batch, whichever is newer, since pending batches are eventually written.
+func (b *BufferedStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	latest, err := b.storage.LatestTimestamp(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sequences, err := b.pendingSequences()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(sequences) == 0 {
+		return latest, nil
+	}
+
+	content, err := os.ReadFile(b.batchPath(sequences[len(sequences)-1]))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading batch file: %w", err)
+	}
+	var pending batch
+	err = json.Unmarshal(content, &pending)
+	if err != nil {
+		// The invalid batch is set aside on the next write
+		return latest, nil
+	}
+	if pending.Timestamp.After(latest) {
+		return pending.Timestamp, nil
+	}
+	return latest, nil
+}
+
+// Flush sends the pending writes of the underlying storage, retrying with exponential backoff,
+// and removes the batches written to it from the buffer.
+//
+// Like failed writes, failed flushes are only logged. The batches are kept in the buffer, so
+// that they are replayed after a restart, while the underlying storage retries them on the
+// next flush.
+func (b *BufferedStorage) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Now().Before(b.retryAt) {
+		slog.Debug("Storage is unavailable, keeping written metrics in buffer", slog.Time("retry_at", b.retryAt))
+		return nil
+	}
+	err := b.withRetries(ctx, func() error { return Flush(ctx, b.storage) })
+	if err != nil {
+		b.retryAt = time.Now().Add(b.maxBackoff)
+		slog.Warn("Error flushing storage, keeping the written metrics in buffer", slog.Any("error", err), slog.Int("batches", len(b.delivered)), slog.Time("retry_at", b.retryAt))
+		return nil
+	}
+	for len(b.delivered) > 0 {
+		err = os.Remove(b.batchPath(b.delivered[0]))
+		if err != nil {
+			return fmt.Errorf("error removing batch file: %w", err)
+		}
+		b.delivered = b.delivered[1:]
+	}
+	return nil
+}
+
+// isFlusher reports whether the underlying storage holds writes until it's flushed.
+func (b *BufferedStorage) isFlusher() bool {
+	return isFlusher(b.storage)
+}
+
+// Close releases the connections of the underlying storage.
+func (b *BufferedStorage) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Close(b.storage)
+}
+
+// persist atomically writes `pending` to a new file in the buffer directory.
+func (b *BufferedStorage) persist(pending batch) error {
+	content, err := json.Marshal(pending)
+	if err != nil {
+		return fmt.Errorf("error encoding batch: %w", err)
+	}
+
+	path := b.batchPath(b.nextSequence)
+	temporaryPath := path + ".tmp"
+	f, err := os.Create(temporaryPath)
+	if err != nil {
+		return fmt.Errorf("error creating batch file: %w", err)
+	}
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		_ = os.Remove(temporaryPath)
+		return fmt.Errorf("error writing batch file: %w", err)
+	}
+	err = os.Rename(temporaryPath, path)
+	if err != nil {
+		return fmt.Errorf("error renaming batch file: %w", err)
+	}
+
+	b.nextSequence++
+	return nil
+}
+
+// forwardPending forwards all pending batches to the underlying storage in order.
+func (b *BufferedStorage) forwardPending(ctx context.Context) error {
+	sequences, err := b.pendingSequences()
+	if err != nil {
+		return err
+	}
+
+	for i, sequence := range sequences {
+		if slices.Contains(b.delivered, sequence) {
+			continue
+		}
+		path := b.batchPath(sequence)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading batch file: %w", err)
+		}
+		var pending batch
+		err = json.Unmarshal(content, &pending)
+		if err != nil {
+			slog.Error("Invalid batch in buffer, setting it aside", slog.Any("error", err), slog.String("path", path))
+			err = os.Rename(path, path+".invalid")
+			if err != nil {
+				return fmt.Errorf("error setting invalid batch aside: %w", err)
+			}
+			continue
+		}
+
+		err = b.withRetries(ctx, func() error { return b.storage.WriteMetrics(ctx, pending.Metrics, pending.Timestamp) })
+		if err != nil {
+			b.retryAt = time.Now().Add(b.maxBackoff)
+			slog.Warn("Error writing buffered metrics to storage, keeping them in buffer", slog.Any("error", err), slog.Int("pending", len(sequences)-i), slog.Time("retry_at", b.retryAt))
+			return nil
+		}
+
+		if isFlusher(b.storage) {
+			b.delivered = append(b.delivered, sequence)
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return fmt.Errorf("error removing batch file: %w", err)
+		}
+	}
+	return nil
+}
+
+// withRetries calls `send` until it succeeds, retrying with exponential backoff until all
+// retries fail or `ctx` is cancelled.
+func (b *BufferedStorage) withRetries(ctx context.Context, send func() error) error {
+	backoff := b.initialBackoff
+	var err error
+	for attempt := 0; attempt <= b.maxRetries; attempt++ {
+		if attempt > 0 {
+			slog.Info("Retrying storage", slog.Int("attempt", attempt), slog.Duration("backoff", backoff))
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			}
+			backoff = min(backoff*2, b.maxBackoff)
+		}
+		err = send()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// pendingSequences returns the sorted sequence numbers of all batches in the buffer.
+func (b *BufferedStorage) pendingSequences() ([]uint64, error) {
+	entries, err := os.ReadDir(b.directory)
+	if err != nil {
+		return nil, fmt.Errorf("error reading buffer directory: %w", err)
+	}
+	sequences := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != batchExtension {
+			continue
+		}
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(name, batchExtension), 10, 64)
+		if err != nil {
+			slog.Warn("Ignoring unknown file in buffer directory", slog.String("name", name))
+			continue
+		}
+		sequences = append(sequences, sequence)
+	}
+	slices.Sort(sequences)
+	return sequences, nil
+}
+
+// batchPath returns the path of the file holding the batch with `sequence`.
+func (b *BufferedStorage) batchPath(sequence uint64) string {
+	return filepath.Join(b.directory, fmt.Sprintf("%020d%s", sequence, batchExtension))
+}
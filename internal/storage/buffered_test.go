@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStorage is a storage that fails a given number of writes before succeeding.
+type flakyStorage struct {
+	failures   int
+	Metrics    []metrics.ZettelkastenMetrics
+	Timestamps []time.Time
+}
+
+func (f *flakyStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("storage unavailable")
+	}
+	f.Metrics = append(f.Metrics, zettelkastenMetrics)
+	f.Timestamps = append(f.Timestamps, timestamp)
+	return nil
+}
+
+func (f *flakyStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	if len(f.Timestamps) == 0 {
+		return time.Time{}, nil
+	}
+	return f.Timestamps[len(f.Timestamps)-1], nil
+}
+
+// batchingStorage is a storage that holds writes in memory until it's flushed.
+type batchingStorage struct {
+	flakyStorage
+	flushFailures int
+	pending       []metrics.ZettelkastenMetrics
+}
+
+func (b *batchingStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	b.pending = append(b.pending, zettelkastenMetrics)
+	return nil
+}
+
+func (b *batchingStorage) Flush(ctx context.Context) error {
+	if b.flushFailures > 0 {
+		b.flushFailures--
+		return errors.New("storage unavailable")
+	}
+	b.Metrics = append(b.Metrics, b.pending...)
+	b.pending = nil
+	return nil
+}
+
+func TestBufferedStorage_Retries(t *testing.T) {
+	directory := t.TempDir()
+	inner := &flakyStorage{failures: 2}
+	storage, err := NewBufferedStorage(inner, directory, 3, time.Millisecond, time.Millisecond*5)
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 1}, timestamp)
+	require.NoError(t, err)
+
+	assert.Equal(t, []metrics.ZettelkastenMetrics{{NoteCount: 1}}, inner.Metrics)
+	assert.True(t, timestamp.Equal(inner.Timestamps[0]))
+	entries, err := os.ReadDir(directory)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestBufferedStorage_ReplayAfterRestart(t *testing.T) {
+	directory := t.TempDir()
+	unavailable := &flakyStorage{failures: 100}
+	storage, err := NewBufferedStorage(unavailable, directory, 1, time.Millisecond, time.Millisecond)
+	require.NoError(t, err)
+
+	for i := range 3 {
+		err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: uint(i)}, time.Unix(int64(i), 0))
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond * 2)
+	}
+	assert.Empty(t, unavailable.Metrics)
+	entries, err := os.ReadDir(directory)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	available := &flakyStorage{}
+	storage, err = NewBufferedStorage(available, directory, 1, time.Millisecond, time.Millisecond)
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 3}, time.Unix(3, 0))
+	require.NoError(t, err)
+
+	expected := []metrics.ZettelkastenMetrics{{NoteCount: 0}, {NoteCount: 1}, {NoteCount: 2}, {NoteCount: 3}}
+	assert.Equal(t, expected, available.Metrics)
+	entries, err = os.ReadDir(directory)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestBufferedStorage_LatestTimestamp(t *testing.T) {
+	directory := t.TempDir()
+	inner := &flakyStorage{}
+	storage, err := NewBufferedStorage(inner, directory, 0, time.Millisecond, time.Hour)
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 1}, timestamp))
+	latest, err := storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, timestamp, latest)
+
+	// Pending batches count as written, since they are eventually flushed
+	inner.failures = 1
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 2}, timestamp.Add(time.Hour)))
+	assert.Len(t, inner.Timestamps, 1)
+	latest, err = storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.True(t, timestamp.Add(time.Hour).Equal(latest))
+}
+
+func TestBufferedStorage_Cancelled(t *testing.T) {
+	directory := t.TempDir()
+	inner := &flakyStorage{failures: 100}
+	storage, err := NewBufferedStorage(inner, directory, 10, time.Hour, time.Hour)
+	require.NoError(t, err)
+
+	// Retries stop waiting for the backoff once the context is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	start := time.Now()
+	err = storage.WriteMetrics(ctx, metrics.ZettelkastenMetrics{NoteCount: 1}, time.Unix(1, 0))
+	require.NoError(t, err)
+
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Empty(t, inner.Metrics)
+	entries, err := os.ReadDir(directory)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestBufferedStorage_KeepsBatchesUntilFlushed(t *testing.T) {
+	directory := t.TempDir()
+	inner := &batchingStorage{flushFailures: 1}
+	storage, err := NewBufferedStorage(inner, directory, 0, time.Millisecond, time.Millisecond)
+	require.NoError(t, err)
+
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 1}, time.Unix(1, 0)))
+	entries, err := os.ReadDir(directory)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// The batch is kept when flushing fails, and not written again on the next write
+	require.NoError(t, storage.Flush(context.Background()))
+	entries, err = os.ReadDir(directory)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	time.Sleep(time.Millisecond * 2)
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 2}, time.Unix(2, 0)))
+	assert.Len(t, inner.pending, 2)
+
+	time.Sleep(time.Millisecond * 2)
+	require.NoError(t, storage.Flush(context.Background()))
+	assert.Equal(t, []metrics.ZettelkastenMetrics{{NoteCount: 1}, {NoteCount: 2}}, inner.Metrics)
+	entries, err = os.ReadDir(directory)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Batches that were never flushed are replayed after a restart
+	inner.flushFailures = 1
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 3}, time.Unix(3, 0)))
+	require.NoError(t, storage.Flush(context.Background()))
+	restarted := &flakyStorage{}
+	storage, err = NewBufferedStorage(restarted, directory, 0, time.Millisecond, time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 4}, time.Unix(4, 0)))
+	assert.Equal(t, []metrics.ZettelkastenMetrics{{NoteCount: 3}, {NoteCount: 4}}, restarted.Metrics)
+}
+
+func TestBufferedStorage_FlushRetries(t *testing.T) {
+	directory := t.TempDir()
+	inner := &batchingStorage{flushFailures: 2}
+	storage, err := NewBufferedStorage(inner, directory, 2, time.Millisecond, time.Millisecond*5)
+	require.NoError(t, err)
+
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 1}, time.Unix(1, 0)))
+	require.NoError(t, storage.Flush(context.Background()))
+
+	assert.Equal(t, []metrics.ZettelkastenMetrics{{NoteCount: 1}}, inner.Metrics)
+	entries, err := os.ReadDir(directory)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestBufferedStorage_DeliversWithoutFlushing(t *testing.T) {
+	directory := t.TempDir()
+	inner := NewTimeoutStorage(&flakyStorage{}, time.Second)
+	storage, err := NewBufferedStorage(inner, directory, 0, time.Millisecond, time.Millisecond)
+	require.NoError(t, err)
+
+	// Wrapping a storage that writes right away doesn't keep the batches until a flush
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 1}, time.Unix(1, 0)))
+	entries, err := os.ReadDir(directory)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// clickHouseSchema returns the statements creating the tables of the fixed schema named by
+// `naming`, since the HTTP interface only accepts a single statement per request. Timestamps are
+// stored with second precision.
+//
+// The tables use the ReplacingMergeTree engine ordered by the same keys as the primary keys of
+// the SQLite schema, so rows written again for the same timestamp are deduplicated on merges.
+func clickHouseSchema(naming MetricNaming) []string {
+	statements := make([]string, 0, len(sqlTables))
+	for _, table := range sqlTables {
+		columns := []string{quoteClickHouseIdentifier("timestamp") + " DateTime('UTC')"}
+		for _, key := range table.keys {
+			columns = append(columns, quoteClickHouseIdentifier(key)+" String")
+		}
+		for _, value := range table.values {
+			columns = append(columns, quoteClickHouseIdentifier(value)+" UInt64")
+		}
+		for _, label := range naming.labelNames() {
+			columns = append(columns, quoteClickHouseIdentifier(label)+" String")
+		}
+		if table.note {
+			for _, label := range naming.noteLabelNames() {
+				columns = append(columns, quoteClickHouseIdentifier(label)+" Nullable(String)")
+			}
+		}
+		keys := table.keyColumns("timestamp", naming)
+		for i, key := range keys {
+			keys[i] = quoteClickHouseIdentifier(key)
+		}
+		statements = append(statements, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n) ENGINE = ReplacingMergeTree ORDER BY (%s)",
+			quoteClickHouseIdentifier(naming.measurement(table.measurement)), strings.Join(columns, ",\n\t"), strings.Join(keys, ", ")))
+	}
+	return statements
+}
+
+// clickHouseLabelledRow represents a row of any table along with the values of its label columns.
+type clickHouseLabelledRow struct {
+	row    any
+	labels map[string]string
+}
+
+// MarshalJSON encodes the row and its labels as a single object.
+func (r clickHouseLabelledRow) MarshalJSON() ([]byte, error) {
+	row, err := json.Marshal(r.row)
+	if err != nil || len(r.labels) == 0 {
+		return row, err
+	}
+	labels, err := json.Marshal(r.labels)
+	if err != nil {
+		return nil, err
+	}
+	// Joining the members of both objects, which are never empty
+	return slices.Concat(row[:len(row)-1], []byte(","), labels[1:]), nil
+}
+
+// clickHouseTotalRow represents a row of the `total` table.
+type clickHouseTotalRow struct {
+	Timestamp int64 `json:"timestamp"`
+	NoteCount uint  `json:"note_count"`
+	LinkCount uint  `json:"link_count"`
+	WordCount uint  `json:"word_count"`
+}
+
+// clickHouseNoteRow represents a row of the `notes` table.
+type clickHouseNoteRow struct {
+	Timestamp     int64  `json:"timestamp"`
+	Name          string `json:"name"`
+	LinkCount     uint   `json:"link_count"`
+	WordCount     uint   `json:"word_count"`
+	BacklinkCount uint   `json:"backlink_count"`
+}
+
+// clickHouseLinkRow represents a row of the `links` and `tag_links` tables.
+type clickHouseLinkRow struct {
+	Timestamp int64  `json:"timestamp"`
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	Count     uint   `json:"count"`
+}
+
+// clickHouseDeletedNoteRow represents a row of the `deleted_notes` table.
+type clickHouseDeletedNoteRow struct {
+	Timestamp int64  `json:"timestamp"`
+	Name      string `json:"name"`
+}
+
+// clickHouseTagRow represents a row of the `tags` table.
+type clickHouseTagRow struct {
+	Timestamp int64  `json:"timestamp"`
+	Tag       string `json:"tag"`
+	NoteCount uint   `json:"note_count"`
+	WordCount uint   `json:"word_count"`
+	LinkCount uint   `json:"link_count"`
+}
+
+// clickHouseTasksRow represents a row of the `tasks` table.
+type clickHouseTasksRow struct {
+	Timestamp      int64 `json:"timestamp"`
+	OpenCount      uint  `json:"open_count"`
+	CompletedCount uint  `json:"completed_count"`
+}
+
+// clickHouseNoteTasksRow represents a row of the `note_tasks` table.
+type clickHouseNoteTasksRow struct {
+	Timestamp      int64  `json:"timestamp"`
+	Name           string `json:"name"`
+	OpenCount      uint   `json:"open_count"`
+	CompletedCount uint   `json:"completed_count"`
+}
+
+// clickHouseAnchorsRow represents a row of the `anchors` table.
+type clickHouseAnchorsRow struct {
+	Timestamp          int64 `json:"timestamp"`
+	LinkCount          uint  `json:"link_count"`
+	BrokenHeadingCount uint  `json:"broken_heading_count"`
+	BrokenBlockCount   uint  `json:"broken_block_count"`
+}
+
+// clickHouseNoteAnchorsRow represents a row of the `note_anchors` table.
+type clickHouseNoteAnchorsRow struct {
+	Timestamp          int64  `json:"timestamp"`
+	Name               string `json:"name"`
+	LinkCount          uint   `json:"link_count"`
+	BrokenHeadingCount uint   `json:"broken_heading_count"`
+	BrokenBlockCount   uint   `json:"broken_block_count"`
+}
+
+// clickHouseActivityRow represents a row of the `activity` table.
+type clickHouseActivityRow struct {
+	Timestamp             int64 `json:"timestamp"`
+	NoteCount             uint  `json:"note_count"`
+	AverageNoteAgeSeconds uint  `json:"average_note_age_seconds"`
+	CreatedLastDay        uint  `json:"created_last_day"`
+	CreatedLastWeek       uint  `json:"created_last_week"`
+	CreatedLastMonth      uint  `json:"created_last_month"`
+	ModifiedLastDay       uint  `json:"modified_last_day"`
+	ModifiedLastWeek      uint  `json:"modified_last_week"`
+	ModifiedLastMonth     uint  `json:"modified_last_month"`
+}
+
+// ClickHouseStorage represents the implementation of a metric storage using the HTTP interface of ClickHouse.
+type ClickHouseStorage struct {
+	baseUrl  string
+	database string
+	client   *http.Client
+	options  HTTPOptions
+	naming   MetricNaming
+}
+
+// NewClickHouseStorage creates a new `ClickHouseStorage` writing to `database` in the ClickHouse
+// server at `baseUrl`, creating the database and its schema if needed.
+//
+// The tables are named and labelled according to `naming`.
+func NewClickHouseStorage(ctx context.Context, baseUrl, database string, options HTTPOptions, naming MetricNaming) (ClickHouseStorage, error) {
+	_, err := url.Parse(baseUrl)
+	if err != nil {
+		return ClickHouseStorage{}, fmt.Errorf("error parsing ClickHouse URL: %w", err)
+	}
+	client, err := options.newHTTPClient()
+	if err != nil {
+		return ClickHouseStorage{}, err
+	}
+	storage := ClickHouseStorage{
+		baseUrl:  strings.TrimSuffix(baseUrl, "/"),
+		database: database,
+		client:   client,
+		options:  options,
+		naming:   naming,
+	}
+	if database != "" {
+		// The database can't be selected before it's created, so the statement runs in the
+		// default database of the user
+		server := ClickHouseStorage{baseUrl: storage.baseUrl, client: client, options: options}
+		err = server.execute(ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", quoteClickHouseIdentifier(database)), nil)
+		if err != nil {
+			return ClickHouseStorage{}, fmt.Errorf("error creating ClickHouse database: %w", err)
+		}
+	}
+	for _, statement := range clickHouseSchema(naming) {
+		err = storage.execute(ctx, statement, nil)
+		if err != nil {
+			return ClickHouseStorage{}, fmt.Errorf("error creating ClickHouse schema: %w", err)
+		}
+	}
+	return storage, nil
+}
+
+// WriteMetrics writes `zettelkastenMetrics` to ClickHouse with `timestamp`.
+//
+// Rows written again with the same timestamp replace the existing ones once ClickHouse
+// merges them, so writing the same metrics twice is idempotent.
+func (c ClickHouseStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	slog.Debug("Writing metrics to ClickHouse", slog.Int("notes", len(zettelkastenMetrics.Notes)))
+	err := c.writeMetrics(ctx, zettelkastenMetrics, timestamp.Unix())
+	if err != nil {
+		slog.Error("Error writing metrics to ClickHouse storage", slog.Any("error", err))
+	}
+	return err
+}
+
+// writeMetrics inserts the rows of `zettelkastenMetrics` in each table with one request per table.
+func (c ClickHouseStorage) writeMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp int64) error {
+	labels := c.naming.tags(map[string]string{})
+	// row adds the static labels to `row`
+	row := func(row any) any {
+		return clickHouseLabelledRow{row: row, labels: labels}
+	}
+	// noteRow adds the static labels and the frontmatter labels of `note` to `row`
+	noteRow := func(note metrics.NoteMetrics, row any) any {
+		return clickHouseLabelledRow{row: row, labels: c.naming.noteLabels(note)}
+	}
+
+	notes := make([]any, 0, len(zettelkastenMetrics.Notes))
+	links := make([]any, 0)
+	noteTasks := make([]any, 0)
+	noteAnchors := make([]any, 0)
+	for name, metric := range zettelkastenMetrics.Notes {
+		notes = append(notes, noteRow(metric, clickHouseNoteRow{
+			Timestamp:     timestamp,
+			Name:          name,
+			LinkCount:     metric.LinkCount,
+			WordCount:     metric.WordCount,
+			BacklinkCount: metric.BacklinkCount,
+		}))
+		for target, count := range metric.Links {
+			links = append(links, row(clickHouseLinkRow{Timestamp: timestamp, Source: name, Target: target, Count: count}))
+		}
+		if metric.OpenTaskCount+metric.CompletedTaskCount > 0 {
+			noteTasks = append(noteTasks, noteRow(metric, clickHouseNoteTasksRow{
+				Timestamp:      timestamp,
+				Name:           name,
+				OpenCount:      metric.OpenTaskCount,
+				CompletedCount: metric.CompletedTaskCount,
+			}))
+		}
+		if metric.AnchorLinkCount > 0 {
+			noteAnchors = append(noteAnchors, noteRow(metric, clickHouseNoteAnchorsRow{
+				Timestamp:          timestamp,
+				Name:               name,
+				LinkCount:          metric.AnchorLinkCount,
+				BrokenHeadingCount: metric.BrokenHeadingLinkCount,
+				BrokenBlockCount:   metric.BrokenBlockLinkCount,
+			}))
+		}
+	}
+	deletedNotes := make([]any, 0, len(zettelkastenMetrics.DeletedNotes))
+	for name, metric := range zettelkastenMetrics.DeletedNotes {
+		deletedNotes = append(deletedNotes, noteRow(metric, clickHouseDeletedNoteRow{Timestamp: timestamp, Name: name}))
+	}
+	tags := make([]any, 0, len(zettelkastenMetrics.Tags))
+	tagLinks := make([]any, 0)
+	for tag, metric := range zettelkastenMetrics.Tags {
+		tags = append(tags, row(clickHouseTagRow{
+			Timestamp: timestamp,
+			Tag:       tag,
+			NoteCount: metric.NoteCount,
+			WordCount: metric.WordCount,
+			LinkCount: metric.LinkCount,
+		}))
+		for target, count := range metric.Links {
+			tagLinks = append(tagLinks, row(clickHouseLinkRow{Timestamp: timestamp, Source: tag, Target: target, Count: count}))
+		}
+	}
+
+	tasks := make([]any, 0, 1)
+	if zettelkastenMetrics.OpenTaskCount+zettelkastenMetrics.CompletedTaskCount > 0 {
+		tasks = append(tasks, row(clickHouseTasksRow{
+			Timestamp:      timestamp,
+			OpenCount:      zettelkastenMetrics.OpenTaskCount,
+			CompletedCount: zettelkastenMetrics.CompletedTaskCount,
+		}))
+	}
+	anchors := make([]any, 0, 1)
+	if zettelkastenMetrics.AnchorLinkCount > 0 {
+		anchors = append(anchors, row(clickHouseAnchorsRow{
+			Timestamp:          timestamp,
+			LinkCount:          zettelkastenMetrics.AnchorLinkCount,
+			BrokenHeadingCount: zettelkastenMetrics.BrokenHeadingLinkCount,
+			BrokenBlockCount:   zettelkastenMetrics.BrokenBlockLinkCount,
+		}))
+	}
+	activity := make([]any, 0, 1)
+	if metric := zettelkastenMetrics.Activity; metric.NoteCount > 0 {
+		activity = append(activity, row(clickHouseActivityRow{
+			Timestamp:             timestamp,
+			NoteCount:             metric.NoteCount,
+			AverageNoteAgeSeconds: uint(metric.AverageNoteAge / time.Second),
+			CreatedLastDay:        metric.CreatedLastDay,
+			CreatedLastWeek:       metric.CreatedLastWeek,
+			CreatedLastMonth:      metric.CreatedLastMonth,
+			ModifiedLastDay:       metric.ModifiedLastDay,
+			ModifiedLastWeek:      metric.ModifiedLastWeek,
+			ModifiedLastMonth:     metric.ModifiedLastMonth,
+		}))
+	}
+
+	// The totals are inserted last, since the latest timestamp is queried from them
+	tables := []struct {
+		table sqlTable
+		rows  []any
+	}{
+		{table: notesTable, rows: notes},
+		{table: linksTable, rows: links},
+		{table: deletedNotesTable, rows: deletedNotes},
+		{table: tagsTable, rows: tags},
+		{table: tagLinksTable, rows: tagLinks},
+		{table: tasksTable, rows: tasks},
+		{table: noteTasksTable, rows: noteTasks},
+		{table: anchorsTable, rows: anchors},
+		{table: noteAnchorsTable, rows: noteAnchors},
+		{table: activityTable, rows: activity},
+		{table: totalTable, rows: []any{row(clickHouseTotalRow{
+			Timestamp: timestamp,
+			NoteCount: zettelkastenMetrics.NoteCount,
+			LinkCount: zettelkastenMetrics.LinkCount,
+			WordCount: zettelkastenMetrics.WordCount,
+		})}},
+	}
+	for _, table := range tables {
+		if len(table.rows) == 0 {
+			continue
+		}
+		name := c.naming.measurement(table.table.measurement)
+		err := c.insert(ctx, name, table.rows)
+		if err != nil {
+			return fmt.Errorf("error inserting %s rows: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// LatestTimestamp returns the timestamp of the latest aggregated metrics with the static labels of the storage in ClickHouse.
+func (c ClickHouseStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	query := "SELECT toUnixTimestamp(max(timestamp)) FROM " + quoteClickHouseIdentifier(c.naming.measurement(totalMeasurementName))
+	conditions := make([]string, 0, len(c.naming.Labels))
+	for _, label := range c.naming.labelNames() {
+		conditions = append(conditions, fmt.Sprintf("%s = %s", quoteClickHouseIdentifier(label), quoteClickHouseString(c.naming.Labels[label])))
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	request, err := c.options.newGetRequest(ctx, c.queryUrl(query+" FORMAT TabSeparated"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	body, err := fetchResponse(c.client, request)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error querying ClickHouse: %w", err)
+	}
+	latest, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing ClickHouse response: %w", err)
+	}
+	// The maximum of an empty table is the zero value of the column, which is the unix epoch
+	if latest == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(latest, 0), nil
+}
+
+// insert inserts `rows` in `table` using the JSONEachRow format.
+func (c ClickHouseStorage) insert(ctx context.Context, table string, rows []any) error {
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	for _, row := range rows {
+		err := encoder.Encode(row)
+		if err != nil {
+			return fmt.Errorf("error encoding row: %w", err)
+		}
+	}
+	return c.execute(ctx, fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", quoteClickHouseIdentifier(table)), content.Bytes())
+}
+
+// execute sends `statement` to ClickHouse with `content` as the request body.
+func (c ClickHouseStorage) execute(ctx context.Context, statement string, content []byte) error {
+	request, err := c.options.newRequest(ctx, c.queryUrl(statement), "application/json", content)
+	if err != nil {
+		return err
+	}
+	return sendRequest(c.client, request)
+}
+
+// quoteClickHouseIdentifier quotes `name` to be used as an identifier in ClickHouse statements.
+func quoteClickHouseIdentifier(name string) string {
+	name = strings.ReplaceAll(name, `\`, `\\`)
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// quoteClickHouseString quotes `value` as a string literal in ClickHouse statements.
+func quoteClickHouseString(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+}
+
+// queryUrl returns the URL of the HTTP interface running `statement` in the database of `c`.
+func (c ClickHouseStorage) queryUrl(statement string) string {
+	query := url.Values{"query": {statement}}
+	if c.database != "" {
+		query.Set("database", c.database)
+	}
+	return fmt.Sprintf("%s/?%s", c.baseUrl, query.Encode())
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClickHouseStorage(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var statements []string
+	rows := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/", r.URL.Path)
+		statement := r.URL.Query().Get("query")
+		if strings.HasPrefix(statement, "CREATE DATABASE") {
+			assert.Empty(t, r.URL.Query().Get("database"))
+		} else {
+			assert.Equal(t, "zettelkasten", r.URL.Query().Get("database"))
+		}
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "any-user", username)
+		assert.Equal(t, "any-password", password)
+		content, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		statements = append(statements, statement)
+		table, ok := strings.CutPrefix(statement, "INSERT INTO ")
+		if ok {
+			table, _, _ = strings.Cut(table, " ")
+			table = strings.Trim(table, "`")
+			rows[table] = append(rows[table], strings.Split(strings.TrimSpace(string(content)), "\n")...)
+		}
+	}))
+	defer server.Close()
+
+	storage, err := NewClickHouseStorage(context.Background(), server.URL, "zettelkasten", HTTPOptions{Username: "any-user", Password: "any-password"}, MetricNaming{})
+	require.NoError(t, err)
+	require.Len(t, statements, len(sqlTables)+1)
+	assert.Equal(t, "CREATE DATABASE IF NOT EXISTS `zettelkasten`", statements[0])
+	for _, statement := range statements[1:] {
+		assert.Contains(t, statement, "CREATE TABLE IF NOT EXISTS")
+		assert.Contains(t, statement, "ENGINE = ReplacingMergeTree")
+	}
+
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{
+		NoteCount:              2,
+		LinkCount:              3,
+		WordCount:              15,
+		OpenTaskCount:          2,
+		CompletedTaskCount:     1,
+		AnchorLinkCount:        3,
+		BrokenHeadingLinkCount: 1,
+		BrokenBlockLinkCount:   1,
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {Links: map[string]uint{"two": 2}, LinkCount: 2, WordCount: 10, BacklinkCount: 1, OpenTaskCount: 2, CompletedTaskCount: 1, AnchorLinkCount: 3, BrokenHeadingLinkCount: 1, BrokenBlockLinkCount: 1},
+			"two": {Links: map[string]uint{"one": 1}, LinkCount: 1, WordCount: 5, BacklinkCount: 2},
+		},
+		Tags: map[string]metrics.TagMetrics{
+			"project": {NoteCount: 2, WordCount: 15, LinkCount: 3, Links: map[string]uint{"project": 3}},
+		},
+		Activity:     metrics.ActivityMetrics{NoteCount: 2, AverageNoteAge: time.Hour, CreatedLastDay: 2, CreatedLastWeek: 2, CreatedLastMonth: 2},
+		DeletedNotes: map[string]metrics.NoteMetrics{"three": {}},
+	}
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+
+	assert.Equal(t, "INSERT INTO `total` FORMAT JSONEachRow", statements[len(statements)-1])
+	for _, table := range rows {
+		sort.Strings(table)
+	}
+	assert.Equal(t, map[string][]string{
+		"total": {`{"timestamp":1716978600,"note_count":2,"link_count":3,"word_count":15}`},
+		"notes": {
+			`{"timestamp":1716978600,"name":"one","link_count":2,"word_count":10,"backlink_count":1}`,
+			`{"timestamp":1716978600,"name":"two","link_count":1,"word_count":5,"backlink_count":2}`,
+		},
+		"links": {
+			`{"timestamp":1716978600,"source":"one","target":"two","count":2}`,
+			`{"timestamp":1716978600,"source":"two","target":"one","count":1}`,
+		},
+		"deleted_notes": {`{"timestamp":1716978600,"name":"three"}`},
+		"tags":          {`{"timestamp":1716978600,"tag":"project","note_count":2,"word_count":15,"link_count":3}`},
+		"tag_links":     {`{"timestamp":1716978600,"source":"project","target":"project","count":3}`},
+		"tasks":         {`{"timestamp":1716978600,"open_count":2,"completed_count":1}`},
+		"note_tasks":    {`{"timestamp":1716978600,"name":"one","open_count":2,"completed_count":1}`},
+		"anchors":       {`{"timestamp":1716978600,"link_count":3,"broken_heading_count":1,"broken_block_count":1}`},
+		"note_anchors":  {`{"timestamp":1716978600,"name":"one","link_count":3,"broken_heading_count":1,"broken_block_count":1}`},
+		"activity": {
+			`{"timestamp":1716978600,"note_count":2,"average_note_age_seconds":3600,"created_last_day":2,"created_last_week":2,"created_last_month":2,"modified_last_day":0,"modified_last_week":0,"modified_last_month":0}`,
+		},
+	}, rows)
+}
+
+func TestClickHouseStorage_Error(t *testing.T) {
+	schemaCreated := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Query().Get("query"), "CREATE TABLE") {
+			schemaCreated = true
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Code: 81. DB::Exception: Database zettelkasten does not exist. (UNKNOWN_DATABASE)"))
+	}))
+	defer server.Close()
+
+	storage, err := NewClickHouseStorage(context.Background(), server.URL, "", HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	assert.True(t, schemaCreated)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}}, time.Now())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "UNKNOWN_DATABASE")
+}
+
+func TestNewClickHouseStorage_DatabaseError(t *testing.T) {
+	var statements []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statements = append(statements, r.URL.Query().Get("query"))
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("Code: 497. DB::Exception: Not enough privileges. (ACCESS_DENIED)"))
+	}))
+	defer server.Close()
+
+	_, err := NewClickHouseStorage(context.Background(), server.URL, "zettel`kasten", HTTPOptions{}, MetricNaming{})
+	assert.ErrorContains(t, err, "ACCESS_DENIED")
+	assert.Equal(t, []string{"CREATE DATABASE IF NOT EXISTS `zettel\\`kasten`"}, statements)
+}
+
+func TestClickHouseStorage_LatestTimestamp(t *testing.T) {
+	data := []struct {
+		name     string
+		response string
+		expected time.Time
+	}{
+		{name: "with metrics", response: "1716978600\n", expected: time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)},
+		{name: "without metrics", response: "0\n", expected: time.Time{}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					return
+				}
+				assert.Equal(t, "SELECT toUnixTimestamp(max(timestamp)) FROM `total` FORMAT TabSeparated", r.URL.Query().Get("query"))
+				_, _ = w.Write([]byte(d.response))
+			}))
+			defer server.Close()
+
+			storage, err := NewClickHouseStorage(context.Background(), server.URL, "", HTTPOptions{}, MetricNaming{})
+			require.NoError(t, err)
+			latest, err := storage.LatestTimestamp(context.Background())
+			require.NoError(t, err)
+			assert.True(t, d.expected.Equal(latest), "expected %s, got %s", d.expected, latest)
+		})
+	}
+}
+
+func TestClickHouseStorage_Naming(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var statements []string
+	rows := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statement := r.URL.Query().Get("query")
+		statements = append(statements, statement)
+		if strings.HasPrefix(statement, "SELECT") {
+			_, _ = w.Write([]byte("1716978600\n"))
+			return
+		}
+		content, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		table, ok := strings.CutPrefix(statement, "INSERT INTO ")
+		if ok {
+			table, _, _ = strings.Cut(table, " ")
+			rows[table] = append(rows[table], strings.Split(strings.TrimSpace(string(content)), "\n")...)
+		}
+	}))
+	defer server.Close()
+
+	naming := MetricNaming{
+		Prefix:       "zettelkasten_",
+		Measurements: map[string]string{"total": "vault"},
+		Labels:       map[string]string{"vault": "work's"},
+		NoteLabels:   map[string]string{"type": "type"},
+	}
+	storage, err := NewClickHouseStorage(context.Background(), server.URL, "", HTTPOptions{}, naming)
+	require.NoError(t, err)
+	require.Len(t, statements, len(sqlTables))
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS `zettelkasten_vault` (\n"+
+		"\t`timestamp` DateTime('UTC'),\n"+
+		"\t`note_count` UInt64,\n"+
+		"\t`link_count` UInt64,\n"+
+		"\t`word_count` UInt64,\n"+
+		"\t`vault` String\n"+
+		") ENGINE = ReplacingMergeTree ORDER BY (`timestamp`, `vault`)", statements[0])
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS `zettelkasten_notes` (\n"+
+		"\t`timestamp` DateTime('UTC'),\n"+
+		"\t`name` String,\n"+
+		"\t`link_count` UInt64,\n"+
+		"\t`word_count` UInt64,\n"+
+		"\t`backlink_count` UInt64,\n"+
+		"\t`vault` String,\n"+
+		"\t`type` Nullable(String)\n"+
+		") ENGINE = ReplacingMergeTree ORDER BY (`timestamp`, `name`, `vault`)", statements[1])
+
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{
+		NoteCount: 2,
+		LinkCount: 1,
+		WordCount: 15,
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {Links: map[string]uint{"two": 1}, LinkCount: 1, WordCount: 10, Frontmatter: map[string]any{"type": "idea"}},
+			"two": {WordCount: 5, BacklinkCount: 1},
+		},
+	}
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+	for _, table := range rows {
+		sort.Strings(table)
+	}
+	assert.Equal(t, map[string][]string{
+		"`zettelkasten_vault`": {`{"timestamp":1716978600,"note_count":2,"link_count":1,"word_count":15,"vault":"work's"}`},
+		"`zettelkasten_notes`": {
+			`{"timestamp":1716978600,"name":"one","link_count":1,"word_count":10,"backlink_count":0,"type":"idea","vault":"work's"}`,
+			`{"timestamp":1716978600,"name":"two","link_count":0,"word_count":5,"backlink_count":1,"vault":"work's"}`,
+		},
+		"`zettelkasten_links`": {`{"timestamp":1716978600,"source":"one","target":"two","count":1,"vault":"work's"}`},
+	}, rows)
+
+	latest, err := storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.True(t, timestamp.Equal(latest))
+	assert.Equal(t, "SELECT toUnixTimestamp(max(timestamp)) FROM `zettelkasten_vault` WHERE `vault` = 'work\\'s' FORMAT TabSeparated", statements[len(statements)-1])
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"time"
 
 	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
@@ -9,6 +10,8 @@ import (
 // FakeStorage represents a fake implementation of storage to be used in tests.
 type FakeStorage struct {
 	Metrics []metrics.ZettelkastenMetrics
+	Latest  time.Time
+	Flushes int
 }
 
 // FakeStorage creates a new `FakeStorage`.
@@ -16,7 +19,16 @@ func NewFakeStorage() FakeStorage {
 	return FakeStorage{}
 }
 
-func (f *FakeStorage) WriteMetrics(zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+func (f *FakeStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
 	f.Metrics = append(f.Metrics, zettelkastenMetrics)
 	return nil
 }
+
+func (f *FakeStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return f.Latest, nil
+}
+
+func (f *FakeStorage) Flush(ctx context.Context) error {
+	f.Flushes++
+	return nil
+}
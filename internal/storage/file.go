@@ -0,0 +1,783 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// The supported file formats.
+const (
+	FileFormatJSONLines = "jsonl"
+	FileFormatCSV       = "csv"
+	FileFormatParquet   = "parquet"
+)
+
+// The name of the file holding the links between notes.
+const linksMeasurementName = "links"
+
+// Limits of the Parquet files, which are written in parts since they can't be appended to.
+const (
+	// parquetRowGroupRows is the maximum number of rows buffered in memory before being written as a row group.
+	parquetRowGroupRows = 100_000
+	// parquetPartRows is the number of rows after which a part is completed, even before it's rotated.
+	parquetPartRows = 1_000_000
+)
+
+// The extension of the files holding the rows of a measurement while they are written.
+const partialExtension = ".tmp"
+
+// fileLabels holds the labels of a row in a file, which are the static labels along with the
+// frontmatter labels for the rows of notes.
+type fileLabels struct {
+	Labels map[string]string `json:"labels,omitempty" parquet:"labels"`
+}
+
+func (l fileLabels) labels() map[string]string {
+	return l.Labels
+}
+
+// totalRow represents the aggregated metrics of a Zettelkasten in a file.
+type totalRow struct {
+	Timestamp time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	NoteCount uint64    `json:"note_count" parquet:"note_count"`
+	LinkCount uint64    `json:"link_count" parquet:"link_count"`
+	WordCount uint64    `json:"word_count" parquet:"word_count"`
+	fileLabels
+}
+
+func (r totalRow) csvHeader() []string {
+	return []string{"timestamp", "note_count", "link_count", "word_count"}
+}
+
+func (r totalRow) csvRecord() []string {
+	return []string{formatFileTimestamp(r.Timestamp), formatUint(r.NoteCount), formatUint(r.LinkCount), formatUint(r.WordCount)}
+}
+
+// noteRow represents the metrics of a single note in a file.
+type noteRow struct {
+	Timestamp     time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	Name          string    `json:"name" parquet:"name,dict"`
+	LinkCount     uint64    `json:"link_count" parquet:"link_count"`
+	WordCount     uint64    `json:"word_count" parquet:"word_count"`
+	BacklinkCount uint64    `json:"backlink_count" parquet:"backlink_count"`
+	fileLabels
+}
+
+func (r noteRow) csvHeader() []string {
+	return []string{"timestamp", "name", "link_count", "word_count", "backlink_count"}
+}
+
+func (r noteRow) csvRecord() []string {
+	return []string{formatFileTimestamp(r.Timestamp), r.Name, formatUint(r.LinkCount), formatUint(r.WordCount), formatUint(r.BacklinkCount)}
+}
+
+// linkRow represents the number of links from a note or tag to another in a file.
+type linkRow struct {
+	Timestamp time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	Source    string    `json:"source" parquet:"source,dict"`
+	Target    string    `json:"target" parquet:"target,dict"`
+	Count     uint64    `json:"count" parquet:"count"`
+	fileLabels
+}
+
+func (r linkRow) csvHeader() []string {
+	return []string{"timestamp", "source", "target", "count"}
+}
+
+func (r linkRow) csvRecord() []string {
+	return []string{formatFileTimestamp(r.Timestamp), r.Source, r.Target, formatUint(r.Count)}
+}
+
+// deletedNoteRow represents the deletion of a note in a file.
+type deletedNoteRow struct {
+	Timestamp time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	Name      string    `json:"name" parquet:"name,dict"`
+	fileLabels
+}
+
+func (r deletedNoteRow) csvHeader() []string {
+	return []string{"timestamp", "name"}
+}
+
+func (r deletedNoteRow) csvRecord() []string {
+	return []string{formatFileTimestamp(r.Timestamp), r.Name}
+}
+
+// tagRow represents the aggregated metrics of the notes with a tag in a file.
+type tagRow struct {
+	Timestamp time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	Tag       string    `json:"tag" parquet:"tag,dict"`
+	NoteCount uint64    `json:"note_count" parquet:"note_count"`
+	WordCount uint64    `json:"word_count" parquet:"word_count"`
+	LinkCount uint64    `json:"link_count" parquet:"link_count"`
+	fileLabels
+}
+
+func (r tagRow) csvHeader() []string {
+	return []string{"timestamp", "tag", "note_count", "word_count", "link_count"}
+}
+
+func (r tagRow) csvRecord() []string {
+	return []string{formatFileTimestamp(r.Timestamp), r.Tag, formatUint(r.NoteCount), formatUint(r.WordCount), formatUint(r.LinkCount)}
+}
+
+// tasksRow represents the number of open and completed tasks of a Zettelkasten in a file.
+type tasksRow struct {
+	Timestamp      time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	OpenCount      uint64    `json:"open_count" parquet:"open_count"`
+	CompletedCount uint64    `json:"completed_count" parquet:"completed_count"`
+	fileLabels
+}
+
+func (r tasksRow) csvHeader() []string {
+	return []string{"timestamp", "open_count", "completed_count"}
+}
+
+func (r tasksRow) csvRecord() []string {
+	return []string{formatFileTimestamp(r.Timestamp), formatUint(r.OpenCount), formatUint(r.CompletedCount)}
+}
+
+// noteTasksRow represents the number of open and completed tasks of a single note in a file.
+type noteTasksRow struct {
+	Timestamp      time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	Name           string    `json:"name" parquet:"name,dict"`
+	OpenCount      uint64    `json:"open_count" parquet:"open_count"`
+	CompletedCount uint64    `json:"completed_count" parquet:"completed_count"`
+	fileLabels
+}
+
+func (r noteTasksRow) csvHeader() []string {
+	return []string{"timestamp", "name", "open_count", "completed_count"}
+}
+
+func (r noteTasksRow) csvRecord() []string {
+	return []string{formatFileTimestamp(r.Timestamp), r.Name, formatUint(r.OpenCount), formatUint(r.CompletedCount)}
+}
+
+// anchorsRow represents the number of links to anchors and of broken ones of a Zettelkasten in a file.
+type anchorsRow struct {
+	Timestamp          time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	LinkCount          uint64    `json:"link_count" parquet:"link_count"`
+	BrokenHeadingCount uint64    `json:"broken_heading_count" parquet:"broken_heading_count"`
+	BrokenBlockCount   uint64    `json:"broken_block_count" parquet:"broken_block_count"`
+	fileLabels
+}
+
+func (r anchorsRow) csvHeader() []string {
+	return []string{"timestamp", "link_count", "broken_heading_count", "broken_block_count"}
+}
+
+func (r anchorsRow) csvRecord() []string {
+	return []string{formatFileTimestamp(r.Timestamp), formatUint(r.LinkCount), formatUint(r.BrokenHeadingCount), formatUint(r.BrokenBlockCount)}
+}
+
+// noteAnchorsRow represents the number of links to anchors and of broken ones of a single note in a file.
+type noteAnchorsRow struct {
+	Timestamp          time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	Name               string    `json:"name" parquet:"name,dict"`
+	LinkCount          uint64    `json:"link_count" parquet:"link_count"`
+	BrokenHeadingCount uint64    `json:"broken_heading_count" parquet:"broken_heading_count"`
+	BrokenBlockCount   uint64    `json:"broken_block_count" parquet:"broken_block_count"`
+	fileLabels
+}
+
+func (r noteAnchorsRow) csvHeader() []string {
+	return []string{"timestamp", "name", "link_count", "broken_heading_count", "broken_block_count"}
+}
+
+func (r noteAnchorsRow) csvRecord() []string {
+	return []string{formatFileTimestamp(r.Timestamp), r.Name, formatUint(r.LinkCount), formatUint(r.BrokenHeadingCount), formatUint(r.BrokenBlockCount)}
+}
+
+// activityRow represents the aggregated creation and modification dates of the notes in a file.
+type activityRow struct {
+	Timestamp             time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	NoteCount             uint64    `json:"note_count" parquet:"note_count"`
+	AverageNoteAgeSeconds uint64    `json:"average_note_age_seconds" parquet:"average_note_age_seconds"`
+	CreatedLastDay        uint64    `json:"created_last_day" parquet:"created_last_day"`
+	CreatedLastWeek       uint64    `json:"created_last_week" parquet:"created_last_week"`
+	CreatedLastMonth      uint64    `json:"created_last_month" parquet:"created_last_month"`
+	ModifiedLastDay       uint64    `json:"modified_last_day" parquet:"modified_last_day"`
+	ModifiedLastWeek      uint64    `json:"modified_last_week" parquet:"modified_last_week"`
+	ModifiedLastMonth     uint64    `json:"modified_last_month" parquet:"modified_last_month"`
+	fileLabels
+}
+
+func (r activityRow) csvHeader() []string {
+	return []string{
+		"timestamp", "note_count", "average_note_age_seconds",
+		"created_last_day", "created_last_week", "created_last_month",
+		"modified_last_day", "modified_last_week", "modified_last_month",
+	}
+}
+
+func (r activityRow) csvRecord() []string {
+	return []string{
+		formatFileTimestamp(r.Timestamp), formatUint(r.NoteCount), formatUint(r.AverageNoteAgeSeconds),
+		formatUint(r.CreatedLastDay), formatUint(r.CreatedLastWeek), formatUint(r.CreatedLastMonth),
+		formatUint(r.ModifiedLastDay), formatUint(r.ModifiedLastWeek), formatUint(r.ModifiedLastMonth),
+	}
+}
+
+// fileRow represents a row that can be written to a file.
+type fileRow interface {
+	csvHeader() []string
+	csvRecord() []string
+	labels() map[string]string
+}
+
+// parquetPart represents a Parquet part file being written, which is only readable once completed.
+type parquetPart struct {
+	path   string
+	file   *os.File
+	writer io.Closer
+	rows   int
+}
+
+// complete closes the writer of the part and moves it to its final path.
+func (p *parquetPart) complete() error {
+	err := errors.Join(p.writer.Close(), p.file.Close())
+	if err != nil {
+		_ = os.Remove(p.file.Name())
+		return fmt.Errorf("error writing file %s: %w", p.path, err)
+	}
+	err = os.Rename(p.file.Name(), p.path)
+	if err != nil {
+		return fmt.Errorf("error renaming file %s: %w", p.path, err)
+	}
+	return nil
+}
+
+// FileStorage represents the implementation of a metric storage that appends metrics to local files.
+//
+// Each measurement is written to a separate file in the storage directory, optionally rotated daily
+// based on the timestamp of the metrics. Parquet measurements are directories of part files instead,
+// each completed when the files are rotated, once it's large enough and when the storage is closed.
+type FileStorage struct {
+	directory     string
+	format        string
+	dailyRotation bool
+	naming        MetricNaming
+	mu            *sync.Mutex
+	// parts holds the Parquet parts being written by the directory of their measurement.
+	parts map[string]*parquetPart
+}
+
+// NewFileStorage creates a new `FileStorage` writing files with `format` to `directory`.
+//
+// The files are named and the rows labelled according to `naming`.
+func NewFileStorage(directory, format string, dailyRotation bool, naming MetricNaming) (FileStorage, error) {
+	if format != FileFormatJSONLines && format != FileFormatCSV && format != FileFormatParquet {
+		return FileStorage{}, fmt.Errorf("unsupported file format %q", format)
+	}
+	err := os.MkdirAll(directory, 0o755)
+	if err != nil {
+		return FileStorage{}, fmt.Errorf("error creating directory: %w", err)
+	}
+	return FileStorage{directory: directory, format: format, dailyRotation: dailyRotation, naming: naming, mu: &sync.Mutex{}, parts: make(map[string]*parquetPart)}, nil
+}
+
+// WriteMetrics appends `zettelkastenMetrics` with `timestamp` to the measurement files.
+func (f FileStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	timestamp = timestamp.UTC()
+	var labels fileLabels
+	if len(f.naming.Labels) > 0 {
+		labels.Labels = f.naming.tags(map[string]string{})
+	}
+	// noteLabels returns the static labels along with the frontmatter labels of `note`
+	noteLabels := func(note metrics.NoteMetrics) fileLabels {
+		if len(f.naming.Labels)+len(f.naming.NoteLabels) == 0 {
+			return fileLabels{}
+		}
+		return fileLabels{Labels: f.naming.noteLabels(note)}
+	}
+
+	totals := []totalRow{{
+		Timestamp:  timestamp,
+		NoteCount:  uint64(zettelkastenMetrics.NoteCount),
+		LinkCount:  uint64(zettelkastenMetrics.LinkCount),
+		WordCount:  uint64(zettelkastenMetrics.WordCount),
+		fileLabels: labels,
+	}}
+	notes := make([]noteRow, 0, len(zettelkastenMetrics.Notes))
+	links := make([]linkRow, 0)
+	noteTasks := make([]noteTasksRow, 0)
+	noteAnchors := make([]noteAnchorsRow, 0)
+	for name, metric := range zettelkastenMetrics.Notes {
+		notes = append(notes, noteRow{
+			Timestamp:     timestamp,
+			Name:          name,
+			LinkCount:     uint64(metric.LinkCount),
+			WordCount:     uint64(metric.WordCount),
+			BacklinkCount: uint64(metric.BacklinkCount),
+			fileLabels:    noteLabels(metric),
+		})
+		for target, count := range metric.Links {
+			links = append(links, linkRow{Timestamp: timestamp, Source: name, Target: target, Count: uint64(count), fileLabels: labels})
+		}
+		if metric.OpenTaskCount+metric.CompletedTaskCount > 0 {
+			noteTasks = append(noteTasks, noteTasksRow{
+				Timestamp:      timestamp,
+				Name:           name,
+				OpenCount:      uint64(metric.OpenTaskCount),
+				CompletedCount: uint64(metric.CompletedTaskCount),
+				fileLabels:     noteLabels(metric),
+			})
+		}
+		if metric.AnchorLinkCount > 0 {
+			noteAnchors = append(noteAnchors, noteAnchorsRow{
+				Timestamp:          timestamp,
+				Name:               name,
+				LinkCount:          uint64(metric.AnchorLinkCount),
+				BrokenHeadingCount: uint64(metric.BrokenHeadingLinkCount),
+				BrokenBlockCount:   uint64(metric.BrokenBlockLinkCount),
+				fileLabels:         noteLabels(metric),
+			})
+		}
+	}
+
+	deletedNotes := make([]deletedNoteRow, 0, len(zettelkastenMetrics.DeletedNotes))
+	for name, metric := range zettelkastenMetrics.DeletedNotes {
+		deletedNotes = append(deletedNotes, deletedNoteRow{Timestamp: timestamp, Name: name, fileLabels: noteLabels(metric)})
+	}
+
+	tags := make([]tagRow, 0, len(zettelkastenMetrics.Tags))
+	tagLinks := make([]linkRow, 0)
+	for tag, metric := range zettelkastenMetrics.Tags {
+		tags = append(tags, tagRow{
+			Timestamp:  timestamp,
+			Tag:        tag,
+			NoteCount:  uint64(metric.NoteCount),
+			WordCount:  uint64(metric.WordCount),
+			LinkCount:  uint64(metric.LinkCount),
+			fileLabels: labels,
+		})
+		for target, count := range metric.Links {
+			tagLinks = append(tagLinks, linkRow{Timestamp: timestamp, Source: tag, Target: target, Count: uint64(count), fileLabels: labels})
+		}
+	}
+
+	slices.SortFunc(notes, func(a, b noteRow) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(links, compareLinkRows)
+	slices.SortFunc(noteTasks, func(a, b noteTasksRow) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(noteAnchors, func(a, b noteAnchorsRow) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(tags, func(a, b tagRow) int { return strings.Compare(a.Tag, b.Tag) })
+	slices.SortFunc(tagLinks, compareLinkRows)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	slog.Debug("Writing metrics to files", slog.String("directory", f.directory), slog.String("format", f.format))
+	err := errors.Join(
+		f.completeRotatedParts(timestamp),
+		appendRows(f, totalMeasurementName, timestamp, totals),
+		appendRows(f, notesMeasurementName, timestamp, notes),
+		appendRows(f, linksMeasurementName, timestamp, links),
+	)
+	// Avoid creating a file for deletions until a note is deleted
+	if len(deletedNotes) > 0 {
+		err = errors.Join(err, appendRows(f, deletedNotesMeasurementName, timestamp, deletedNotes))
+	}
+	// Avoid creating files for tags in Zettelkastens that don't use them
+	if len(tags) > 0 {
+		err = errors.Join(
+			err,
+			appendRows(f, tagsMeasurementName, timestamp, tags),
+			appendRows(f, tagLinksMeasurementName, timestamp, tagLinks),
+		)
+	}
+	// Avoid creating files for tasks in Zettelkastens that don't have them
+	if zettelkastenMetrics.OpenTaskCount+zettelkastenMetrics.CompletedTaskCount > 0 {
+		tasks := []tasksRow{{
+			Timestamp:      timestamp,
+			OpenCount:      uint64(zettelkastenMetrics.OpenTaskCount),
+			CompletedCount: uint64(zettelkastenMetrics.CompletedTaskCount),
+			fileLabels:     labels,
+		}}
+		err = errors.Join(
+			err,
+			appendRows(f, tasksMeasurementName, timestamp, tasks),
+			appendRows(f, noteTasksMeasurementName, timestamp, noteTasks),
+		)
+	}
+	// Avoid creating files for anchors in Zettelkastens that don't link to them
+	if zettelkastenMetrics.AnchorLinkCount > 0 {
+		anchors := []anchorsRow{{
+			Timestamp:          timestamp,
+			LinkCount:          uint64(zettelkastenMetrics.AnchorLinkCount),
+			BrokenHeadingCount: uint64(zettelkastenMetrics.BrokenHeadingLinkCount),
+			BrokenBlockCount:   uint64(zettelkastenMetrics.BrokenBlockLinkCount),
+			fileLabels:         labels,
+		}}
+		err = errors.Join(
+			err,
+			appendRows(f, anchorsMeasurementName, timestamp, anchors),
+			appendRows(f, noteAnchorsMeasurementName, timestamp, noteAnchors),
+		)
+	}
+	// Avoid creating a file for the note dates when none are known
+	if activity := zettelkastenMetrics.Activity; activity.NoteCount > 0 {
+		err = errors.Join(err, appendRows(f, activityMeasurementName, timestamp, []activityRow{{
+			Timestamp:             timestamp,
+			NoteCount:             uint64(activity.NoteCount),
+			AverageNoteAgeSeconds: uint64(activity.AverageNoteAge / time.Second),
+			CreatedLastDay:        uint64(activity.CreatedLastDay),
+			CreatedLastWeek:       uint64(activity.CreatedLastWeek),
+			CreatedLastMonth:      uint64(activity.CreatedLastMonth),
+			ModifiedLastDay:       uint64(activity.ModifiedLastDay),
+			ModifiedLastWeek:      uint64(activity.ModifiedLastWeek),
+			ModifiedLastMonth:     uint64(activity.ModifiedLastMonth),
+			fileLabels:            labels,
+		}}))
+	}
+	if err != nil {
+		slog.Error("Error writing metrics to file storage", slog.Any("error", err))
+	}
+	return err
+}
+
+// LatestTimestamp returns the timestamp of the last row of the `total` measurement, which is
+// written on every collection.
+func (f FileStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path, err := f.latestPath(totalMeasurementName)
+	if err != nil || path == "" {
+		return time.Time{}, err
+	}
+	var latest time.Time
+	switch f.format {
+	case FileFormatJSONLines:
+		latest, err = lastJSONLinesTimestamp(path)
+	case FileFormatCSV:
+		latest, err = lastCSVTimestamp(path)
+	case FileFormatParquet:
+		latest, err = lastParquetTimestamp(path)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading latest timestamp from %s: %w", path, err)
+	}
+	return latest, nil
+}
+
+// Close completes the Parquet parts being written.
+func (f FileStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.completeParts()
+}
+
+// completeRotatedParts completes the Parquet parts of the days before `timestamp`, which are
+// no longer written to once the files are rotated.
+func (f FileStorage) completeRotatedParts(timestamp time.Time) error {
+	if !f.dailyRotation {
+		return nil
+	}
+	suffix := "-" + timestamp.Format(time.DateOnly)
+	var err error
+	for directory, part := range f.parts {
+		if !strings.HasSuffix(directory, suffix) {
+			err = errors.Join(err, part.complete())
+			delete(f.parts, directory)
+		}
+	}
+	return err
+}
+
+// completeParts completes all Parquet parts being written.
+func (f FileStorage) completeParts() error {
+	var err error
+	for directory, part := range f.parts {
+		err = errors.Join(err, part.complete())
+		delete(f.parts, directory)
+	}
+	if err != nil {
+		slog.Error("Error completing Parquet files", slog.Any("error", err))
+	}
+	return err
+}
+
+// path returns the path of the file for the default measurement `measurement` at `timestamp`,
+// or of the directory holding its parts for Parquet.
+func (f FileStorage) path(measurement string, timestamp time.Time) string {
+	name := f.naming.measurement(measurement)
+	if f.dailyRotation {
+		name = fmt.Sprintf("%s-%s", name, timestamp.Format(time.DateOnly))
+	}
+	return filepath.Join(f.directory, name+f.extension())
+}
+
+// extension returns the extension of the files of each measurement, which is empty for the
+// Parquet directories.
+func (f FileStorage) extension() string {
+	if f.format == FileFormatParquet {
+		return ""
+	}
+	return "." + f.format
+}
+
+// latestPath returns the path of the latest file of `measurement`, or an empty path if there is none.
+func (f FileStorage) latestPath(measurement string) (string, error) {
+	if !f.dailyRotation {
+		path := f.path(measurement, time.Time{})
+		_, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("error stating file: %w", err)
+		}
+		return path, nil
+	}
+
+	// The dates in the names of rotated files sort chronologically
+	pattern := filepath.Join(f.directory, f.naming.measurement(measurement)+"-????-??-??"+f.extension())
+	paths, err := filepath.Glob(pattern)
+	if err != nil || len(paths) == 0 {
+		return "", err
+	}
+	slices.Sort(paths)
+	return paths[len(paths)-1], nil
+}
+
+// appendRows appends `rows` to the file of `measurement` at `timestamp` in the storage `f`.
+func appendRows[T fileRow](f FileStorage, measurement string, timestamp time.Time, rows []T) error {
+	path := f.path(measurement, timestamp)
+	switch f.format {
+	case FileFormatJSONLines:
+		return appendJSONLines(path, rows)
+	case FileFormatCSV:
+		return appendCSV(path, f.labelNames(measurement), rows)
+	case FileFormatParquet:
+		return appendParquet(f.parts, path, rows)
+	default:
+		return fmt.Errorf("unsupported file format %q", f.format)
+	}
+}
+
+// labelNames returns the names of the labels of the rows of `measurement`, which are the static
+// labels followed by the frontmatter labels for the measurements of notes.
+func (f FileStorage) labelNames(measurement string) []string {
+	for _, table := range sqlTables {
+		if table.measurement == measurement && table.note {
+			return slices.Concat(f.naming.labelNames(), f.naming.noteLabelNames())
+		}
+	}
+	return f.naming.labelNames()
+}
+
+// appendJSONLines appends `rows` to the file at `path` as JSON objects, one per line.
+func appendJSONLines[T fileRow](path string, rows []T) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, row := range rows {
+		err = encoder.Encode(row)
+		if err != nil {
+			break
+		}
+	}
+	err = errors.Join(err, file.Close())
+	if err != nil {
+		return fmt.Errorf("error writing file %s: %w", path, err)
+	}
+	return nil
+}
+
+// appendCSV appends `rows` to the file at `path` as CSV records followed by the values of the
+// labels `labelNames`, writing the header if the file is new.
+func appendCSV[T fileRow](path string, labelNames []string, rows []T) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("error stating file: %w", err)
+	}
+
+	writer := csv.NewWriter(file)
+	if info.Size() == 0 {
+		var row T
+		err = writer.Write(slices.Concat(row.csvHeader(), labelNames))
+	}
+	for _, row := range rows {
+		if err != nil {
+			break
+		}
+		record := row.csvRecord()
+		for _, label := range labelNames {
+			record = append(record, row.labels()[label])
+		}
+		err = writer.Write(record)
+	}
+	writer.Flush()
+	err = errors.Join(err, writer.Error(), file.Close())
+	if err != nil {
+		return fmt.Errorf("error writing file %s: %w", path, err)
+	}
+	return nil
+}
+
+// appendParquet appends `rows` to the Parquet part being written in the directory at `path`,
+// starting a new part in `parts` if there is none.
+//
+// Since Parquet files can't be appended to, the rows are written to parts that are completed
+// when the files are rotated or the storage is closed, or once they have `parquetPartRows` rows.
+func appendParquet[T fileRow](parts map[string]*parquetPart, path string, rows []T) error {
+	part, ok := parts[path]
+	if !ok {
+		var err error
+		part, err = createParquetPart[T](path)
+		if err != nil {
+			return err
+		}
+		parts[path] = part
+	}
+
+	_, err := part.writer.(*parquet.GenericWriter[T]).Write(rows)
+	part.rows += len(rows)
+	if err != nil || part.rows >= parquetPartRows {
+		delete(parts, path)
+		err = errors.Join(err, part.complete())
+	}
+	if err != nil {
+		return fmt.Errorf("error writing file %s: %w", part.path, err)
+	}
+	return nil
+}
+
+// createParquetPart creates the next part in the directory at `path`.
+func createParquetPart[T fileRow](path string) (*parquetPart, error) {
+	err := os.MkdirAll(path, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating directory: %w", err)
+	}
+	completed, err := parquetParts(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Part names sort in the order they were written, and the leftovers of interrupted writes are overwritten
+	partPath := filepath.Join(path, fmt.Sprintf("part-%06d.%s", len(completed), FileFormatParquet))
+	file, err := os.Create(partPath + partialExtension)
+	if err != nil {
+		return nil, fmt.Errorf("error creating file: %w", err)
+	}
+	writer := parquet.NewGenericWriter[T](file, parquet.MaxRowsPerRowGroup(parquetRowGroupRows))
+	return &parquetPart{path: partPath, file: file, writer: writer}, nil
+}
+
+// parquetParts returns the sorted paths of the completed parts in the directory at `path`.
+func parquetParts(path string) ([]string, error) {
+	parts, err := filepath.Glob(filepath.Join(path, "part-*."+FileFormatParquet))
+	if err != nil {
+		return nil, fmt.Errorf("error listing parts: %w", err)
+	}
+	slices.Sort(parts)
+	return parts, nil
+}
+
+// lastJSONLinesTimestamp returns the timestamp of the last row in the JSON Lines file at `path`.
+func lastJSONLinesTimestamp(path string) (time.Time, error) {
+	line, err := lastLine(path)
+	if err != nil || line == "" {
+		return time.Time{}, err
+	}
+	var row totalRow
+	err = json.Unmarshal([]byte(line), &row)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return row.Timestamp, nil
+}
+
+// lastCSVTimestamp returns the timestamp of the last record in the CSV file at `path`.
+func lastCSVTimestamp(path string) (time.Time, error) {
+	line, err := lastLine(path)
+	if err != nil || line == "" {
+		return time.Time{}, err
+	}
+	record, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil {
+		return time.Time{}, err
+	}
+	// Files with only the header have no records
+	if record[0] == "timestamp" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, record[0])
+}
+
+// lastParquetTimestamp returns the timestamp of the last row in the last completed part of the
+// Parquet directory at `path`.
+func lastParquetTimestamp(path string) (time.Time, error) {
+	parts, err := parquetParts(path)
+	if err != nil || len(parts) == 0 {
+		return time.Time{}, err
+	}
+	rows, err := parquet.ReadFile[totalRow](parts[len(parts)-1])
+	if err != nil || len(rows) == 0 {
+		return time.Time{}, err
+	}
+	return rows[len(rows)-1].Timestamp.UTC(), nil
+}
+
+// lastLine returns the last non empty line of the file at `path`, reading only its end.
+func lastLine(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// The rows of the total measurement are way shorter than this
+	size := min(info.Size(), 64*1024)
+	content := make([]byte, size)
+	_, err = file.ReadAt(content, info.Size()-size)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(string(content), "\r\n"), "\n")
+	return strings.TrimRight(lines[len(lines)-1], "\r"), nil
+}
+
+// compareLinkRows orders link rows by their source and then by their target.
+func compareLinkRows(a, b linkRow) int {
+	return cmp.Or(strings.Compare(a.Source, b.Source), strings.Compare(a.Target, b.Target))
+}
+
+// formatFileTimestamp formats `timestamp` for text based files.
+func formatFileTimestamp(timestamp time.Time) string {
+	return timestamp.Format(time.RFC3339Nano)
+}
+
+// formatUint formats `value` as a decimal string.
+func formatUint(value uint64) string {
+	return strconv.FormatUint(value, 10)
+}
@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fileTestMetrics = metrics.ZettelkastenMetrics{
+	NoteCount: 2,
+	LinkCount: 3,
+	WordCount: 15,
+	Notes: map[string]metrics.NoteMetrics{
+		"one": {Links: map[string]uint{"two": 2}, LinkCount: 2, WordCount: 10, BacklinkCount: 1},
+		"two": {Links: map[string]uint{"one": 1}, LinkCount: 1, WordCount: 5, BacklinkCount: 2},
+	},
+}
+
+func TestFileStorage_JSONLines(t *testing.T) {
+	directory := t.TempDir()
+	storage, err := NewFileStorage(directory, FileFormatJSONLines, false, MetricNaming{})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp.Add(time.Hour)))
+
+	file, err := os.Open(filepath.Join(directory, "notes.jsonl"))
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	lines := make([]map[string]any, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, lines, 4)
+	assert.Equal(t, map[string]any{
+		"timestamp":      "2024-05-29T10:30:00Z",
+		"name":           "one",
+		"link_count":     float64(2),
+		"word_count":     float64(10),
+		"backlink_count": float64(1),
+	}, lines[0])
+	assert.Equal(t, "two", lines[1]["name"])
+	assert.Equal(t, "2024-05-29T11:30:00Z", lines[2]["timestamp"])
+
+	total, err := os.ReadFile(filepath.Join(directory, "total.jsonl"))
+	require.NoError(t, err)
+	assert.Equal(t,
+		"{\"timestamp\":\"2024-05-29T10:30:00Z\",\"note_count\":2,\"link_count\":3,\"word_count\":15}\n"+
+			"{\"timestamp\":\"2024-05-29T11:30:00Z\",\"note_count\":2,\"link_count\":3,\"word_count\":15}\n",
+		string(total),
+	)
+}
+
+func TestFileStorage_Measurements(t *testing.T) {
+	tagged := fileTestMetrics
+	tagged.Tags = map[string]metrics.TagMetrics{
+		"project": {NoteCount: 2, WordCount: 15, LinkCount: 3, Links: map[string]uint{"project": 3}},
+	}
+	tasked := fileTestMetrics
+	tasked.OpenTaskCount = 2
+	tasked.CompletedTaskCount = 1
+	tasked.Notes = map[string]metrics.NoteMetrics{
+		"one": {Links: map[string]uint{}, OpenTaskCount: 2, CompletedTaskCount: 1},
+		"two": {Links: map[string]uint{}},
+	}
+	anchored := fileTestMetrics
+	anchored.AnchorLinkCount = 3
+	anchored.BrokenHeadingLinkCount = 1
+	anchored.BrokenBlockLinkCount = 1
+	anchored.Notes = map[string]metrics.NoteMetrics{
+		"one": {Links: map[string]uint{}, AnchorLinkCount: 3, BrokenHeadingLinkCount: 1, BrokenBlockLinkCount: 1},
+		"two": {Links: map[string]uint{}},
+	}
+	dated := fileTestMetrics
+	dated.Activity = metrics.ActivityMetrics{NoteCount: 2, AverageNoteAge: 48 * time.Hour, CreatedLastWeek: 1, CreatedLastMonth: 2, ModifiedLastDay: 1, ModifiedLastWeek: 2, ModifiedLastMonth: 2}
+
+	data := []struct {
+		name    string
+		format  string
+		metrics metrics.ZettelkastenMetrics
+		files   map[string]string
+	}{
+		{
+			name:    "tags",
+			format:  FileFormatJSONLines,
+			metrics: tagged,
+			files: map[string]string{
+				"tags.jsonl":      "{\"timestamp\":\"2024-05-29T11:30:00Z\",\"tag\":\"project\",\"note_count\":2,\"word_count\":15,\"link_count\":3}\n",
+				"tag_links.jsonl": "{\"timestamp\":\"2024-05-29T11:30:00Z\",\"source\":\"project\",\"target\":\"project\",\"count\":3}\n",
+			},
+		},
+		{
+			name:    "tasks",
+			format:  FileFormatJSONLines,
+			metrics: tasked,
+			files: map[string]string{
+				"tasks.jsonl":      "{\"timestamp\":\"2024-05-29T11:30:00Z\",\"open_count\":2,\"completed_count\":1}\n",
+				"note_tasks.jsonl": "{\"timestamp\":\"2024-05-29T11:30:00Z\",\"name\":\"one\",\"open_count\":2,\"completed_count\":1}\n",
+			},
+		},
+		{
+			name:    "anchors",
+			format:  FileFormatJSONLines,
+			metrics: anchored,
+			files: map[string]string{
+				"anchors.jsonl":      "{\"timestamp\":\"2024-05-29T11:30:00Z\",\"link_count\":3,\"broken_heading_count\":1,\"broken_block_count\":1}\n",
+				"note_anchors.jsonl": "{\"timestamp\":\"2024-05-29T11:30:00Z\",\"name\":\"one\",\"link_count\":3,\"broken_heading_count\":1,\"broken_block_count\":1}\n",
+			},
+		},
+		{
+			name:    "activity",
+			format:  FileFormatCSV,
+			metrics: dated,
+			files: map[string]string{
+				"activity.csv": "timestamp,note_count,average_note_age_seconds,created_last_day,created_last_week,created_last_month,modified_last_day,modified_last_week,modified_last_month\n2024-05-29T11:30:00Z,2,172800,0,1,2,1,2,2\n",
+			},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			directory := t.TempDir()
+			storage, err := NewFileStorage(directory, d.format, false, MetricNaming{})
+			require.NoError(t, err)
+
+			// The files are only created once there are metrics to write to them
+			timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+			require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp))
+			for name := range d.files {
+				_, err = os.Stat(filepath.Join(directory, name))
+				require.ErrorIs(t, err, os.ErrNotExist)
+			}
+
+			require.NoError(t, storage.WriteMetrics(context.Background(), d.metrics, timestamp.Add(time.Hour)))
+			for name, expected := range d.files {
+				content, err := os.ReadFile(filepath.Join(directory, name))
+				require.NoError(t, err)
+				assert.Equal(t, expected, string(content), name)
+			}
+		})
+	}
+}
+
+func TestFileStorage_Naming(t *testing.T) {
+	naming := MetricNaming{
+		Prefix:       "zettelkasten_",
+		Measurements: map[string]string{"total": "vault"},
+		Labels:       map[string]string{"vault": "work"},
+		NoteLabels:   map[string]string{"type": "type"},
+	}
+	labelled := fileTestMetrics
+	labelled.Notes = map[string]metrics.NoteMetrics{
+		"one": {Links: map[string]uint{"two": 2}, LinkCount: 2, WordCount: 10, BacklinkCount: 1, Frontmatter: map[string]any{"type": "idea"}},
+		"two": {Links: map[string]uint{"one": 1}, LinkCount: 1, WordCount: 5, BacklinkCount: 2},
+	}
+
+	data := []struct {
+		name   string
+		format string
+		files  map[string]string
+	}{
+		{
+			name:   "json lines",
+			format: FileFormatJSONLines,
+			files: map[string]string{
+				"zettelkasten_vault.jsonl": "{\"timestamp\":\"2024-05-29T10:30:00Z\",\"note_count\":2,\"link_count\":3,\"word_count\":15,\"labels\":{\"vault\":\"work\"}}\n",
+				"zettelkasten_notes.jsonl": "{\"timestamp\":\"2024-05-29T10:30:00Z\",\"name\":\"one\",\"link_count\":2,\"word_count\":10,\"backlink_count\":1,\"labels\":{\"type\":\"idea\",\"vault\":\"work\"}}\n" +
+					"{\"timestamp\":\"2024-05-29T10:30:00Z\",\"name\":\"two\",\"link_count\":1,\"word_count\":5,\"backlink_count\":2,\"labels\":{\"vault\":\"work\"}}\n",
+			},
+		},
+		{
+			name:   "csv",
+			format: FileFormatCSV,
+			files: map[string]string{
+				"zettelkasten_vault.csv": "timestamp,note_count,link_count,word_count,vault\n2024-05-29T10:30:00Z,2,3,15,work\n",
+				"zettelkasten_notes.csv": "timestamp,name,link_count,word_count,backlink_count,vault,type\n" +
+					"2024-05-29T10:30:00Z,one,2,10,1,work,idea\n" +
+					"2024-05-29T10:30:00Z,two,1,5,2,work,\n",
+				"zettelkasten_links.csv": "timestamp,source,target,count,vault\n" +
+					"2024-05-29T10:30:00Z,one,two,2,work\n" +
+					"2024-05-29T10:30:00Z,two,one,1,work\n",
+			},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			directory := t.TempDir()
+			storage, err := NewFileStorage(directory, d.format, false, naming)
+			require.NoError(t, err)
+
+			timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+			require.NoError(t, storage.WriteMetrics(context.Background(), labelled, timestamp))
+			for name, expected := range d.files {
+				content, err := os.ReadFile(filepath.Join(directory, name))
+				require.NoError(t, err)
+				assert.Equal(t, expected, string(content), name)
+			}
+			latest, err := storage.LatestTimestamp(context.Background())
+			require.NoError(t, err)
+			assert.True(t, timestamp.Equal(latest))
+		})
+	}
+
+	t.Run("parquet", func(t *testing.T) {
+		directory := t.TempDir()
+		storage, err := NewFileStorage(directory, FileFormatParquet, false, naming)
+		require.NoError(t, err)
+
+		timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+		require.NoError(t, storage.WriteMetrics(context.Background(), labelled, timestamp))
+		require.NoError(t, storage.Close())
+
+		notes, err := parquet.ReadFile[noteRow](filepath.Join(directory, "zettelkasten_notes", "part-000000.parquet"))
+		require.NoError(t, err)
+		require.Len(t, notes, 2)
+		assert.Equal(t, map[string]string{"vault": "work", "type": "idea"}, notes[0].Labels)
+		assert.Equal(t, map[string]string{"vault": "work"}, notes[1].Labels)
+	})
+}
+
+func TestFileStorage_CSV(t *testing.T) {
+	directory := t.TempDir()
+	storage, err := NewFileStorage(directory, FileFormatCSV, false, MetricNaming{})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp.Add(time.Hour)))
+
+	file, err := os.Open(filepath.Join(directory, "links.csv"))
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	records, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	// The header should only be written once
+	assert.Equal(t, [][]string{
+		{"timestamp", "source", "target", "count"},
+		{"2024-05-29T10:30:00Z", "one", "two", "2"},
+		{"2024-05-29T10:30:00Z", "two", "one", "1"},
+		{"2024-05-29T11:30:00Z", "one", "two", "2"},
+		{"2024-05-29T11:30:00Z", "two", "one", "1"},
+	}, records)
+}
+
+func TestFileStorage_Parquet(t *testing.T) {
+	directory := t.TempDir()
+	storage, err := NewFileStorage(directory, FileFormatParquet, false, MetricNaming{})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp.Add(time.Hour)))
+
+	// Parts are only completed on close
+	_, err = os.Stat(filepath.Join(directory, "total", "part-000000.parquet"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, storage.Close())
+
+	totals, err := parquet.ReadFile[totalRow](filepath.Join(directory, "total", "part-000000.parquet"))
+	require.NoError(t, err)
+	require.Len(t, totals, 2)
+	assert.Equal(t, timestamp, totals[0].Timestamp.UTC())
+	assert.Equal(t, []uint64{2, 3, 15}, []uint64{totals[0].NoteCount, totals[0].LinkCount, totals[0].WordCount})
+	assert.Equal(t, timestamp.Add(time.Hour), totals[1].Timestamp.UTC())
+
+	notes, err := parquet.ReadFile[noteRow](filepath.Join(directory, "notes", "part-000000.parquet"))
+	require.NoError(t, err)
+	require.Len(t, notes, 4)
+	assert.Equal(t, "one", notes[0].Name)
+	assert.Equal(t, uint64(10), notes[0].WordCount)
+	assert.Equal(t, uint64(2), notes[1].BacklinkCount)
+
+	// Writes after closing go to a new part
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp.Add(2*time.Hour)))
+	require.NoError(t, storage.Close())
+	entries, err := os.ReadDir(filepath.Join(directory, "total"))
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"part-000000.parquet", "part-000001.parquet"}, names)
+}
+
+func TestFileStorage_ParquetDailyRotation(t *testing.T) {
+	directory := t.TempDir()
+	storage, err := NewFileStorage(directory, FileFormatParquet, true, MetricNaming{})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 22, 30, 0, 0, time.UTC)
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp.Add(time.Hour)))
+	_, err = os.Stat(filepath.Join(directory, "total-2024-05-29", "part-000000.parquet"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// The parts of a day are completed once the files are rotated
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp.Add(2*time.Hour)))
+	totals, err := parquet.ReadFile[totalRow](filepath.Join(directory, "total-2024-05-29", "part-000000.parquet"))
+	require.NoError(t, err)
+	assert.Len(t, totals, 2)
+	_, err = os.Stat(filepath.Join(directory, "total-2024-05-30", "part-000000.parquet"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, storage.Close())
+}
+
+func TestFileStorage_LatestTimestamp(t *testing.T) {
+	data := []struct {
+		name          string
+		format        string
+		dailyRotation bool
+	}{
+		{name: "json lines", format: FileFormatJSONLines},
+		{name: "csv", format: FileFormatCSV},
+		{name: "parquet", format: FileFormatParquet},
+		{name: "daily rotation", format: FileFormatCSV, dailyRotation: true},
+		{name: "parquet with daily rotation", format: FileFormatParquet, dailyRotation: true},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			directory := t.TempDir()
+			storage, err := NewFileStorage(directory, d.format, d.dailyRotation, MetricNaming{})
+			require.NoError(t, err)
+			latest, err := storage.LatestTimestamp(context.Background())
+			require.NoError(t, err)
+			assert.True(t, latest.IsZero())
+
+			timestamp := time.Date(2024, 5, 29, 23, 30, 0, 0, time.UTC)
+			require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp))
+			require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp.Add(time.Hour)))
+			require.NoError(t, storage.Close())
+
+			// Reading the files of a previous run
+			storage, err = NewFileStorage(directory, d.format, d.dailyRotation, MetricNaming{})
+			require.NoError(t, err)
+			latest, err = storage.LatestTimestamp(context.Background())
+			require.NoError(t, err)
+			assert.True(t, timestamp.Add(time.Hour).Equal(latest), "expected %s, got %s", timestamp.Add(time.Hour), latest)
+		})
+	}
+}
+
+func TestFileStorage_DailyRotation(t *testing.T) {
+	directory := t.TempDir()
+	storage, err := NewFileStorage(directory, FileFormatCSV, true, MetricNaming{})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 23, 30, 0, 0, time.UTC)
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), fileTestMetrics, timestamp.Add(time.Hour)))
+
+	entries, err := os.ReadDir(directory)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{
+		"total-2024-05-29.csv", "notes-2024-05-29.csv", "links-2024-05-29.csv",
+		"total-2024-05-30.csv", "notes-2024-05-30.csv", "links-2024-05-30.csv",
+	}, names)
+}
+
+func TestNewFileStorage_UnsupportedFormat(t *testing.T) {
+	_, err := NewFileStorage(t.TempDir(), "xml", false, MetricNaming{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// The supported network protocols for Graphite.
+const (
+	GraphiteProtocolTCP = "tcp"
+	GraphiteProtocolUDP = "udp"
+)
+
+// graphiteDialTimeout is the maximum time to wait when connecting to Graphite and StatsD servers.
+const graphiteDialTimeout = time.Second * 10
+
+// maxUDPPayloadSize is the maximum size of a UDP datagram sent to Graphite and StatsD servers.
+// It's small enough to avoid IP fragmentation on common networks.
+const maxUDPPayloadSize = 1432
+
+// invalidGraphiteCharacters matches the characters that are not allowed in a Graphite path segment.
+var invalidGraphiteCharacters = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// graphiteMetric represents a single value of a metric identified by a Graphite path.
+type graphiteMetric struct {
+	path      string
+	value     float64
+	timestamp time.Time
+}
+
+// GraphiteStorage represents the implementation of a metric storage using the Graphite plaintext protocol.
+type GraphiteStorage struct {
+	protocol string
+	address  string
+	prefix   string
+	naming   MetricNaming
+}
+
+// NewGraphiteStorage creates a new `GraphiteStorage` sending metrics with `prefix` to `address` over `protocol`,
+// named and labelled according to `naming`.
+func NewGraphiteStorage(address, protocol, prefix string, naming MetricNaming) (GraphiteStorage, error) {
+	if protocol != GraphiteProtocolTCP && protocol != GraphiteProtocolUDP {
+		return GraphiteStorage{}, fmt.Errorf("unsupported Graphite protocol %q", protocol)
+	}
+	return GraphiteStorage{protocol: protocol, address: address, prefix: prefix, naming: naming}, nil
+}
+
+// WriteMetrics sends `zettelkastenMetrics` to Graphite with `timestamp`.
+func (g GraphiteStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	points := createInfluxDBPoints(g.naming, zettelkastenMetrics, timestamp)
+	graphiteMetrics, err := createGraphiteMetrics(g.prefix, g.naming, points)
+	if err != nil {
+		slog.Error("Error creating Graphite metrics", slog.Any("error", err))
+		return err
+	}
+
+	lines := make([][]byte, 0, len(graphiteMetrics))
+	for _, m := range graphiteMetrics {
+		lines = append(lines, fmt.Appendf(nil, "%s %s %d\n", m.path, formatGraphiteValue(m.value), m.timestamp.Unix()))
+	}
+
+	slog.Debug("Writing metrics to Graphite", slog.Int("metrics", len(lines)))
+	err = sendLines(ctx, g.protocol, g.address, lines)
+	if err != nil {
+		slog.Error("Error writing metrics to Graphite", slog.Any("error", err), slog.String("address", g.address))
+		return err
+	}
+	return nil
+}
+
+// LatestTimestamp is not supported by the Graphite storage, since the plaintext protocol is write only.
+func (g GraphiteStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("graphite storage: %w", errors.ErrUnsupported)
+}
+
+// createGraphiteMetrics flattens `points` into one metric per field, with paths prefixed by `prefix`.
+//
+// Paths are built from the measurement, the sanitised values of the tags and the field, so the
+// word count of the note `my note` becomes `<prefix>.notes.my_note.word_count`. The values of the
+// static labels of `naming` come right after the prefix, sorted by label name, and the frontmatter
+// labels of the notes are left out, so that the path of a note doesn't change with its frontmatter.
+func createGraphiteMetrics(prefix string, naming MetricNaming, points []*write.Point) ([]graphiteMetric, error) {
+	graphiteMetrics := make([]graphiteMetric, 0, len(points)*3)
+	for _, point := range points {
+		segments := make([]string, 0, len(point.TagList())+3)
+		if prefix != "" {
+			segments = append(segments, prefix)
+		}
+		for _, name := range naming.labelNames() {
+			segments = append(segments, sanitizeGraphiteSegment(naming.Labels[name]))
+		}
+		segments = append(segments, point.Name())
+		for _, tag := range point.TagList() {
+			if _, ok := naming.Labels[tag.Key]; ok {
+				continue
+			}
+			if _, ok := naming.NoteLabels[tag.Key]; ok {
+				continue
+			}
+			segments = append(segments, sanitizeGraphiteSegment(tag.Value))
+		}
+
+		for _, field := range point.FieldList() {
+			value, err := fieldValue(field.Value)
+			if err != nil {
+				return nil, fmt.Errorf("error converting field %s of %s: %w", field.Key, point.Name(), err)
+			}
+			graphiteMetrics = append(graphiteMetrics, graphiteMetric{
+				path:      strings.Join(append(segments, field.Key), "."),
+				value:     value,
+				timestamp: point.Time(),
+			})
+		}
+	}
+	return graphiteMetrics, nil
+}
+
+// sanitizeGraphiteSegment replaces the characters of `segment` that are not allowed in a Graphite path with underscores.
+func sanitizeGraphiteSegment(segment string) string {
+	sanitized := invalidGraphiteCharacters.ReplaceAllString(segment, "_")
+	if sanitized == "" {
+		return "_"
+	}
+	return sanitized
+}
+
+// formatGraphiteValue formats `value` for the Graphite and StatsD protocols.
+func formatGraphiteValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// sendLines sends `lines` to `address` over `protocol`.
+//
+// For TCP, all lines are sent in a single connection. For UDP, the lines are packed
+// into as few datagrams as possible without exceeding `maxUDPPayloadSize`.
+func sendLines(ctx context.Context, protocol, address string, lines [][]byte) error {
+	dialer := net.Dialer{Timeout: graphiteDialTimeout}
+	conn, err := dialer.DialContext(ctx, protocol, address)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", address, err)
+	}
+	// Interrupting blocked writes when the context is cancelled
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	var payloads [][]byte
+	if protocol == GraphiteProtocolUDP {
+		payloads = packDatagrams(lines)
+	} else {
+		payloads = [][]byte{bytes.Join(lines, nil)}
+	}
+	for _, payload := range payloads {
+		_, err = conn.Write(payload)
+		if err != nil {
+			break
+		}
+	}
+	err = errors.Join(err, conn.Close())
+	if err != nil {
+		return fmt.Errorf("error sending metrics to %s: %w", address, err)
+	}
+	return nil
+}
+
+// packDatagrams groups `lines` into payloads of at most `maxUDPPayloadSize` bytes.
+// Lines larger than the maximum size are sent in their own payload.
+func packDatagrams(lines [][]byte) [][]byte {
+	payloads := make([][]byte, 0)
+	var current []byte
+	for _, line := range lines {
+		if len(current) > 0 && len(current)+len(line) > maxUDPPayloadSize {
+			payloads = append(payloads, current)
+			current = nil
+		}
+		current = append(current, line...)
+	}
+	if len(current) > 0 {
+		payloads = append(payloads, current)
+	}
+	return payloads
+}
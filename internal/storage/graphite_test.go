@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var graphiteTestMetrics = metrics.ZettelkastenMetrics{
+	NoteCount: 1,
+	LinkCount: 2,
+	WordCount: 3,
+	Notes: map[string]metrics.NoteMetrics{
+		"my note.v2": {Links: map[string]uint{"two": 2}, LinkCount: 2, WordCount: 3, BacklinkCount: 4},
+	},
+}
+
+func TestGraphiteStorage_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		lines := make([]string, 0)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	storage, err := NewGraphiteStorage(listener.Addr().String(), GraphiteProtocolTCP, "zettelkasten", MetricNaming{})
+	require.NoError(t, err)
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	require.NoError(t, storage.WriteMetrics(context.Background(), graphiteTestMetrics, timestamp))
+
+	lines := <-received
+	sort.Strings(lines)
+	assert.Equal(t, []string{
+		"zettelkasten.notes.my_note_v2.backlink_count 4 1716978600",
+		"zettelkasten.notes.my_note_v2.link_count 2 1716978600",
+		"zettelkasten.notes.my_note_v2.word_count 3 1716978600",
+		"zettelkasten.total.link_count 2 1716978600",
+		"zettelkasten.total.note_count 1 1716978600",
+		"zettelkasten.total.word_count 3 1716978600",
+	}, lines)
+}
+
+func TestGraphiteStorage_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	storage, err := NewGraphiteStorage(conn.LocalAddr().String(), GraphiteProtocolUDP, "", MetricNaming{})
+	require.NoError(t, err)
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	require.NoError(t, storage.WriteMetrics(context.Background(), graphiteTestMetrics, timestamp))
+
+	lines := readDatagramLines(t, conn, 6)
+	assert.Contains(t, lines, "total.note_count 1 1716978600")
+	assert.Contains(t, lines, "notes.my_note_v2.word_count 3 1716978600")
+}
+
+func TestCreateGraphiteMetrics_MetricNaming(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	naming := MetricNaming{
+		Measurements: map[string]string{"notes": "zettels"},
+		Labels:       map[string]string{"vault": "work", "owner": "team a"},
+		NoteLabels:   map[string]string{"type": "type"},
+	}
+	points := createNotePoints(naming, map[string]metrics.NoteMetrics{"one": {WordCount: 3, Frontmatter: map[string]any{"type": "idea"}}}, timestamp)
+
+	graphiteMetrics, err := createGraphiteMetrics("zettelkasten", naming, points)
+	require.NoError(t, err)
+	paths := make([]string, 0, len(graphiteMetrics))
+	for _, m := range graphiteMetrics {
+		paths = append(paths, m.path)
+	}
+	assert.Equal(t, []string{
+		"zettelkasten.team_a.work.zettels.one.backlink_count",
+		"zettelkasten.team_a.work.zettels.one.link_count",
+		"zettelkasten.team_a.work.zettels.one.word_count",
+	}, paths)
+}
+
+func TestNewGraphiteStorage_UnsupportedProtocol(t *testing.T) {
+	_, err := NewGraphiteStorage("localhost:2003", "http", "zettelkasten", MetricNaming{})
+	assert.Error(t, err)
+}
+
+func TestStatsDStorage(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	storage := NewStatsDStorage(conn.LocalAddr().String(), "zettelkasten", MetricNaming{})
+	require.NoError(t, storage.WriteMetrics(context.Background(), graphiteTestMetrics, time.Now()))
+
+	lines := readDatagramLines(t, conn, 6)
+	sort.Strings(lines)
+	assert.Equal(t, []string{
+		"zettelkasten.notes.my_note_v2.backlink_count:4|g",
+		"zettelkasten.notes.my_note_v2.link_count:2|g",
+		"zettelkasten.notes.my_note_v2.word_count:3|g",
+		"zettelkasten.total.link_count:2|g",
+		"zettelkasten.total.note_count:1|g",
+		"zettelkasten.total.word_count:3|g",
+	}, lines)
+}
+
+func TestSanitizeGraphiteSegment(t *testing.T) {
+	assert.Equal(t, "my_note", sanitizeGraphiteSegment("my note"))
+	assert.Equal(t, "dir_sub_note_md", sanitizeGraphiteSegment("dir/sub/note.md"))
+	assert.Equal(t, "caf_", sanitizeGraphiteSegment("café"))
+	assert.Equal(t, "_", sanitizeGraphiteSegment(""))
+}
+
+func TestPackDatagrams(t *testing.T) {
+	line := []byte(strings.Repeat("a", 500) + "\n")
+	payloads := packDatagrams([][]byte{line, line, line, line})
+	require.Len(t, payloads, 2)
+	assert.Len(t, payloads[0], 1002)
+	assert.Len(t, payloads[1], 1002)
+}
+
+// readDatagramLines reads datagrams from `conn` until `count` lines are received.
+func readDatagramLines(t *testing.T, conn net.PacketConn, count int) []string {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second*5)))
+	lines := make([]string, 0, count)
+	buffer := make([]byte, 65536)
+	for len(lines) < count {
+		n, _, err := conn.ReadFrom(buffer)
+		require.NoError(t, err)
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(buffer[:n]), "\n"), "\n")...)
+	}
+	return lines
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// maxErrorBodySize is the maximum number of bytes read from an error response body.
+const maxErrorBodySize = 1024
+
+// HTTPOptions represents the options used when sending requests to HTTP based storages.
+type HTTPOptions struct {
+	Username           string
+	Password           string
+	BearerToken        string
+	Headers            map[string]string
+	Timeout            time.Duration
+	CAFile             string
+	InsecureSkipVerify bool
+	Gzip               bool
+}
+
+// newHTTPClient creates an HTTP client with the timeout and TLS settings from `o`.
+func (o HTTPOptions) newHTTPClient() (*http.Client, error) {
+	tlsConfig, err := o.newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: o.Timeout, Transport: transport}, nil
+}
+
+// newTLSConfig creates the TLS config trusting the CA certificates and skipping verification according to `o`.
+func (o HTTPOptions) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.CAFile != "" {
+		ca, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("error parsing CA file: no certificates found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// newGetRequest creates a GET request to `url` bound to `ctx`, with the authentication and headers from `o`.
+func (o HTTPOptions) newGetRequest(ctx context.Context, url string) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	o.apply(request)
+	return request, nil
+}
+
+// newRequest creates a POST request to `url` bound to `ctx` with `content`, compressing it if enabled in `o`.
+func (o HTTPOptions) newRequest(ctx context.Context, url, contentType string, content []byte) (*http.Request, error) {
+	if o.Gzip {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		_, err := writer.Write(content)
+		if err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error compressing request body: %w", err)
+		}
+		content = buffer.Bytes()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	o.apply(request)
+	request.Header.Set("Content-Type", contentType)
+	if o.Gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	return request, nil
+}
+
+// apply sets the authentication and custom headers from `o` on `request`.
+func (o HTTPOptions) apply(request *http.Request) {
+	for key, value := range o.Headers {
+		request.Header.Set(key, value)
+	}
+	if o.Username != "" || o.Password != "" {
+		request.SetBasicAuth(o.Username, o.Password)
+	}
+	if o.BearerToken != "" {
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.BearerToken))
+	}
+}
+
+// sendRequest sends `request` with `client`, returning an error if the request fails or does not succeed.
+func sendRequest(client *http.Client, request *http.Request) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+	return checkResponse(response)
+}
+
+// fetchResponse sends `request` with `client`, returning the response body if the request succeeds.
+func fetchResponse(client *http.Client, request *http.Request) ([]byte, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	err = checkResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	return body, nil
+}
+
+// checkResponse returns an error containing the response body if `response` does not have a 2xx status code.
+func checkResponse(response *http.Response) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+	if err != nil {
+		return fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+	return fmt.Errorf("unexpected status code %d: %s", response.StatusCode, string(body))
+}
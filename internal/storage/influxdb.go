@@ -2,12 +2,20 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
 
 	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
 )
@@ -15,39 +23,152 @@ import (
 // The measurement names to be used for metrics within the InfluxDB bucket.
 const notesMeasurementName = "notes"
 const totalMeasurementName = "total"
+const deletedNotesMeasurementName = "deleted_notes"
+const tagsMeasurementName = "tags"
+const tagLinksMeasurementName = "tag_links"
+const activityMeasurementName = "activity"
+const tasksMeasurementName = "tasks"
+const noteTasksMeasurementName = "note_tasks"
+const anchorsMeasurementName = "anchors"
+const noteAnchorsMeasurementName = "note_anchors"
+
+// influxDBWriter writes points to an InfluxDB server.
+type influxDBWriter interface {
+	WritePoint(ctx context.Context, points ...*write.Point) error
+}
+
+// influxDBQuerier queries an InfluxDB server using the query language of its version.
+type influxDBQuerier interface {
+	// latestTimestamp returns the timestamp of the latest point in the total measurement.
+	latestTimestamp(ctx context.Context) (time.Time, error)
+}
 
 // InfluxDBStorage represents the implementation of a metric storage using InfluxDB.
+//
+// The same measurements are written regardless of the InfluxDB version, so
+// queries only differ by the language supported by each version.
 type InfluxDBStorage struct {
-	writeAPI api.WriteAPIBlocking
-	queryAPI api.QueryAPI
+	writeAPI influxDBWriter
+	queryAPI influxDBQuerier
+	naming   MetricNaming
 }
 
-// NewInfluxDBStorage creates a new `InfluxDBStorage`.
-func NewInfluxDBStorage(url, org, bucket, token string) InfluxDBStorage {
+// NewInfluxDBStorage creates a new `InfluxDBStorage` for InfluxDB 2.x.
+func NewInfluxDBStorage(url, org, bucket, token string, naming MetricNaming) InfluxDBStorage {
 	client := influxdb2.NewClient(url, string(token))
 	writeAPI := client.WriteAPIBlocking(org, bucket)
-	queryAPI := client.QueryAPI(org)
-	return InfluxDBStorage{writeAPI: writeAPI, queryAPI: queryAPI}
+	queryAPI := fluxQuerier{queryAPI: client.QueryAPI(org), bucket: bucket, naming: naming}
+	return InfluxDBStorage{writeAPI: writeAPI, queryAPI: queryAPI, naming: naming}
+}
+
+// NewInfluxDBV1Storage creates a new `InfluxDBStorage` for InfluxDB 1.x, writing to `database`
+// using `retentionPolicy`. When `retentionPolicy` is empty, the default retention policy of the
+// database is used. The username and password from `options` are sent using basic auth.
+func NewInfluxDBV1Storage(baseUrl, database, retentionPolicy string, options HTTPOptions, naming MetricNaming) (InfluxDBStorage, error) {
+	query := url.Values{}
+	query.Set("db", database)
+	if retentionPolicy != "" {
+		query.Set("rp", retentionPolicy)
+	}
+	query.Set("precision", "ms")
+	// InfluxDB 1.x doesn't support unsigned integer fields by default
+	writer, err := newLineProtocolWriter(baseUrl, "/write", query, options, lp.FieldTypeSupport(0))
+	if err != nil {
+		return InfluxDBStorage{}, err
+	}
+
+	measurement := influxQLIdentifier(naming.measurement(totalMeasurementName))
+	if retentionPolicy != "" {
+		measurement = fmt.Sprintf("%s.%s", influxQLIdentifier(retentionPolicy), measurement)
+	}
+	conditions := make([]string, 0, len(naming.Labels))
+	for _, name := range naming.labelNames() {
+		conditions = append(conditions, fmt.Sprintf("%s = %s", influxQLIdentifier(name), influxQLString(naming.Labels[name])))
+	}
+	statement := fmt.Sprintf("SELECT last(\"note_count\") FROM %s", measurement)
+	if len(conditions) > 0 {
+		statement = fmt.Sprintf("%s WHERE %s", statement, strings.Join(conditions, " AND "))
+	}
+	queryUrl, err := url.JoinPath(baseUrl, "query")
+	if err != nil {
+		return InfluxDBStorage{}, fmt.Errorf("error parsing InfluxDB URL: %w", err)
+	}
+	querier := influxQLQuerier{
+		url: fmt.Sprintf("%s?%s", queryUrl, url.Values{
+			"db":    {database},
+			"epoch": {"ms"},
+			"q":     {statement},
+		}.Encode()),
+		client:  writer.client,
+		options: options,
+	}
+	return InfluxDBStorage{writeAPI: writer, queryAPI: querier, naming: naming}, nil
+}
+
+// NewInfluxDBV3Storage creates a new `InfluxDBStorage` for InfluxDB 3, writing to `database`
+// and authenticating with `token`.
+func NewInfluxDBV3Storage(baseUrl, database, token string, options HTTPOptions, naming MetricNaming) (InfluxDBStorage, error) {
+	query := url.Values{}
+	query.Set("db", database)
+	query.Set("precision", "millisecond")
+	options.BearerToken = token
+	writer, err := newLineProtocolWriter(baseUrl, "/api/v3/write_lp", query, options, lp.UintSupport)
+	if err != nil {
+		return InfluxDBStorage{}, err
+	}
+
+	queryUrl, err := url.JoinPath(baseUrl, "api", "v3", "query_sql")
+	if err != nil {
+		return InfluxDBStorage{}, fmt.Errorf("error parsing InfluxDB URL: %w", err)
+	}
+	querier := influxSQLQuerier{url: queryUrl, database: database, client: writer.client, options: options, naming: naming}
+	return InfluxDBStorage{writeAPI: writer, queryAPI: querier, naming: naming}, nil
+}
+
+// newLineProtocolWriter creates a new `lineProtocolWriter` writing to `path` of `baseUrl` with `query`.
+func newLineProtocolWriter(baseUrl, path string, query url.Values, options HTTPOptions, fieldTypeSupport lp.FieldTypeSupport) (lineProtocolWriter, error) {
+	writeUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return lineProtocolWriter{}, fmt.Errorf("error parsing InfluxDB URL: %w", err)
+	}
+	writeUrl = writeUrl.JoinPath(path)
+	writeUrl.RawQuery = query.Encode()
+
+	client, err := options.newHTTPClient()
+	if err != nil {
+		return lineProtocolWriter{}, err
+	}
+	return lineProtocolWriter{url: writeUrl.String(), client: client, options: options, fieldTypeSupport: fieldTypeSupport}, nil
 }
 
 // WriteMetric writes `metric` for `noteName` to the storage with `timestamp`.
-func (i InfluxDBStorage) WriteMetrics(zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
-	points := createInfluxDBPoints(zettelkastenMetrics, timestamp)
+func (i InfluxDBStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	points := createInfluxDBPoints(i.naming, zettelkastenMetrics, timestamp)
 	slog.Debug("Writing metrics to InfluxDB", slog.Any("points", points))
-	err := i.writeAPI.WritePoint(context.Background(), points...)
+	err := i.writeAPI.WritePoint(ctx, points...)
 	if err != nil {
 		slog.Error("Error writing points to InfluxDB storage", slog.Any("error", err))
 	}
 	return err
 }
 
-// createInfluxDBPoints creates a slice of InfluxDB measurement points from `zettelkastenMetrics` with the given `timestamp`.
-func createInfluxDBPoints(zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) []*write.Point {
-	points := make([]*write.Point, 0, len(zettelkastenMetrics.Notes)+1)
+// LatestTimestamp returns the timestamp of the latest aggregated metrics in InfluxDB.
+func (i InfluxDBStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	timestamp, err := i.queryAPI.latestTimestamp(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error querying InfluxDB: %w", err)
+	}
+	return timestamp, nil
+}
+
+// createInfluxDBPoints creates a slice of InfluxDB measurement points from `zettelkastenMetrics` with the given `timestamp`,
+// named and labelled according to `naming`.
+func createInfluxDBPoints(naming MetricNaming, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) []*write.Point {
+	points := make([]*write.Point, 0, len(zettelkastenMetrics.Notes)+len(zettelkastenMetrics.DeletedNotes)+4)
 	// Aggregated metrics
 	point := influxdb2.NewPoint(
-		totalMeasurementName,
-		map[string]string{},
+		naming.measurement(totalMeasurementName),
+		naming.tags(map[string]string{}),
 		map[string]interface{}{
 			"note_count": zettelkastenMetrics.NoteCount,
 			"link_count": zettelkastenMetrics.LinkCount,
@@ -56,12 +177,63 @@ func createInfluxDBPoints(zettelkastenMetrics metrics.ZettelkastenMetrics, times
 		timestamp,
 	)
 	points = append(points, point)
+	if zettelkastenMetrics.OpenTaskCount+zettelkastenMetrics.CompletedTaskCount > 0 {
+		point = influxdb2.NewPoint(
+			naming.measurement(tasksMeasurementName),
+			naming.tags(map[string]string{}),
+			map[string]interface{}{
+				"open_count":      zettelkastenMetrics.OpenTaskCount,
+				"completed_count": zettelkastenMetrics.CompletedTaskCount,
+			},
+			timestamp,
+		)
+		points = append(points, point)
+	}
+	if zettelkastenMetrics.AnchorLinkCount > 0 {
+		point = influxdb2.NewPoint(
+			naming.measurement(anchorsMeasurementName),
+			naming.tags(map[string]string{}),
+			map[string]interface{}{
+				"link_count":           zettelkastenMetrics.AnchorLinkCount,
+				"broken_heading_count": zettelkastenMetrics.BrokenHeadingLinkCount,
+				"broken_block_count":   zettelkastenMetrics.BrokenBlockLinkCount,
+			},
+			timestamp,
+		)
+		points = append(points, point)
+	}
+	if zettelkastenMetrics.Activity.NoteCount > 0 {
+		points = append(points, createActivityPoint(naming, zettelkastenMetrics.Activity, timestamp))
+	}
 
 	// Individual note metrics
-	for name, metric := range zettelkastenMetrics.Notes {
+	points = append(points, createNotePoints(naming, zettelkastenMetrics.Notes, timestamp)...)
+
+	// Tag metrics
+	points = append(points, createTagPoints(naming, zettelkastenMetrics.Tags, timestamp)...)
+
+	// Deletion events
+	for name, metric := range zettelkastenMetrics.DeletedNotes {
 		point = influxdb2.NewPoint(
-			notesMeasurementName,
-			map[string]string{"name": name},
+			naming.measurement(deletedNotesMeasurementName),
+			naming.noteTags(name, metric),
+			map[string]interface{}{"deleted": true},
+			timestamp,
+		)
+		points = append(points, point)
+	}
+	return points
+}
+
+// createNotePoints creates one InfluxDB measurement point for each note in `notes`, plus one for the
+// tasks of the notes with task lists and one for the anchor links of the notes linking to anchors,
+// with the given `timestamp`, named and labelled according to `naming`.
+func createNotePoints(naming MetricNaming, notes map[string]metrics.NoteMetrics, timestamp time.Time) []*write.Point {
+	points := make([]*write.Point, 0, len(notes))
+	for name, metric := range notes {
+		point := influxdb2.NewPoint(
+			naming.measurement(notesMeasurementName),
+			naming.noteTags(name, metric),
 			map[string]interface{}{
 				"link_count":     metric.LinkCount,
 				"word_count":     metric.WordCount,
@@ -70,6 +242,255 @@ func createInfluxDBPoints(zettelkastenMetrics metrics.ZettelkastenMetrics, times
 			timestamp,
 		)
 		points = append(points, point)
+		if metric.OpenTaskCount+metric.CompletedTaskCount > 0 {
+			point = influxdb2.NewPoint(
+				naming.measurement(noteTasksMeasurementName),
+				naming.noteTags(name, metric),
+				map[string]interface{}{
+					"open_count":      metric.OpenTaskCount,
+					"completed_count": metric.CompletedTaskCount,
+				},
+				timestamp,
+			)
+			points = append(points, point)
+		}
+		if metric.AnchorLinkCount > 0 {
+			point = influxdb2.NewPoint(
+				naming.measurement(noteAnchorsMeasurementName),
+				naming.noteTags(name, metric),
+				map[string]interface{}{
+					"link_count":           metric.AnchorLinkCount,
+					"broken_heading_count": metric.BrokenHeadingLinkCount,
+					"broken_block_count":   metric.BrokenBlockLinkCount,
+				},
+				timestamp,
+			)
+			points = append(points, point)
+		}
 	}
 	return points
 }
+
+// createActivityPoint creates the InfluxDB measurement point of the aggregated creation and modification
+// dates of the notes with the given `timestamp`, named and labelled according to `naming`.
+func createActivityPoint(naming MetricNaming, activity metrics.ActivityMetrics, timestamp time.Time) *write.Point {
+	return influxdb2.NewPoint(
+		naming.measurement(activityMeasurementName),
+		naming.tags(map[string]string{}),
+		map[string]interface{}{
+			"note_count":               activity.NoteCount,
+			"average_note_age_seconds": uint(activity.AverageNoteAge / time.Second),
+			"created_last_day":         activity.CreatedLastDay,
+			"created_last_week":        activity.CreatedLastWeek,
+			"created_last_month":       activity.CreatedLastMonth,
+			"modified_last_day":        activity.ModifiedLastDay,
+			"modified_last_week":       activity.ModifiedLastWeek,
+			"modified_last_month":      activity.ModifiedLastMonth,
+		},
+		timestamp,
+	)
+}
+
+// createTagPoints creates one InfluxDB measurement point for each tag in `tags` and for each pair
+// of tags linked by their notes with the given `timestamp`, named and labelled according to `naming`.
+func createTagPoints(naming MetricNaming, tags map[string]metrics.TagMetrics, timestamp time.Time) []*write.Point {
+	points := make([]*write.Point, 0, len(tags))
+	for tag, metric := range tags {
+		point := influxdb2.NewPoint(
+			naming.measurement(tagsMeasurementName),
+			naming.tags(map[string]string{"tag": tag}),
+			map[string]interface{}{
+				"note_count": metric.NoteCount,
+				"word_count": metric.WordCount,
+				"link_count": metric.LinkCount,
+			},
+			timestamp,
+		)
+		points = append(points, point)
+		for target, count := range metric.Links {
+			point = influxdb2.NewPoint(
+				naming.measurement(tagLinksMeasurementName),
+				naming.tags(map[string]string{"source": tag, "target": target}),
+				map[string]interface{}{"count": count},
+				timestamp,
+			)
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// lineProtocolWriter writes points encoded in the line protocol to an HTTP endpoint.
+type lineProtocolWriter struct {
+	url              string
+	client           *http.Client
+	options          HTTPOptions
+	fieldTypeSupport lp.FieldTypeSupport
+}
+
+// WritePoint writes `points` to the endpoint of `w`.
+func (w lineProtocolWriter) WritePoint(ctx context.Context, points ...*write.Point) error {
+	content, err := encodePoints(points, w.fieldTypeSupport)
+	if err != nil {
+		return fmt.Errorf("error encoding points into line protocol: %w", err)
+	}
+	request, err := w.options.newRequest(ctx, w.url, "text/plain; charset=utf-8", content)
+	if err != nil {
+		return err
+	}
+	return sendRequest(w.client, request)
+}
+
+// fluxQuerier queries InfluxDB 2.x using Flux.
+type fluxQuerier struct {
+	queryAPI api.QueryAPI
+	bucket   string
+	naming   MetricNaming
+}
+
+func (q fluxQuerier) latestTimestamp(ctx context.Context) (time.Time, error) {
+	predicate := fmt.Sprintf(`r._measurement == %s and r._field == "note_count"`, strconv.Quote(q.naming.measurement(totalMeasurementName)))
+	for _, name := range q.naming.labelNames() {
+		predicate += fmt.Sprintf(" and r[%s] == %s", strconv.Quote(name), strconv.Quote(q.naming.Labels[name]))
+	}
+	query := fmt.Sprintf(`from(bucket: %s) |> range(start: 0) |> filter(fn: (r) => %s) |> last()`, strconv.Quote(q.bucket), predicate)
+	result, err := q.queryAPI.Query(ctx, query)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = result.Close() }()
+
+	var latest time.Time
+	for result.Next() {
+		if result.Record().Time().After(latest) {
+			latest = result.Record().Time()
+		}
+	}
+	return latest, result.Err()
+}
+
+// influxQLResponse represents the response of the InfluxDB 1.x query API.
+type influxQLResponse struct {
+	Results []struct {
+		Error  string `json:"error"`
+		Series []struct {
+			Values [][]any `json:"values"`
+		} `json:"series"`
+	} `json:"results"`
+}
+
+// influxQLQuerier queries InfluxDB 1.x using InfluxQL.
+type influxQLQuerier struct {
+	url     string
+	client  *http.Client
+	options HTTPOptions
+}
+
+func (q influxQLQuerier) latestTimestamp(ctx context.Context) (time.Time, error) {
+	request, err := q.options.newGetRequest(ctx, q.url)
+	if err != nil {
+		return time.Time{}, err
+	}
+	body, err := fetchResponse(q.client, request)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var response influxQLResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error decoding response: %w", err)
+	}
+	var latest time.Time
+	for _, result := range response.Results {
+		if result.Error != "" {
+			return time.Time{}, errors.New(result.Error)
+		}
+		for _, series := range result.Series {
+			for _, values := range series.Values {
+				// Timestamps are returned as unix milliseconds
+				milliseconds, ok := values[0].(float64)
+				if !ok {
+					return time.Time{}, fmt.Errorf("unexpected timestamp in response: %v", values[0])
+				}
+				if timestamp := time.UnixMilli(int64(milliseconds)); timestamp.After(latest) {
+					latest = timestamp
+				}
+			}
+		}
+	}
+	return latest, nil
+}
+
+// influxQLIdentifier quotes `name` as an InfluxQL identifier.
+func influxQLIdentifier(name string) string {
+	return fmt.Sprintf(`"%s"`, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name))
+}
+
+// influxQLString quotes `value` as an InfluxQL string literal.
+func influxQLString(value string) string {
+	return fmt.Sprintf(`'%s'`, strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value))
+}
+
+// influxSQLQuerier queries InfluxDB 3 using SQL.
+type influxSQLQuerier struct {
+	url      string
+	database string
+	client   *http.Client
+	options  HTTPOptions
+	naming   MetricNaming
+}
+
+func (q influxSQLQuerier) latestTimestamp(ctx context.Context) (time.Time, error) {
+	statement := fmt.Sprintf(`SELECT max(time) AS time FROM %s`, sqlIdentifier(q.naming.measurement(totalMeasurementName)))
+	conditions := make([]string, 0, len(q.naming.Labels))
+	for _, name := range q.naming.labelNames() {
+		conditions = append(conditions, fmt.Sprintf("%s = %s", sqlIdentifier(name), sqlString(q.naming.Labels[name])))
+	}
+	if len(conditions) > 0 {
+		statement = fmt.Sprintf("%s WHERE %s", statement, strings.Join(conditions, " AND "))
+	}
+	content, err := json.Marshal(map[string]string{
+		"db":     q.database,
+		"q":      statement,
+		"format": "json",
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error encoding query: %w", err)
+	}
+	request, err := q.options.newRequest(ctx, q.url, "application/json", content)
+	if err != nil {
+		return time.Time{}, err
+	}
+	body, err := fetchResponse(q.client, request)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var rows []struct {
+		Time *string `json:"time"`
+	}
+	err = json.Unmarshal(body, &rows)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error decoding response: %w", err)
+	}
+	if len(rows) == 0 || rows[0].Time == nil {
+		return time.Time{}, nil
+	}
+	// Timestamps are returned in UTC without a time zone
+	timestamp, err := time.Parse("2006-01-02T15:04:05.999999999", *rows[0].Time)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing timestamp: %w", err)
+	}
+	return timestamp, nil
+}
+
+// sqlIdentifier quotes `name` as an SQL identifier.
+func sqlIdentifier(name string) string {
+	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(name, `"`, `""`))
+}
+
+// sqlString quotes `value` as an SQL string literal.
+func sqlString(value string) string {
+	return fmt.Sprintf(`'%s'`, strings.ReplaceAll(value, `'`, `''`))
+}
@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var influxDBTestMetrics = metrics.ZettelkastenMetrics{
+	NoteCount: 1,
+	LinkCount: 2,
+	WordCount: 3,
+	Notes: map[string]metrics.NoteMetrics{
+		"one": {Links: map[string]uint{"two": 2}, LinkCount: 2, WordCount: 3, BacklinkCount: 4},
+	},
+}
+
+func TestInfluxDBV1Storage(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var lines []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/write", r.URL.Path)
+		assert.Equal(t, "zettelkasten", r.URL.Query().Get("db"))
+		assert.Equal(t, "one_year", r.URL.Query().Get("rp"))
+		assert.Equal(t, "ms", r.URL.Query().Get("precision"))
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "any-user", username)
+		assert.Equal(t, "any-password", password)
+		content, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		lines = strings.Split(strings.TrimSpace(string(content)), "\n")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	storage, err := NewInfluxDBV1Storage(server.URL, "zettelkasten", "one_year", HTTPOptions{Username: "any-user", Password: "any-password"}, MetricNaming{})
+	require.NoError(t, err)
+	require.NoError(t, storage.WriteMetrics(context.Background(), influxDBTestMetrics, timestamp))
+
+	sort.Strings(lines)
+	assert.Equal(t, []string{
+		"notes,name=one backlink_count=4i,link_count=2i,word_count=3i 1716978600000",
+		"total link_count=2i,note_count=1i,word_count=3i 1716978600000",
+	}, lines)
+}
+
+func TestInfluxDBV1Storage_Measurements(t *testing.T) {
+	data := []struct {
+		name     string
+		metrics  metrics.ZettelkastenMetrics
+		expected []string
+	}{
+		{
+			name:    "deleted notes",
+			metrics: metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}, DeletedNotes: map[string]metrics.NoteMetrics{"gone": {}}},
+			expected: []string{
+				"deleted_notes,name=gone deleted=true 1716978600000",
+				"total link_count=0i,note_count=0i,word_count=0i 1716978600000",
+			},
+		},
+		{
+			name: "tags",
+			metrics: metrics.ZettelkastenMetrics{
+				Notes: map[string]metrics.NoteMetrics{},
+				Tags: map[string]metrics.TagMetrics{
+					"project/alpha": {NoteCount: 2, WordCount: 15, LinkCount: 3, Links: map[string]uint{"project/alpha": 1, "idea": 2}},
+				},
+			},
+			expected: []string{
+				"tag_links,source=project/alpha,target=idea count=2i 1716978600000",
+				"tag_links,source=project/alpha,target=project/alpha count=1i 1716978600000",
+				"tags,tag=project/alpha link_count=3i,note_count=2i,word_count=15i 1716978600000",
+				"total link_count=0i,note_count=0i,word_count=0i 1716978600000",
+			},
+		},
+		{
+			// Only notes with tasks get task series
+			name: "tasks",
+			metrics: metrics.ZettelkastenMetrics{
+				NoteCount:          2,
+				OpenTaskCount:      3,
+				CompletedTaskCount: 1,
+				Notes: map[string]metrics.NoteMetrics{
+					"one": {Links: map[string]uint{}, OpenTaskCount: 3, CompletedTaskCount: 1},
+					"two": {Links: map[string]uint{}},
+				},
+			},
+			expected: []string{
+				"note_tasks,name=one completed_count=1i,open_count=3i 1716978600000",
+				"notes,name=one backlink_count=0i,link_count=0i,word_count=0i 1716978600000",
+				"notes,name=two backlink_count=0i,link_count=0i,word_count=0i 1716978600000",
+				"tasks completed_count=1i,open_count=3i 1716978600000",
+				"total link_count=0i,note_count=2i,word_count=0i 1716978600000",
+			},
+		},
+		{
+			// Only notes linking to anchors get anchor series
+			name: "anchors",
+			metrics: metrics.ZettelkastenMetrics{
+				NoteCount:              2,
+				AnchorLinkCount:        3,
+				BrokenHeadingLinkCount: 1,
+				BrokenBlockLinkCount:   1,
+				Notes: map[string]metrics.NoteMetrics{
+					"one": {Links: map[string]uint{}, AnchorLinkCount: 3, BrokenHeadingLinkCount: 1, BrokenBlockLinkCount: 1},
+					"two": {Links: map[string]uint{}},
+				},
+			},
+			expected: []string{
+				"anchors broken_block_count=1i,broken_heading_count=1i,link_count=3i 1716978600000",
+				"note_anchors,name=one broken_block_count=1i,broken_heading_count=1i,link_count=3i 1716978600000",
+				"notes,name=one backlink_count=0i,link_count=0i,word_count=0i 1716978600000",
+				"notes,name=two backlink_count=0i,link_count=0i,word_count=0i 1716978600000",
+				"total link_count=0i,note_count=2i,word_count=0i 1716978600000",
+			},
+		},
+		{
+			name: "activity",
+			metrics: metrics.ZettelkastenMetrics{
+				Notes: map[string]metrics.NoteMetrics{},
+				Activity: metrics.ActivityMetrics{
+					NoteCount:         3,
+					AverageNoteAge:    36 * time.Hour,
+					CreatedLastDay:    1,
+					CreatedLastWeek:   2,
+					CreatedLastMonth:  3,
+					ModifiedLastDay:   2,
+					ModifiedLastWeek:  3,
+					ModifiedLastMonth: 3,
+				},
+			},
+			expected: []string{
+				"activity average_note_age_seconds=129600i,created_last_day=1i,created_last_month=3i,created_last_week=2i,modified_last_day=2i,modified_last_month=3i,modified_last_week=3i,note_count=3i 1716978600000",
+				"total link_count=0i,note_count=0i,word_count=0i 1716978600000",
+			},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+			var lines []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				content, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				lines = strings.Split(strings.TrimSpace(string(content)), "\n")
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			storage, err := NewInfluxDBV1Storage(server.URL, "zettelkasten", "", HTTPOptions{}, MetricNaming{})
+			require.NoError(t, err)
+			require.NoError(t, storage.WriteMetrics(context.Background(), d.metrics, timestamp))
+
+			sort.Strings(lines)
+			assert.Equal(t, d.expected, lines)
+		})
+	}
+}
+
+func TestInfluxDBV3Storage(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var lines []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/write_lp", r.URL.Path)
+		assert.Equal(t, "zettelkasten", r.URL.Query().Get("db"))
+		assert.Equal(t, "millisecond", r.URL.Query().Get("precision"))
+		assert.Equal(t, "Bearer any-token", r.Header.Get("Authorization"))
+		content, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		lines = strings.Split(strings.TrimSpace(string(content)), "\n")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	storage, err := NewInfluxDBV3Storage(server.URL, "zettelkasten", "any-token", HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	require.NoError(t, storage.WriteMetrics(context.Background(), influxDBTestMetrics, timestamp))
+
+	sort.Strings(lines)
+	assert.Equal(t, []string{
+		"notes,name=one backlink_count=4u,link_count=2u,word_count=3u 1716978600000",
+		"total link_count=2u,note_count=1u,word_count=3u 1716978600000",
+	}, lines)
+}
+
+func TestInfluxDBV1Storage_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"database not found: \"zettelkasten\""}`))
+	}))
+	defer server.Close()
+
+	storage, err := NewInfluxDBV1Storage(server.URL, "zettelkasten", "", HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), influxDBTestMetrics, time.Now())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "database not found")
+}
+
+func TestInfluxDBStorage_LatestTimestamp(t *testing.T) {
+	expected := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/query", r.URL.Path)
+		assert.Equal(t, "any-org", r.URL.Query().Get("org"))
+		content, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `from(bucket: \"any-bucket\")`)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		_, _ = w.Write([]byte("#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,unsignedLong,string,string\n" +
+			"#group,false,false,true,true,false,false,true,true\n" +
+			"#default,_result,,,,,,,\n" +
+			",result,table,_start,_stop,_time,_value,_field,_measurement\n" +
+			",,0,1970-01-01T00:00:00Z,2024-06-01T00:00:00Z,2024-05-29T10:30:00Z,1,note_count,total\n\n"))
+	}))
+	defer server.Close()
+
+	storage := NewInfluxDBStorage(server.URL, "any-org", "any-bucket", "any-token", MetricNaming{})
+	latest, err := storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.True(t, expected.Equal(latest), "expected %s, got %s", expected, latest)
+}
+
+func TestInfluxDBV1Storage_LatestTimestamp(t *testing.T) {
+	data := []struct {
+		name        string
+		response    string
+		expected    time.Time
+		shouldError bool
+	}{
+		{
+			name:     "with metrics",
+			response: `{"results":[{"statement_id":0,"series":[{"name":"total","columns":["time","last"],"values":[[1716978600000,1]]}]}]}`,
+			expected: time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "without metrics",
+			response: `{"results":[{"statement_id":0}]}`,
+			expected: time.Time{},
+		},
+		{
+			name:        "error",
+			response:    `{"results":[{"statement_id":0,"error":"database not found: zettelkasten"}]}`,
+			shouldError: true,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/query", r.URL.Path)
+				assert.Equal(t, "zettelkasten", r.URL.Query().Get("db"))
+				assert.Equal(t, "ms", r.URL.Query().Get("epoch"))
+				assert.Equal(t, `SELECT last("note_count") FROM "one_year"."total"`, r.URL.Query().Get("q"))
+				_, _ = w.Write([]byte(d.response))
+			}))
+			defer server.Close()
+
+			storage, err := NewInfluxDBV1Storage(server.URL, "zettelkasten", "one_year", HTTPOptions{}, MetricNaming{})
+			require.NoError(t, err)
+			latest, err := storage.LatestTimestamp(context.Background())
+			if d.shouldError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, d.expected.Equal(latest), "expected %s, got %s", d.expected, latest)
+		})
+	}
+}
+
+func TestInfluxDBStorage_LatestTimestamp_MetricNaming(t *testing.T) {
+	naming := MetricNaming{Prefix: "zettelkasten_", Labels: map[string]string{"vault": "it's work"}}
+	data := []struct {
+		name       string
+		newStorage func(url string) (InfluxDBStorage, error)
+		query      func(r *http.Request) string
+		response   string
+		expected   string
+	}{
+		{
+			name: "v1",
+			newStorage: func(url string) (InfluxDBStorage, error) {
+				return NewInfluxDBV1Storage(url, "zettelkasten", "", HTTPOptions{}, naming)
+			},
+			query:    func(r *http.Request) string { return r.URL.Query().Get("q") },
+			response: `{"results":[{"statement_id":0}]}`,
+			expected: `SELECT last("note_count") FROM "zettelkasten_total" WHERE "vault" = 'it\'s work'`,
+		},
+		{
+			name: "v3",
+			newStorage: func(url string) (InfluxDBStorage, error) {
+				return NewInfluxDBV3Storage(url, "zettelkasten", "any-token", HTTPOptions{}, naming)
+			},
+			query: func(r *http.Request) string {
+				var query map[string]string
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+				return query["q"]
+			},
+			response: `[]`,
+			expected: `SELECT max(time) AS time FROM "zettelkasten_total" WHERE "vault" = 'it''s work'`,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, d.expected, d.query(r))
+				_, _ = w.Write([]byte(d.response))
+			}))
+			defer server.Close()
+
+			storage, err := d.newStorage(server.URL)
+			require.NoError(t, err)
+			latest, err := storage.LatestTimestamp(context.Background())
+			require.NoError(t, err)
+			assert.True(t, latest.IsZero())
+		})
+	}
+}
+
+func TestInfluxDBV3Storage_LatestTimestamp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/query_sql", r.URL.Path)
+		assert.Equal(t, "Bearer any-token", r.Header.Get("Authorization"))
+		var query map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+		assert.Equal(t, map[string]string{"db": "zettelkasten", "q": `SELECT max(time) AS time FROM "total"`, "format": "json"}, query)
+		_, _ = w.Write([]byte(`[{"time":"2024-05-29T10:30:00"}]`))
+	}))
+	defer server.Close()
+
+	storage, err := NewInfluxDBV3Storage(server.URL, "zettelkasten", "any-token", HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	latest, err := storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC), latest)
+}
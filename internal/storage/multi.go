@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// FailurePolicy represents how a failure to write to a storage backend is handled.
+type FailurePolicy int
+
+const (
+	// FailurePolicyFailAll makes the whole write fail when the backend fails.
+	FailurePolicyFailAll FailurePolicy = iota
+	// FailurePolicyBestEffort only logs the failures of the backend.
+	FailurePolicyBestEffort
+)
+
+// Backend represents a named storage backend within a `MultiStorage`.
+type Backend struct {
+	Name          string
+	Storage       Storage
+	FailurePolicy FailurePolicy
+}
+
+// MultiStorage represents a storage that writes metrics to multiple storage backends.
+type MultiStorage struct {
+	backends []Backend
+}
+
+// NewMultiStorage creates a new `MultiStorage` writing to all `backends`.
+func NewMultiStorage(backends ...Backend) MultiStorage {
+	return MultiStorage{backends: backends}
+}
+
+// WriteMetrics writes `zettelkastenMetrics` to all backends with `timestamp`.
+//
+// All backends are written even if some of them fail. An error is returned if
+// any of the backends with `FailurePolicyFailAll` fails.
+func (m MultiStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	var errs []error
+	for _, backend := range m.backends {
+		start := time.Now()
+		err := backend.Storage.WriteMetrics(ctx, zettelkastenMetrics, timestamp)
+		if err == nil {
+			slog.Debug("Wrote metrics to storage", slog.String("storage", backend.Name), slog.Duration("duration", time.Since(start)))
+			continue
+		}
+
+		if backend.FailurePolicy == FailurePolicyBestEffort {
+			slog.Warn("Error writing metrics to best effort storage, ignoring it", slog.String("storage", backend.Name), slog.Any("error", err))
+			continue
+		}
+		slog.Error("Error writing metrics to storage", slog.String("storage", backend.Name), slog.Any("error", err))
+		errs = append(errs, fmt.Errorf("error writing metrics to %s storage: %w", backend.Name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// Flush sends the pending writes of all backends, handling failures like `WriteMetrics`.
+func (m MultiStorage) Flush(ctx context.Context) error {
+	var errs []error
+	for _, backend := range m.backends {
+		err := Flush(ctx, backend.Storage)
+		if err == nil {
+			continue
+		}
+
+		if backend.FailurePolicy == FailurePolicyBestEffort {
+			slog.Warn("Error flushing best effort storage, ignoring it", slog.String("storage", backend.Name), slog.Any("error", err))
+			continue
+		}
+		slog.Error("Error flushing storage", slog.String("storage", backend.Name), slog.Any("error", err))
+		errs = append(errs, fmt.Errorf("error flushing %s storage: %w", backend.Name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// isFlusher reports whether any of the backends holds writes until it's flushed.
+func (m MultiStorage) isFlusher() bool {
+	return slices.ContainsFunc(m.backends, func(backend Backend) bool { return isFlusher(backend.Storage) })
+}
+
+// Close releases the connections of all backends.
+func (m MultiStorage) Close() error {
+	var errs []error
+	for _, backend := range m.backends {
+		err := Close(backend.Storage)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error closing %s storage: %w", backend.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LatestTimestamp returns the earliest of the latest timestamps of all backends, so
+// that metrics newer than it are missing in at least one of them.
+//
+// Backends that don't support reporting their latest timestamp are ignored, as well as
+// best effort backends that fail to report it. An error wrapping `errors.ErrUnsupported`
+// is returned if none of the backends report their latest timestamp.
+func (m MultiStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	var latest time.Time
+	supported := false
+	for _, backend := range m.backends {
+		timestamp, err := backend.Storage.LatestTimestamp(ctx)
+		if errors.Is(err, errors.ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			if backend.FailurePolicy == FailurePolicyBestEffort {
+				slog.Warn("Error getting latest timestamp from best effort storage, ignoring it", slog.String("storage", backend.Name), slog.Any("error", err))
+				continue
+			}
+			return time.Time{}, fmt.Errorf("error getting latest timestamp from %s storage: %w", backend.Name, err)
+		}
+		if !supported || timestamp.Before(latest) {
+			latest = timestamp
+		}
+		supported = true
+	}
+	if !supported {
+		return time.Time{}, fmt.Errorf("no storage reports its latest timestamp: %w", errors.ErrUnsupported)
+	}
+	return latest, nil
+}
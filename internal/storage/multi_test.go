@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStorage is a storage that always fails to write metrics.
+type failingStorage struct{}
+
+func (f failingStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	return errors.New("any error")
+}
+
+func (f failingStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return time.Time{}, errors.New("any error")
+}
+
+func TestMultiStorage(t *testing.T) {
+	data := []struct {
+		name        string
+		policy      FailurePolicy
+		shouldError bool
+	}{
+		{name: "fail all", policy: FailurePolicyFailAll, shouldError: true},
+		{name: "best effort", policy: FailurePolicyBestEffort, shouldError: false},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			first := NewFakeStorage()
+			second := NewFakeStorage()
+			storage := NewMultiStorage(
+				Backend{Name: "first", Storage: &first, FailurePolicy: FailurePolicyFailAll},
+				Backend{Name: "failing", Storage: failingStorage{}, FailurePolicy: d.policy},
+				Backend{Name: "second", Storage: &second, FailurePolicy: FailurePolicyFailAll},
+			)
+			zettelkastenMetrics := metrics.ZettelkastenMetrics{NoteCount: 1, Notes: map[string]metrics.NoteMetrics{}}
+
+			err := storage.WriteMetrics(context.Background(), zettelkastenMetrics, time.Now())
+
+			if d.shouldError {
+				assert.ErrorContains(t, err, "failing")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, []metrics.ZettelkastenMetrics{zettelkastenMetrics}, first.Metrics)
+			assert.Equal(t, []metrics.ZettelkastenMetrics{zettelkastenMetrics}, second.Metrics)
+		})
+	}
+}
+
+func TestMultiStorage_LatestTimestamp(t *testing.T) {
+	older := NewFakeStorage()
+	older.Latest = time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	newer := NewFakeStorage()
+	newer.Latest = older.Latest.Add(time.Hour)
+
+	data := []struct {
+		name        string
+		backends    []Backend
+		expected    time.Time
+		unsupported bool
+		shouldError bool
+	}{
+		{
+			name: "earliest of all backends",
+			backends: []Backend{
+				{Name: "newer", Storage: &newer},
+				{Name: "older", Storage: &older},
+				{Name: "unsupported", Storage: StatsDStorage{}},
+			},
+			expected: older.Latest,
+		},
+		{
+			name: "best effort failure",
+			backends: []Backend{
+				{Name: "newer", Storage: &newer},
+				{Name: "failing", Storage: failingStorage{}, FailurePolicy: FailurePolicyBestEffort},
+			},
+			expected: newer.Latest,
+		},
+		{
+			name: "fail all failure",
+			backends: []Backend{
+				{Name: "newer", Storage: &newer},
+				{Name: "failing", Storage: failingStorage{}, FailurePolicy: FailurePolicyFailAll},
+			},
+			shouldError: true,
+		},
+		{
+			name:        "all unsupported",
+			backends:    []Backend{{Name: "unsupported", Storage: StatsDStorage{}}},
+			shouldError: true,
+			unsupported: true,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			latest, err := NewMultiStorage(d.backends...).LatestTimestamp(context.Background())
+
+			if d.shouldError {
+				assert.Error(t, err)
+				assert.Equal(t, d.unsupported, errors.Is(err, errors.ErrUnsupported))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, d.expected, latest)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// MetricNaming represents how the measurements written by the storages are named and labelled.
+//
+// The zero value keeps the default measurement names and adds no labels.
+type MetricNaming struct {
+	// Prefix is prepended to the name of all measurements.
+	Prefix string
+	// Measurements maps default measurement names to the names used instead.
+	Measurements map[string]string
+	// Labels are added as tags to all points.
+	Labels map[string]string
+	// NoteLabels maps label names to the frontmatter keys whose values are added as tags to the
+	// points of each note.
+	NoteLabels map[string]string
+}
+
+// measurement returns the name of the default measurement `name` after renaming and prefixing it.
+func (n MetricNaming) measurement(name string) string {
+	if renamed, ok := n.Measurements[name]; ok {
+		name = renamed
+	}
+	return n.Prefix + name
+}
+
+// tags returns `tags` with the static labels of `n` added.
+func (n MetricNaming) tags(tags map[string]string) map[string]string {
+	maps.Copy(tags, n.Labels)
+	return tags
+}
+
+// noteTags returns the tags of the note `name` with metrics `note`, with the values of its
+// frontmatter fields configured as labels added.
+//
+// Fields missing from the frontmatter or whose values are not scalars are left out.
+func (n MetricNaming) noteTags(name string, note metrics.NoteMetrics) map[string]string {
+	tags := n.noteLabels(note)
+	tags["name"] = name
+	return tags
+}
+
+// noteLabels returns the static labels of `n` along with the values of the frontmatter fields
+// of `note` configured as labels, leaving out the fields it doesn't have.
+func (n MetricNaming) noteLabels(note metrics.NoteMetrics) map[string]string {
+	labels := n.tags(map[string]string{})
+	for _, label := range n.noteLabelNames() {
+		value, ok := n.noteLabelValue(label, note)
+		if ok {
+			labels[label] = value
+		}
+	}
+	return labels
+}
+
+// noteLabelValue returns the value of the frontmatter label `label` of `note`, reporting
+// whether the note has one.
+func (n MetricNaming) noteLabelValue(label string, note metrics.NoteMetrics) (string, bool) {
+	return frontmatterLabelValue(note.Frontmatter[n.NoteLabels[label]])
+}
+
+// frontmatterLabelValue formats the frontmatter field `value` as a label value, reporting
+// whether it's a scalar that can be used as one.
+func frontmatterLabelValue(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, v != ""
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), true
+	case time.Time:
+		return v.Format(time.RFC3339), true
+	default:
+		return "", false
+	}
+}
+
+// labelNames returns the names of the static labels of `n` in sorted order.
+func (n MetricNaming) labelNames() []string {
+	return slices.Sorted(maps.Keys(n.Labels))
+}
+
+// noteLabelNames returns the names of the frontmatter labels of `n` in sorted order.
+func (n MetricNaming) noteLabelNames() []string {
+	return slices.Sorted(maps.Keys(n.NoteLabels))
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// hashedNameLength is the number of hexadecimal characters kept from the hash of a note name.
+const hashedNameLength = 16
+
+// NoteSeriesOptions represents the options controlling which notes have their own series in the storage.
+type NoteSeriesOptions struct {
+	// Disabled drops all per note metrics, keeping only the aggregated metrics.
+	Disabled bool
+	// Limit is the maximum number of notes written, keeping the ones with the most backlinks.
+	// A limit of zero writes all notes. The series of the notes that are no longer written are
+	// marked as stale.
+	Limit int
+	// HashNames replaces the note names and tags with an HMAC of them keyed with `HashSalt`.
+	HashNames bool
+	HashSalt  string
+}
+
+// NoteSeriesStorage represents a storage that reduces or pseudonymises the per
+// note metrics before forwarding them to another storage.
+//
+// The aggregated metrics are always forwarded unchanged, since they are computed
+// from all notes.
+type NoteSeriesStorage struct {
+	storage Storage
+	options NoteSeriesOptions
+	// written holds the metrics of the notes in the last successful write by name, when the
+	// notes are limited.
+	written map[string]metrics.NoteMetrics
+}
+
+// NewNoteSeriesStorage creates a new `NoteSeriesStorage` forwarding writes to `storage`.
+func NewNoteSeriesStorage(storage Storage, options NoteSeriesOptions) *NoteSeriesStorage {
+	return &NoteSeriesStorage{storage: storage, options: options}
+}
+
+// WriteMetrics writes `zettelkastenMetrics` to the underlying storage with the per note
+// metrics reduced and pseudonymised according to the options.
+func (n *NoteSeriesStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	filtered, written := n.filterNotes(zettelkastenMetrics)
+	err := n.storage.WriteMetrics(ctx, filtered, timestamp)
+	if err != nil {
+		return err
+	}
+	n.written = written
+	return nil
+}
+
+// LatestTimestamp returns the timestamp of the latest metrics in the underlying storage.
+func (n *NoteSeriesStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return n.storage.LatestTimestamp(ctx)
+}
+
+// Flush sends the pending writes of the underlying storage.
+func (n *NoteSeriesStorage) Flush(ctx context.Context) error {
+	return Flush(ctx, n.storage)
+}
+
+// isFlusher reports whether the underlying storage holds writes until it's flushed.
+func (n *NoteSeriesStorage) isFlusher() bool {
+	return isFlusher(n.storage)
+}
+
+// Close releases the connections of the underlying storage.
+func (n *NoteSeriesStorage) Close() error {
+	return Close(n.storage)
+}
+
+// filterNotes returns a copy of `zettelkastenMetrics` with the per note metrics reduced and
+// pseudonymised, along with the metrics of the written notes by name when they are limited.
+//
+// The notes written last time that are left out by the limit are added to the stale notes,
+// so that their series end like the ones of deleted notes.
+func (n *NoteSeriesStorage) filterNotes(zettelkastenMetrics metrics.ZettelkastenMetrics) (metrics.ZettelkastenMetrics, map[string]metrics.NoteMetrics) {
+	filtered := metrics.ZettelkastenMetrics{
+		NoteCount:              zettelkastenMetrics.NoteCount,
+		LinkCount:              zettelkastenMetrics.LinkCount,
+		WordCount:              zettelkastenMetrics.WordCount,
+		OpenTaskCount:          zettelkastenMetrics.OpenTaskCount,
+		CompletedTaskCount:     zettelkastenMetrics.CompletedTaskCount,
+		AnchorLinkCount:        zettelkastenMetrics.AnchorLinkCount,
+		BrokenHeadingLinkCount: zettelkastenMetrics.BrokenHeadingLinkCount,
+		BrokenBlockLinkCount:   zettelkastenMetrics.BrokenBlockLinkCount,
+		Notes:                  make(map[string]metrics.NoteMetrics),
+		Tags:                   n.filterTags(zettelkastenMetrics.Tags),
+		Activity:               zettelkastenMetrics.Activity,
+	}
+	if n.options.Disabled {
+		return filtered, nil
+	}
+
+	names := slices.Collect(maps.Keys(zettelkastenMetrics.Notes))
+	if n.options.Limit > 0 && len(names) > n.options.Limit {
+		slices.SortFunc(names, func(a, b string) int {
+			// Most backlinks first, using the name to break ties
+			backlinks := cmp.Compare(zettelkastenMetrics.Notes[b].BacklinkCount, zettelkastenMetrics.Notes[a].BacklinkCount)
+			return cmp.Or(backlinks, cmp.Compare(a, b))
+		})
+		names = names[:n.options.Limit]
+	}
+	for _, name := range names {
+		filtered.Notes[n.noteName(name)] = n.filterNote(zettelkastenMetrics.Notes[name])
+	}
+
+	stale := zettelkastenMetrics.StaleNotes
+	var written map[string]metrics.NoteMetrics
+	if n.options.Limit > 0 {
+		written = make(map[string]metrics.NoteMetrics, len(names))
+		for _, name := range names {
+			written[name] = zettelkastenMetrics.Notes[name]
+		}
+		stale = maps.Clone(stale)
+		for name, note := range n.written {
+			// Deleted notes are reported by the collection according to the configured mode
+			_, exists := zettelkastenMetrics.Notes[name]
+			if _, ok := written[name]; exists && !ok {
+				if stale == nil {
+					stale = make(map[string]metrics.NoteMetrics)
+				}
+				stale[name] = note
+			}
+		}
+	}
+	filtered.StaleNotes = n.filterDeletedNotes(stale)
+	filtered.DeletedNotes = n.filterDeletedNotes(zettelkastenMetrics.DeletedNotes)
+	return filtered, written
+}
+
+// filterNote returns a copy of the metrics of a note with its links and tags pseudonymised.
+func (n *NoteSeriesStorage) filterNote(note metrics.NoteMetrics) metrics.NoteMetrics {
+	links := make(map[string]uint, len(note.Links))
+	for target, count := range note.Links {
+		links[n.noteName(target)] += count
+	}
+	note.Links = links
+	if n.options.HashNames {
+		// The anchors are only used to find broken links, and would reveal the headings and linked notes
+		note.Anchors, note.AnchorLinks = nil, nil
+		if len(note.Tags) > 0 {
+			tags := make([]string, 0, len(note.Tags))
+			for _, tag := range note.Tags {
+				tags = append(tags, n.tagName(tag))
+			}
+			slices.Sort(tags)
+			note.Tags = tags
+		}
+	}
+	return note
+}
+
+// filterTags returns the metrics of `tags` with the tags pseudonymised, both in the tags
+// themselves and in the targets of their links.
+func (n *NoteSeriesStorage) filterTags(tags map[string]metrics.TagMetrics) map[string]metrics.TagMetrics {
+	if !n.options.HashNames {
+		return tags
+	}
+	filtered := make(map[string]metrics.TagMetrics, len(tags))
+	for tag, metric := range tags {
+		links := make(map[string]uint, len(metric.Links))
+		for target, count := range metric.Links {
+			links[n.tagName(target)] += count
+		}
+		metric.Links = links
+		filtered[n.tagName(tag)] = metric
+	}
+	return filtered
+}
+
+// filterDeletedNotes returns the deleted `notes` filtered like the notes of a collection, or nil
+// when there are none.
+func (n *NoteSeriesStorage) filterDeletedNotes(notes map[string]metrics.NoteMetrics) map[string]metrics.NoteMetrics {
+	if len(notes) == 0 {
+		return nil
+	}
+	filtered := make(map[string]metrics.NoteMetrics, len(notes))
+	for name, note := range notes {
+		filtered[n.noteName(name)] = n.filterNote(note)
+	}
+	return filtered
+}
+
+// noteName returns the name under which the note `name` is written.
+func (n *NoteSeriesStorage) noteName(name string) string {
+	if !n.options.HashNames {
+		return name
+	}
+	return n.hash(name)
+}
+
+// tagName returns the name under which the tag `tag` is written.
+//
+// Nested tags are hashed as a whole, so the hashes of a tag and of its parent tags are unrelated.
+func (n *NoteSeriesStorage) tagName(tag string) string {
+	if !n.options.HashNames {
+		return tag
+	}
+	return n.hash(tag)
+}
+
+// hash returns the first characters of the HMAC of `value` keyed with the salt.
+func (n *NoteSeriesStorage) hash(value string) string {
+	mac := hmac.New(sha256.New, []byte(n.options.HashSalt))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:hashedNameLength]
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var noteSeriesTestMetrics = metrics.ZettelkastenMetrics{
+	NoteCount: 3,
+	LinkCount: 4,
+	WordCount: 30,
+	Notes: map[string]metrics.NoteMetrics{
+		"one":   {Links: map[string]uint{"two": 1}, LinkCount: 1, WordCount: 10, BacklinkCount: 2},
+		"two":   {Links: map[string]uint{"one": 2}, LinkCount: 2, WordCount: 10, BacklinkCount: 1},
+		"three": {Links: map[string]uint{"one": 1}, LinkCount: 1, WordCount: 10, BacklinkCount: 1},
+	},
+	DeletedNotes: map[string]metrics.NoteMetrics{"four": {Links: map[string]uint{"one": 1}, LinkCount: 1, WordCount: 5}},
+}
+
+func TestNoteSeriesStorage(t *testing.T) {
+	data := []struct {
+		name     string
+		options  NoteSeriesOptions
+		expected metrics.ZettelkastenMetrics
+	}{
+		{
+			name:     "no options",
+			options:  NoteSeriesOptions{},
+			expected: noteSeriesTestMetrics,
+		},
+		{
+			name:    "disabled",
+			options: NoteSeriesOptions{Disabled: true},
+			expected: metrics.ZettelkastenMetrics{
+				NoteCount: 3,
+				LinkCount: 4,
+				WordCount: 30,
+				Notes:     map[string]metrics.NoteMetrics{},
+			},
+		},
+		{
+			name:    "limit",
+			options: NoteSeriesOptions{Limit: 2},
+			expected: metrics.ZettelkastenMetrics{
+				NoteCount: 3,
+				LinkCount: 4,
+				WordCount: 30,
+				Notes: map[string]metrics.NoteMetrics{
+					"one":   {Links: map[string]uint{"two": 1}, LinkCount: 1, WordCount: 10, BacklinkCount: 2},
+					"three": {Links: map[string]uint{"one": 1}, LinkCount: 1, WordCount: 10, BacklinkCount: 1},
+				},
+				DeletedNotes: map[string]metrics.NoteMetrics{"four": {Links: map[string]uint{"one": 1}, LinkCount: 1, WordCount: 5}},
+			},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			inner := NewFakeStorage()
+			storage := NewNoteSeriesStorage(&inner, d.options)
+
+			require.NoError(t, storage.WriteMetrics(context.Background(), noteSeriesTestMetrics, time.Now()))
+
+			assert.Equal(t, []metrics.ZettelkastenMetrics{d.expected}, inner.Metrics)
+		})
+	}
+}
+
+func TestNoteSeriesStorage_LimitStaleNotes(t *testing.T) {
+	inner := NewFakeStorage()
+	storage := NewNoteSeriesStorage(&inner, NoteSeriesOptions{Limit: 1})
+	one := metrics.NoteMetrics{Links: map[string]uint{}, WordCount: 10, BacklinkCount: 2, Frontmatter: map[string]any{"type": "idea"}}
+	two := metrics.NoteMetrics{Links: map[string]uint{}, WordCount: 10, BacklinkCount: 1}
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{"one": one, "two": two}}, time.Now()))
+
+	// The note written last time is marked as stale with its last metrics once it falls out of the limit
+	dropped := one
+	dropped.BacklinkCount = 0
+	two.BacklinkCount = 3
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{"one": dropped, "two": two}}, time.Now()))
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{"one": dropped, "two": two}}, time.Now()))
+
+	require.Len(t, inner.Metrics, 3)
+	assert.Empty(t, inner.Metrics[0].StaleNotes)
+	assert.Equal(t, map[string]metrics.NoteMetrics{"two": two}, inner.Metrics[1].Notes)
+	assert.Equal(t, map[string]metrics.NoteMetrics{"one": one}, inner.Metrics[1].StaleNotes)
+	assert.Empty(t, inner.Metrics[2].StaleNotes)
+}
+
+func TestNoteSeriesStorage_HashNames(t *testing.T) {
+	inner := NewFakeStorage()
+	storage := NewNoteSeriesStorage(&inner, NoteSeriesOptions{HashNames: true, HashSalt: "any-salt"})
+	require.NoError(t, storage.WriteMetrics(context.Background(), noteSeriesTestMetrics, time.Now()))
+	require.NoError(t, storage.WriteMetrics(context.Background(), noteSeriesTestMetrics, time.Now()))
+
+	require.Len(t, inner.Metrics, 2)
+	written := inner.Metrics[0]
+	assert.Equal(t, inner.Metrics[1], written, "hashes should be stable")
+	assert.Len(t, written.Notes, 3)
+	one := storage.noteName("one")
+	two := storage.noteName("two")
+	assert.Len(t, one, hashedNameLength)
+	assert.NotEqual(t, one, two)
+	assert.Equal(t, map[string]uint{two: 1}, written.Notes[one].Links)
+	assert.Equal(t, uint(2), written.Notes[one].BacklinkCount)
+	assert.Equal(t, map[string]metrics.NoteMetrics{storage.noteName("four"): {Links: map[string]uint{one: 1}, LinkCount: 1, WordCount: 5}}, written.DeletedNotes)
+	assert.NotContains(t, written.Notes, "one")
+
+	salted := NewNoteSeriesStorage(&inner, NoteSeriesOptions{HashNames: true, HashSalt: "another-salt"})
+	assert.NotEqual(t, one, salted.noteName("one"))
+}
+
+func TestNoteSeriesStorage_HashTags(t *testing.T) {
+	inner := NewFakeStorage()
+	storage := NewNoteSeriesStorage(&inner, NoteSeriesOptions{HashNames: true, HashSalt: "any-salt"})
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {Links: map[string]uint{}, Tags: []string{"project", "project/alpha"}},
+		},
+		Tags: map[string]metrics.TagMetrics{
+			"project":       {NoteCount: 1, Links: map[string]uint{"project": 1, "project/alpha": 1}},
+			"project/alpha": {NoteCount: 1, Links: map[string]uint{"project": 1, "project/alpha": 1}},
+		},
+	}
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, time.Now()))
+
+	require.Len(t, inner.Metrics, 1)
+	project := storage.tagName("project")
+	alpha := storage.tagName("project/alpha")
+	assert.Len(t, project, hashedNameLength)
+	assert.NotEqual(t, project, alpha)
+	links := map[string]uint{project: 1, alpha: 1}
+	expected := map[string]metrics.TagMetrics{
+		project: {NoteCount: 1, Links: links},
+		alpha:   {NoteCount: 1, Links: links},
+	}
+	assert.Equal(t, expected, inner.Metrics[0].Tags)
+	assert.ElementsMatch(t, []string{project, alpha}, inner.Metrics[0].Notes[storage.noteName("one")].Tags)
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// openMetricsSeries represents the values of a single time series written to an OpenMetrics file.
+type openMetricsSeries struct {
+	name       string
+	labels     string
+	values     []float64
+	timestamps []int64
+}
+
+// OpenMetricsStorage represents the implementation of a metric storage that writes all metrics,
+// with their timestamps, to an OpenMetrics text file.
+//
+// The file is meant to be imported offline with `promtool tsdb create-blocks-from openmetrics`.
+// Since the OpenMetrics format requires all samples of a metric to be next to each other, the
+// metrics are kept in memory and the whole file is rewritten when the storage is flushed.
+type OpenMetricsStorage struct {
+	path         string
+	naming       MetricNaming
+	descriptions map[string]string
+	series       map[string]*openMetricsSeries
+	pending      bool
+}
+
+// NewOpenMetricsStorage creates a new `OpenMetricsStorage` writing the metrics named according
+// to `naming` to the file at `path`.
+func NewOpenMetricsStorage(path string, naming MetricNaming) (*OpenMetricsStorage, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OpenMetrics directory: %w", err)
+	}
+	return &OpenMetricsStorage{
+		path:         path,
+		naming:       naming,
+		descriptions: createMetricDescriptions(naming),
+		series:       make(map[string]*openMetricsSeries),
+	}, nil
+}
+
+// WriteMetrics adds `zettelkastenMetrics` with `timestamp` to the metrics written on the next flush.
+func (o *OpenMetricsStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	samples, err := createSamples(createInfluxDBPoints(o.naming, zettelkastenMetrics, timestamp))
+	if err != nil {
+		slog.Error("Error creating samples", slog.Any("error", err))
+		return err
+	}
+	for _, s := range samples {
+		labels := formatLabels(s)
+		key := s.name + labels
+		series, ok := o.series[key]
+		if !ok {
+			series = &openMetricsSeries{name: s.name, labels: labels}
+			o.series[key] = series
+		}
+		series.values = append(series.values, s.value)
+		series.timestamps = append(series.timestamps, s.timestamp.UnixMilli())
+	}
+	o.pending = true
+	return nil
+}
+
+// LatestTimestamp is not supported by the OpenMetrics storage, since the file is rewritten with
+// only the metrics written since the exporter started.
+func (o *OpenMetricsStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("openmetrics storage: %w", errors.ErrUnsupported)
+}
+
+// Flush rewrites the OpenMetrics file with all metrics written so far.
+//
+// The file is replaced atomically, so it's never left partially written.
+func (o *OpenMetricsStorage) Flush(ctx context.Context) error {
+	if !o.pending {
+		return nil
+	}
+	slog.Debug("Writing OpenMetrics file", slog.String("path", o.path), slog.Int("series", len(o.series)))
+
+	temporary, err := os.CreateTemp(filepath.Dir(o.path), fmt.Sprintf(".%s-*", filepath.Base(o.path)))
+	if err != nil {
+		return fmt.Errorf("error creating OpenMetrics file: %w", err)
+	}
+	defer func() { _ = os.Remove(temporary.Name()) }()
+	_, err = temporary.Write(encodeOpenMetrics(slices.Collect(maps.Values(o.series)), o.descriptions))
+	if err == nil {
+		err = temporary.Close()
+	}
+	if err != nil {
+		_ = temporary.Close()
+		return fmt.Errorf("error writing OpenMetrics file: %w", err)
+	}
+	err = os.Rename(temporary.Name(), o.path)
+	if err != nil {
+		return fmt.Errorf("error replacing OpenMetrics file: %w", err)
+	}
+	o.pending = false
+	return nil
+}
+
+// encodeOpenMetrics encodes `series` into the OpenMetrics text format as gauges, with the help
+// text of each metric taken from `descriptions`.
+//
+// The values of each series are written in timestamp order. When a series has more than one value
+// with the same timestamp, such as for commits made in the same second, only the last written
+// value is kept, since duplicated timestamps are rejected on import.
+// Reference: https://github.com/prometheus/OpenMetrics/blob/main/specification/OpenMetrics.md
+func encodeOpenMetrics(series []*openMetricsSeries, descriptions map[string]string) []byte {
+	slices.SortFunc(series, func(a, b *openMetricsSeries) int {
+		return cmp.Or(cmp.Compare(a.name, b.name), cmp.Compare(a.labels, b.labels))
+	})
+
+	var buffer bytes.Buffer
+	previousName := ""
+	for _, s := range series {
+		if s.name != previousName {
+			fmt.Fprintf(&buffer, "# TYPE %s gauge\n", s.name)
+			if description, ok := descriptions[s.name]; ok {
+				fmt.Fprintf(&buffer, "# HELP %s %s\n", s.name, description)
+			}
+			previousName = s.name
+		}
+
+		order := make([]int, len(s.timestamps))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(s.timestamps[a], s.timestamps[b]) })
+		for i, index := range order {
+			if i+1 < len(order) && s.timestamps[order[i+1]] == s.timestamps[index] {
+				continue
+			}
+			timestamp := s.timestamps[index]
+			fmt.Fprintf(&buffer, "%s%s %s %d.%03d\n", s.name, s.labels, strconv.FormatFloat(s.values[index], 'f', -1, 64), timestamp/1000, timestamp%1000)
+		}
+	}
+	buffer.WriteString("# EOF\n")
+	return buffer.Bytes()
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenMetricsStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backfill", "zettelkasten.om")
+	storage, err := NewOpenMetricsStorage(path, MetricNaming{Labels: map[string]string{"vault": "work"}})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	first := metrics.ZettelkastenMetrics{
+		NoteCount: 1,
+		LinkCount: 0,
+		WordCount: 3,
+		Notes:     map[string]metrics.NoteMetrics{"one": {WordCount: 3}},
+	}
+	second := metrics.ZettelkastenMetrics{
+		NoteCount: 2,
+		LinkCount: 1,
+		WordCount: 8,
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {Links: map[string]uint{"two": 1}, LinkCount: 1, WordCount: 5, BacklinkCount: 0},
+			"two": {WordCount: 3, BacklinkCount: 1},
+		},
+	}
+	// The file is only written on flush
+	require.NoError(t, storage.WriteMetrics(context.Background(), first, timestamp))
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Only the last metrics written with the same timestamp are kept
+	require.NoError(t, storage.WriteMetrics(context.Background(), second, timestamp.Add(time.Hour)))
+	require.NoError(t, storage.WriteMetrics(context.Background(), second, timestamp.Add(time.Hour)))
+	require.NoError(t, storage.Flush(context.Background()))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	expected := `# TYPE notes_backlink_count gauge
+# HELP notes_backlink_count Number of links that reference the note
+notes_backlink_count{name="one",vault="work"} 0 1716978600.000
+notes_backlink_count{name="one",vault="work"} 0 1716982200.000
+notes_backlink_count{name="two",vault="work"} 1 1716982200.000
+# TYPE notes_link_count gauge
+# HELP notes_link_count Number of links in the note
+notes_link_count{name="one",vault="work"} 0 1716978600.000
+notes_link_count{name="one",vault="work"} 1 1716982200.000
+notes_link_count{name="two",vault="work"} 0 1716982200.000
+# TYPE notes_word_count gauge
+# HELP notes_word_count Number of words in the note
+notes_word_count{name="one",vault="work"} 3 1716978600.000
+notes_word_count{name="one",vault="work"} 5 1716982200.000
+notes_word_count{name="two",vault="work"} 3 1716982200.000
+# TYPE total_link_count gauge
+# HELP total_link_count Number of links in the Zettelkasten
+total_link_count{vault="work"} 0 1716978600.000
+total_link_count{vault="work"} 1 1716982200.000
+# TYPE total_note_count gauge
+# HELP total_note_count Number of notes in the Zettelkasten
+total_note_count{vault="work"} 1 1716978600.000
+total_note_count{vault="work"} 2 1716982200.000
+# TYPE total_word_count gauge
+# HELP total_word_count Number of words in the Zettelkasten
+total_word_count{vault="work"} 3 1716978600.000
+total_word_count{vault="work"} 8 1716982200.000
+# EOF
+`
+	assert.Equal(t, expected, string(content))
+
+	_, err = storage.LatestTimestamp(context.Background())
+	assert.True(t, errors.Is(err, errors.ErrUnsupported))
+}
+
+func TestEncodeOpenMetrics_OutOfOrder(t *testing.T) {
+	series := []*openMetricsSeries{{
+		name:       "total_note_count",
+		values:     []float64{2, 1, 3},
+		timestamps: []int64{2000, 1000, 2000},
+	}}
+
+	content := encodeOpenMetrics(series, nil)
+
+	expected := `# TYPE total_note_count gauge
+total_note_count 1 1.000
+total_note_count 3 2.000
+# EOF
+`
+	assert.Equal(t, expected, string(content))
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// The supported OTLP transport protocols.
+const (
+	OTLPProtocolHTTP = "http/protobuf"
+	OTLPProtocolGRPC = "grpc"
+)
+
+// The instrumentation scope used for all metrics sent via OTLP.
+const otlpScopeName = "github.com/luissimas/zettelkasten-exporter"
+
+// OTLPStorage represents the implementation of a metric storage using the OpenTelemetry protocol.
+type OTLPStorage struct {
+	resource     *resourcepb.Resource
+	options      HTTPOptions
+	httpURL      string
+	httpClient   *http.Client
+	grpcConn     *grpc.ClientConn
+	grpcClient   colmetricspb.MetricsServiceClient
+	naming       MetricNaming
+	descriptions map[string]string
+}
+
+// NewOTLPStorage creates a new `OTLPStorage` exporting to `endpoint` using `protocol`.
+//
+// For the HTTP protocol, metrics are sent to the `/v1/metrics` path of `endpoint`. For gRPC,
+// the scheme of `endpoint` determines whether the connection is secure (https) or not (http).
+// Both protocols use the headers, timeout and TLS settings from `options`. The
+// `resourceAttributes` are attached to the resource describing the metrics, which are
+// named and labelled according to `naming`.
+func NewOTLPStorage(endpoint, protocol string, options HTTPOptions, resourceAttributes map[string]string, naming MetricNaming) (OTLPStorage, error) {
+	o := OTLPStorage{
+		resource:     createOTLPResource(resourceAttributes),
+		options:      options,
+		naming:       naming,
+		descriptions: createMetricDescriptions(naming),
+	}
+
+	switch protocol {
+	case OTLPProtocolHTTP:
+		httpURL, err := url.JoinPath(endpoint, "v1", "metrics")
+		if err != nil {
+			return OTLPStorage{}, fmt.Errorf("error creating OTLP metrics URL: %w", err)
+		}
+		o.httpURL = httpURL
+		o.httpClient, err = options.newHTTPClient()
+		if err != nil {
+			return OTLPStorage{}, err
+		}
+	case OTLPProtocolGRPC:
+		parsed, err := url.Parse(endpoint)
+		if err != nil {
+			return OTLPStorage{}, fmt.Errorf("error parsing OTLP endpoint: %w", err)
+		}
+		transportCredentials := insecure.NewCredentials()
+		if parsed.Scheme == "https" {
+			tlsConfig, err := options.newTLSConfig()
+			if err != nil {
+				return OTLPStorage{}, err
+			}
+			transportCredentials = credentials.NewTLS(tlsConfig)
+		}
+		conn, err := grpc.NewClient(parsed.Host, grpc.WithTransportCredentials(transportCredentials))
+		if err != nil {
+			return OTLPStorage{}, fmt.Errorf("error creating OTLP gRPC client: %w", err)
+		}
+		o.grpcConn = conn
+		o.grpcClient = colmetricspb.NewMetricsServiceClient(conn)
+	default:
+		return OTLPStorage{}, fmt.Errorf("unsupported OTLP protocol %q", protocol)
+	}
+
+	return o, nil
+}
+
+// WriteMetrics exports `zettelkastenMetrics` as OTLP gauges with `timestamp`.
+func (o OTLPStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	points := createInfluxDBPoints(o.naming, zettelkastenMetrics, timestamp)
+	samples, err := createSamples(points)
+	if err != nil {
+		slog.Error("Error creating samples", slog.Any("error", err))
+		return err
+	}
+	staleSamples, err := createStaleSamples(o.naming, zettelkastenMetrics.StaleNotes, timestamp)
+	if err != nil {
+		slog.Error("Error creating stale samples", slog.Any("error", err))
+		return err
+	}
+	request := createOTLPRequest(o.resource, append(samples, staleSamples...), o.descriptions)
+
+	slog.Debug("Writing metrics to OTLP endpoint", slog.Int("samples", len(samples)))
+	if o.grpcClient != nil {
+		err = o.exportGRPC(ctx, request)
+	} else {
+		err = o.exportHTTP(ctx, request)
+	}
+	if err != nil {
+		slog.Error("Error exporting metrics to OTLP endpoint", slog.Any("error", err))
+		return err
+	}
+	return nil
+}
+
+// LatestTimestamp is not supported by the OTLP storage, since the protocol is write only.
+func (o OTLPStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("otlp storage: %w", errors.ErrUnsupported)
+}
+
+// Close closes the gRPC connection, if any.
+func (o OTLPStorage) Close() error {
+	if o.grpcConn == nil {
+		return nil
+	}
+	err := o.grpcConn.Close()
+	if err != nil {
+		return fmt.Errorf("error closing OTLP gRPC connection: %w", err)
+	}
+	return nil
+}
+
+// exportHTTP sends `request` using the OTLP/HTTP protocol with binary protobuf encoding.
+func (o OTLPStorage) exportHTTP(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) error {
+	content, err := proto.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error encoding OTLP request: %w", err)
+	}
+	httpRequest, err := o.options.newRequest(ctx, o.httpURL, "application/x-protobuf", content)
+	if err != nil {
+		return fmt.Errorf("error creating OTLP request: %w", err)
+	}
+	err = sendRequest(o.httpClient, httpRequest)
+	if err != nil {
+		return fmt.Errorf("error sending OTLP request: %w", err)
+	}
+	return nil
+}
+
+// exportGRPC sends `request` using the OTLP/gRPC protocol.
+func (o OTLPStorage) exportGRPC(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) error {
+	if o.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.options.Timeout)
+		defer cancel()
+	}
+	_, err := o.grpcClient.Export(metadata.NewOutgoingContext(ctx, metadata.New(o.options.Headers)), request)
+	if err != nil {
+		return fmt.Errorf("error sending OTLP request: %w", err)
+	}
+	return nil
+}
+
+// createOTLPRequest creates an OTLP export request with one gauge for each metric name in `samples`,
+// described by the text from `descriptions`.
+//
+// Staleness markers in `samples` are converted into data points flagged as having no recorded value.
+func createOTLPRequest(resource *resourcepb.Resource, samples []sample, descriptions map[string]string) *colmetricspb.ExportMetricsServiceRequest {
+	gauges := make(map[string]*metricspb.Metric)
+	names := make([]string, 0)
+	for _, s := range samples {
+		metric, ok := gauges[s.name]
+		if !ok {
+			metric = &metricspb.Metric{
+				Name:        s.name,
+				Description: descriptions[s.name],
+				Data:        &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}},
+			}
+			gauges[s.name] = metric
+			names = append(names, s.name)
+		}
+
+		attributes := make([]*commonpb.KeyValue, 0, len(s.labels))
+		for _, label := range s.labels {
+			attributes = append(attributes, otlpStringAttribute(label.Key, label.Value))
+		}
+		dataPoint := &metricspb.NumberDataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: uint64(s.timestamp.UnixNano()),
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: s.value},
+		}
+		if isStaleNaN(s.value) {
+			// Stale series are reported as data points without a value
+			dataPoint.Value = nil
+			dataPoint.Flags = uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)
+		}
+		gauge := metric.GetGauge()
+		gauge.DataPoints = append(gauge.DataPoints, dataPoint)
+	}
+
+	sort.Strings(names)
+	scopeMetrics := &metricspb.ScopeMetrics{
+		Scope:   &commonpb.InstrumentationScope{Name: otlpScopeName},
+		Metrics: make([]*metricspb.Metric, 0, len(names)),
+	}
+	for _, name := range names {
+		scopeMetrics.Metrics = append(scopeMetrics.Metrics, gauges[name])
+	}
+
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{
+				Resource:     resource,
+				ScopeMetrics: []*metricspb.ScopeMetrics{scopeMetrics},
+			},
+		},
+	}
+}
+
+// createOTLPResource creates an OTLP resource with the given `attributes`.
+func createOTLPResource(attributes map[string]string) *resourcepb.Resource {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	resource := &resourcepb.Resource{Attributes: make([]*commonpb.KeyValue, 0, len(keys))}
+	for _, key := range keys {
+		resource.Attributes = append(resource.Attributes, otlpStringAttribute(key, attributes[key]))
+	}
+	return resource
+}
+
+// otlpStringAttribute creates an OTLP attribute with a string value.
+func otlpStringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
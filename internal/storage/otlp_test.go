@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"google.golang.org/grpc/credentials"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+var otlpTestMetrics = metrics.ZettelkastenMetrics{
+	NoteCount: 1,
+	LinkCount: 2,
+	WordCount: 3,
+	Notes: map[string]metrics.NoteMetrics{
+		"one": {Links: map[string]uint{"two": 2}, LinkCount: 2, WordCount: 3, BacklinkCount: 4},
+	},
+}
+
+// fakeMetricsService is a gRPC OTLP metrics service that records the received requests.
+type fakeMetricsService struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	requests chan *colmetricspb.ExportMetricsServiceRequest
+	metadata chan metadata.MD
+}
+
+func (f *fakeMetricsService) Export(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.metadata <- md
+	f.requests <- request
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestOTLPStorage_HTTP(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var request colmetricspb.ExportMetricsServiceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "any-key", r.Header.Get("Api-Key"))
+		content, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, proto.Unmarshal(content, &request))
+	}))
+	defer server.Close()
+
+	storage, err := NewOTLPStorage(server.URL, OTLPProtocolHTTP, HTTPOptions{Headers: map[string]string{"api-key": "any-key"}}, map[string]string{"zettelkasten.directory": "/any/dir"}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), otlpTestMetrics, timestamp)
+	require.NoError(t, err)
+
+	assertOTLPRequest(t, &request, timestamp)
+}
+
+func TestOTLPStorage_GRPC(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	service := &fakeMetricsService{
+		requests: make(chan *colmetricspb.ExportMetricsServiceRequest, 1),
+		metadata: make(chan metadata.MD, 1),
+	}
+	server := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(server, service)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	storage, err := NewOTLPStorage("http://"+listener.Addr().String(), OTLPProtocolGRPC, HTTPOptions{Headers: map[string]string{"api-key": "any-key"}}, map[string]string{"zettelkasten.directory": "/any/dir"}, MetricNaming{})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, storage.Close()) }()
+	err = storage.WriteMetrics(context.Background(), otlpTestMetrics, timestamp)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"any-key"}, (<-service.metadata).Get("api-key"))
+	assertOTLPRequest(t, <-service.requests, timestamp)
+}
+
+func TestOTLPStorage_GRPCWithTLS(t *testing.T) {
+	// Reusing the self signed certificate of the test HTTP server
+	certificateServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certificateServer.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateServer.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, ca, 0o600))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	service := &fakeMetricsService{
+		requests: make(chan *colmetricspb.ExportMetricsServiceRequest, 1),
+		metadata: make(chan metadata.MD, 1),
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: certificateServer.TLS.Certificates})))
+	colmetricspb.RegisterMetricsServiceServer(server, service)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+	endpoint := "https://" + listener.Addr().String()
+
+	// The certificate is rejected unless its CA is trusted
+	storage, err := NewOTLPStorage(endpoint, OTLPProtocolGRPC, HTTPOptions{Timeout: time.Second}, nil, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), otlpTestMetrics, time.Now())
+	assert.Error(t, err)
+	require.NoError(t, storage.Close())
+
+	storage, err = NewOTLPStorage(endpoint, OTLPProtocolGRPC, HTTPOptions{CAFile: caFile}, nil, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), otlpTestMetrics, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+	assert.NotNil(t, <-service.requests)
+}
+
+func TestOTLPStorage_StaleNotes(t *testing.T) {
+	samples, err := createStaleSamples(MetricNaming{}, map[string]metrics.NoteMetrics{"gone": {}}, time.Now())
+	require.NoError(t, err)
+	request := createOTLPRequest(nil, samples, nil)
+
+	require.Len(t, request.ResourceMetrics, 1)
+	gauges := request.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	require.Len(t, gauges, 3)
+	for _, metric := range gauges {
+		require.Len(t, metric.GetGauge().DataPoints, 1)
+		dataPoint := metric.GetGauge().DataPoints[0]
+		assert.Nil(t, dataPoint.Value, metric.Name)
+		assert.Equal(t, uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK), dataPoint.Flags, metric.Name)
+	}
+}
+
+func TestOTLPStorage_InvalidProtocol(t *testing.T) {
+	_, err := NewOTLPStorage("http://localhost:4318", "http/json", HTTPOptions{}, nil, MetricNaming{})
+	assert.Error(t, err)
+}
+
+// assertOTLPRequest asserts that `request` contains the metrics from `otlpTestMetrics`.
+func assertOTLPRequest(t *testing.T, request *colmetricspb.ExportMetricsServiceRequest, timestamp time.Time) {
+	require.Len(t, request.ResourceMetrics, 1)
+	resourceMetrics := request.ResourceMetrics[0]
+	require.Len(t, resourceMetrics.Resource.Attributes, 1)
+	assert.Equal(t, "zettelkasten.directory", resourceMetrics.Resource.Attributes[0].Key)
+	assert.Equal(t, "/any/dir", resourceMetrics.Resource.Attributes[0].Value.GetStringValue())
+
+	require.Len(t, resourceMetrics.ScopeMetrics, 1)
+	values := make(map[string]float64)
+	for _, metric := range resourceMetrics.ScopeMetrics[0].Metrics {
+		for _, dataPoint := range metric.GetGauge().DataPoints {
+			assert.Equal(t, uint64(timestamp.UnixNano()), dataPoint.TimeUnixNano)
+			name := metric.Name
+			for _, attribute := range dataPoint.Attributes {
+				name += "/" + attribute.Key + "=" + attribute.Value.GetStringValue()
+			}
+			values[name] = dataPoint.GetAsDouble()
+		}
+	}
+	expected := map[string]float64{
+		"total_note_count":              1,
+		"total_link_count":              2,
+		"total_word_count":              3,
+		"notes_link_count/name=one":     2,
+		"notes_word_count/name=one":     3,
+		"notes_backlink_count/name=one": 4,
+	}
+	assert.Equal(t, expected, values)
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// postgresDialect is the dialect of PostgreSQL.
+var postgresDialect = sqlDialect{
+	timestamp:     "time",
+	timestampType: "TIMESTAMPTZ",
+	textType:      "TEXT",
+	integerType:   "BIGINT",
+	placeholder:   func(position int) string { return fmt.Sprintf("$%d", position) },
+}
+
+// timescaleDBSchema returns the statements turning the tables named by `naming` into TimescaleDB hypertables.
+func timescaleDBSchema(naming MetricNaming) string {
+	var schema strings.Builder
+	schema.WriteString("CREATE EXTENSION IF NOT EXISTS timescaledb;\n")
+	for _, table := range sqlTables {
+		fmt.Fprintf(&schema, "SELECT create_hypertable(%s, 'time', if_not_exists => TRUE, migrate_data => TRUE);\n",
+			sqlString(sqlIdentifier(naming.measurement(table.measurement))))
+	}
+	return schema.String()
+}
+
+// PostgresStorage represents the implementation of a metric storage using PostgreSQL, optionally with TimescaleDB.
+type PostgresStorage struct {
+	db         *sql.DB
+	naming     MetricNaming
+	statements sqlStatements
+}
+
+// NewPostgresStorage creates a new `PostgresStorage` connecting to the database at `url`, creating its schema if needed.
+//
+// When `timescaleDB` is true, the tables are created as TimescaleDB hypertables. The tables are
+// named and labelled according to `naming`.
+func NewPostgresStorage(url string, timescaleDB bool, naming MetricNaming) (PostgresStorage, error) {
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		return PostgresStorage{}, fmt.Errorf("error opening PostgreSQL database: %w", err)
+	}
+
+	_, err = db.Exec(postgresDialect.schema(naming))
+	if err != nil {
+		return PostgresStorage{}, fmt.Errorf("error creating PostgreSQL schema: %w", err)
+	}
+	if timescaleDB {
+		_, err = db.Exec(timescaleDBSchema(naming))
+		if err != nil {
+			return PostgresStorage{}, fmt.Errorf("error creating TimescaleDB hypertables: %w", err)
+		}
+	}
+	return PostgresStorage{db: db, naming: naming, statements: postgresDialect.statements(naming)}, nil
+}
+
+// WriteMetrics writes `zettelkastenMetrics` to the database with `timestamp`.
+//
+// Existing rows with the same timestamp are updated, so writing the same metrics twice is idempotent.
+func (p PostgresStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	slog.Debug("Writing metrics to PostgreSQL", slog.Int("notes", len(zettelkastenMetrics.Notes)))
+	err := writeSQLMetrics(ctx, p.db, p.statements, p.naming, zettelkastenMetrics, timestamp)
+	if err != nil {
+		slog.Error("Error writing metrics to PostgreSQL storage", slog.Any("error", err))
+	}
+	return err
+}
+
+// LatestTimestamp returns the timestamp of the latest aggregated metrics with the static labels of the storage in the database.
+func (p PostgresStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	var latest sql.NullTime
+	err := p.db.QueryRowContext(ctx, postgresDialect.latestTimestamp(p.naming), sqlLabelArgs(p.naming)...).Scan(&latest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error querying PostgreSQL database: %w", err)
+	}
+	if !latest.Valid {
+		return time.Time{}, nil
+	}
+	return latest.Time, nil
+}
+
+// Close closes the connections to the database.
+func (p PostgresStorage) Close() error {
+	err := p.db.Close()
+	if err != nil {
+		return fmt.Errorf("error closing PostgreSQL database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func TestPostgresStorage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping PostgreSQL integration test")
+	}
+
+	ctx := context.Background()
+	timescaleDB, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "timescale/timescaledb:latest-pg16",
+			ExposedPorts: []string{"5432/tcp"},
+			Env: map[string]string{
+				"POSTGRES_USER":     "zettelkasten",
+				"POSTGRES_PASSWORD": "password",
+				"POSTGRES_DB":       "zettelkasten",
+			},
+			WaitingFor: wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+		},
+		Started: true,
+	})
+	defer testcontainers.CleanupContainer(t, timescaleDB)
+	require.NoError(t, err)
+
+	host, err := timescaleDB.Host(ctx)
+	require.NoError(t, err)
+	port, err := timescaleDB.MappedPort(ctx, "5432")
+	require.NoError(t, err)
+	url := fmt.Sprintf("postgres://zettelkasten:password@%s:%s/zettelkasten?sslmode=disable", host, port.Port())
+
+	storage, err := NewPostgresStorage(url, true, MetricNaming{})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{
+		NoteCount: 2,
+		LinkCount: 3,
+		WordCount: 15,
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {Links: map[string]uint{"two": 2}, LinkCount: 2, WordCount: 10, BacklinkCount: 1},
+			"two": {Links: map[string]uint{"one": 1}, LinkCount: 1, WordCount: 5, BacklinkCount: 2},
+		},
+	}
+	// Writing twice should not duplicate rows
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+
+	latest, err := storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.True(t, timestamp.Equal(latest))
+
+	db, err := sql.Open("pgx", url)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	var noteCount, linkCount, wordCount uint
+	err = db.QueryRow("SELECT note_count, link_count, word_count FROM total WHERE time = $1", timestamp).Scan(&noteCount, &linkCount, &wordCount)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{2, 3, 15}, []uint{noteCount, linkCount, wordCount})
+
+	for table, expected := range map[string]int{"total": 1, "notes": 2, "links": 2} {
+		var rows int
+		err = db.QueryRow("SELECT count(*) FROM " + table).Scan(&rows)
+		require.NoError(t, err)
+		assert.Equal(t, expected, rows, table)
+	}
+	require.NoError(t, storage.Close())
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// metricDescriptions holds the help text for each field of the measurements exposed to Prometheus.
+var metricDescriptions = map[string]map[string]string{
+	notesMeasurementName: {
+		"link_count":     "Number of links in the note",
+		"word_count":     "Number of words in the note",
+		"backlink_count": "Number of links that reference the note",
+	},
+	totalMeasurementName: {
+		"note_count": "Number of notes in the Zettelkasten",
+		"link_count": "Number of links in the Zettelkasten",
+		"word_count": "Number of words in the Zettelkasten",
+	},
+	tasksMeasurementName: {
+		"open_count":      "Number of open tasks in the Zettelkasten",
+		"completed_count": "Number of completed tasks in the Zettelkasten",
+	},
+	noteTasksMeasurementName: {
+		"open_count":      "Number of open tasks in the note",
+		"completed_count": "Number of completed tasks in the note",
+	},
+	anchorsMeasurementName: {
+		"link_count":           "Number of links to headings and blocks of notes in the Zettelkasten",
+		"broken_heading_count": "Number of links to headings that don't exist in the linked note",
+		"broken_block_count":   "Number of links to blocks that don't exist in the linked note",
+	},
+	noteAnchorsMeasurementName: {
+		"link_count":           "Number of links to headings and blocks of notes in the note",
+		"broken_heading_count": "Number of links in the note to headings that don't exist in the linked note",
+		"broken_block_count":   "Number of links in the note to blocks that don't exist in the linked note",
+	},
+	activityMeasurementName: {
+		"note_count":               "Number of notes with a known creation date",
+		"average_note_age_seconds": "Average time since the creation of the notes",
+		"created_last_day":         "Number of notes created in the last day",
+		"created_last_week":        "Number of notes created in the last 7 days",
+		"created_last_month":       "Number of notes created in the last 30 days",
+		"modified_last_day":        "Number of notes modified in the last day",
+		"modified_last_week":       "Number of notes modified in the last 7 days",
+		"modified_last_month":      "Number of notes modified in the last 30 days",
+	},
+	deletedNotesMeasurementName: {
+		"deleted": "Whether the note was deleted since the previous collection",
+	},
+	tagsMeasurementName: {
+		"note_count": "Number of notes with the tag",
+		"word_count": "Number of words in the notes with the tag",
+		"link_count": "Number of links in the notes with the tag",
+	},
+	tagLinksMeasurementName: {
+		"count": "Number of links from the notes with the source tag to the notes with the target tag",
+	},
+}
+
+// prometheusShutdownTimeout is how long the scrapes in flight are waited for when shutting down.
+const prometheusShutdownTimeout = 5 * time.Second
+
+// PrometheusStorage represents the implementation of a metric storage that
+// keeps the latest metrics in memory and exposes them to be scraped by Prometheus.
+//
+// Since Prometheus pulls the metrics, only the latest written metrics are
+// exposed and historical metrics are not supported.
+type PrometheusStorage struct {
+	mu           *sync.RWMutex
+	samples      []sample
+	naming       MetricNaming
+	descriptions map[string]string
+}
+
+// NewPrometheusStorage creates a new `PrometheusStorage` serving the metrics
+// endpoint on `address`, with the metrics named and labelled according to `naming`.
+//
+// The server is shut down when `ctx` is cancelled.
+func NewPrometheusStorage(ctx context.Context, address string, naming MetricNaming) (*PrometheusStorage, error) {
+	p := &PrometheusStorage{mu: &sync.RWMutex{}, naming: naming, descriptions: createMetricDescriptions(naming)}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", p)
+	server := &http.Server{Handler: mux}
+	go func() {
+		slog.Info("Serving Prometheus metrics", slog.String("address", listener.Addr().String()))
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error serving Prometheus metrics", slog.Any("error", err))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), prometheusShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			slog.Warn("Error shutting down Prometheus metrics server", slog.Any("error", err))
+		}
+	}()
+
+	return p, nil
+}
+
+// WriteMetrics replaces the exposed metrics with `zettelkastenMetrics`.
+func (p *PrometheusStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	points := createInfluxDBPoints(p.naming, zettelkastenMetrics, timestamp)
+	samples, err := createSamples(points)
+	if err != nil {
+		slog.Error("Error creating samples", slog.Any("error", err))
+		return err
+	}
+	sortSamples(samples)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples = samples
+	return nil
+}
+
+// LatestTimestamp is not supported by the Prometheus storage, since it only keeps the latest metrics in memory.
+func (p *PrometheusStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("prometheus storage: %w", errors.ErrUnsupported)
+}
+
+// ServeHTTP writes the latest metrics in the Prometheus text exposition format.
+func (p *PrometheusStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.RLock()
+	content := encodeExposition(p.samples, p.descriptions)
+	p.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, err := w.Write(content)
+	if err != nil {
+		slog.Warn("Error writing metrics response", slog.Any("error", err))
+	}
+}
+
+// createMetricDescriptions returns the help text for each metric exposed to Prometheus, by metric name.
+func createMetricDescriptions(naming MetricNaming) map[string]string {
+	descriptions := make(map[string]string)
+	for measurement, fields := range metricDescriptions {
+		for field, description := range fields {
+			descriptions[fmt.Sprintf("%s_%s", naming.measurement(measurement), field)] = description
+		}
+	}
+	return descriptions
+}
+
+// sortSamples sorts `samples` by name and labels, so that all samples of a metric are next to each other.
+func sortSamples(samples []sample) {
+	slices.SortFunc(samples, func(a, b sample) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+		return strings.Compare(formatLabels(a), formatLabels(b))
+	})
+}
+
+// encodeExposition encodes the sorted `samples` into the Prometheus text exposition format,
+// with the help text of each metric taken from `descriptions`.
+// Reference: https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+func encodeExposition(samples []sample, descriptions map[string]string) []byte {
+	var buffer bytes.Buffer
+	previousName := ""
+	for _, s := range samples {
+		if s.name != previousName {
+			if description, ok := descriptions[s.name]; ok {
+				fmt.Fprintf(&buffer, "# HELP %s %s\n", s.name, description)
+			}
+			fmt.Fprintf(&buffer, "# TYPE %s gauge\n", s.name)
+			previousName = s.name
+		}
+		fmt.Fprintf(&buffer, "%s%s %s\n", s.name, formatLabels(s), strconv.FormatFloat(s.value, 'f', -1, 64))
+	}
+	return buffer.Bytes()
+}
+
+// formatLabels formats the labels of `s` as a Prometheus label set.
+func formatLabels(s sample) string {
+	if len(s.labels) == 0 {
+		return ""
+	}
+	labels := make([]string, 0, len(s.labels))
+	for _, label := range s.labels {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", label.Key, labelValueReplacer.Replace(label.Value)))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(labels, ","))
+}
+
+// labelValueReplacer escapes label values according to the Prometheus text format.
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusStorage(t *testing.T) {
+	storage := &PrometheusStorage{mu: &sync.RWMutex{}, descriptions: createMetricDescriptions(MetricNaming{})}
+	err := storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{
+		NoteCount: 2,
+		LinkCount: 1,
+		WordCount: 15,
+		Notes: map[string]metrics.NoteMetrics{
+			"one":          {Links: map[string]uint{"two": 1}, LinkCount: 1, WordCount: 10, BacklinkCount: 0},
+			`two "quoted"`: {Links: map[string]uint{}, LinkCount: 0, WordCount: 5, BacklinkCount: 1},
+		},
+	}, time.Now())
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	storage.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `# HELP notes_backlink_count Number of links that reference the note
+# TYPE notes_backlink_count gauge
+notes_backlink_count{name="one"} 0
+notes_backlink_count{name="two \"quoted\""} 1
+# HELP notes_link_count Number of links in the note
+# TYPE notes_link_count gauge
+notes_link_count{name="one"} 1
+notes_link_count{name="two \"quoted\""} 0
+# HELP notes_word_count Number of words in the note
+# TYPE notes_word_count gauge
+notes_word_count{name="one"} 10
+notes_word_count{name="two \"quoted\""} 5
+# HELP total_link_count Number of links in the Zettelkasten
+# TYPE total_link_count gauge
+total_link_count 1
+# HELP total_note_count Number of notes in the Zettelkasten
+# TYPE total_note_count gauge
+total_note_count 2
+# HELP total_word_count Number of words in the Zettelkasten
+# TYPE total_word_count gauge
+total_word_count 15
+`
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, expected, recorder.Body.String())
+}
+
+func TestPrometheusStorage_MetricNaming(t *testing.T) {
+	naming := MetricNaming{Prefix: "zettelkasten_", Labels: map[string]string{"vault": "work"}}
+	storage := &PrometheusStorage{mu: &sync.RWMutex{}, naming: naming, descriptions: createMetricDescriptions(naming)}
+	err := storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 2, LinkCount: 1, WordCount: 15, Notes: map[string]metrics.NoteMetrics{}}, time.Now())
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	storage.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Contains(t, recorder.Body.String(), `# HELP zettelkasten_total_note_count Number of notes in the Zettelkasten
+# TYPE zettelkasten_total_note_count gauge
+zettelkasten_total_note_count{vault="work"} 2
+`)
+}
+
+func TestPrometheusStorage_NoteLabels(t *testing.T) {
+	naming := MetricNaming{NoteLabels: map[string]string{"type": "type", "created_at": "created-at", "status": "status"}}
+	storage := &PrometheusStorage{mu: &sync.RWMutex{}, naming: naming, descriptions: createMetricDescriptions(naming)}
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{
+		NoteCount: 2,
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {WordCount: 3, Frontmatter: map[string]any{"type": "idea", "created-at": "2024-05-29", "status": []any{"draft"}}},
+			"two": {WordCount: 5},
+		},
+	}
+	err := storage.WriteMetrics(context.Background(), zettelkastenMetrics, time.Now())
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	storage.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	// Missing fields and fields that are not scalars are left out
+	assert.Contains(t, recorder.Body.String(), `notes_word_count{created_at="2024-05-29",name="one",type="idea"} 3
+notes_word_count{name="two"} 5
+`)
+}
+
+func TestPrometheusStorage_Shutdown(t *testing.T) {
+	// Reserving a free port for the storage
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = NewPrometheusStorage(ctx, address, MetricNaming{})
+	require.NoError(t, err)
+
+	response, err := http.Get("http://" + address + "/metrics")
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// The port is released once the context is cancelled
+	cancel()
+	assert.Eventually(t, func() bool {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return false
+		}
+		return listener.Close() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
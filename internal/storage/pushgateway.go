@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// PushgatewayStorage represents the implementation of a metric storage that pushes
+// the metrics to a Prometheus Pushgateway.
+//
+// Each write replaces all metrics in the group identified by the job and grouping key,
+// so the metrics of notes that no longer exist are removed from the Pushgateway.
+// Since the Pushgateway doesn't accept timestamps, only the latest written metrics
+// are kept and historical metrics are not supported.
+type PushgatewayStorage struct {
+	url          string
+	client       *http.Client
+	options      HTTPOptions
+	naming       MetricNaming
+	descriptions map[string]string
+}
+
+// NewPushgatewayStorage creates a new `PushgatewayStorage` pushing the metrics named according
+// to `naming` to the Pushgateway at `baseUrl`, in the group of `job` and `groupingKey`.
+func NewPushgatewayStorage(baseUrl, job string, groupingKey map[string]string, options HTTPOptions, naming MetricNaming) (PushgatewayStorage, error) {
+	pushUrl, err := url.Parse(fmt.Sprintf("%s/metrics/%s", strings.TrimSuffix(baseUrl, "/"), pushgatewayGroupPath(job, groupingKey)))
+	if err != nil {
+		return PushgatewayStorage{}, fmt.Errorf("error parsing Pushgateway URL: %w", err)
+	}
+	client, err := options.newHTTPClient()
+	if err != nil {
+		return PushgatewayStorage{}, err
+	}
+	return PushgatewayStorage{
+		url:          pushUrl.String(),
+		client:       client,
+		options:      options,
+		naming:       naming,
+		descriptions: createMetricDescriptions(naming),
+	}, nil
+}
+
+// WriteMetrics replaces the metrics of the group in the Pushgateway with `zettelkastenMetrics`.
+func (p PushgatewayStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	points := createInfluxDBPoints(p.naming, zettelkastenMetrics, timestamp)
+	samples, err := createSamples(points)
+	if err != nil {
+		slog.Error("Error creating samples", slog.Any("error", err))
+		return err
+	}
+	sortSamples(samples)
+
+	request, err := p.options.newRequest(ctx, p.url, "text/plain; version=0.0.4; charset=utf-8", encodeExposition(samples, p.descriptions))
+	if err != nil {
+		return fmt.Errorf("error creating Pushgateway request: %w", err)
+	}
+	// PUT replaces the whole group, while POST would only replace the metrics with the same names
+	request.Method = http.MethodPut
+
+	slog.Debug("Pushing metrics to Pushgateway", slog.Int("samples", len(samples)))
+	err = sendRequest(p.client, request)
+	if err != nil {
+		slog.Error("Error pushing metrics to Pushgateway", slog.Any("error", err), slog.String("url", p.url))
+		return err
+	}
+	return nil
+}
+
+// LatestTimestamp is not supported by the Pushgateway storage, since it only keeps the latest metrics.
+func (p PushgatewayStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("pushgateway storage: %w", errors.ErrUnsupported)
+}
+
+// pushgatewayGroupPath returns the URL path identifying the group of `job` and `groupingKey`,
+// with the labels of the grouping key in sorted order.
+// Reference: https://github.com/prometheus/pushgateway#url
+func pushgatewayGroupPath(job string, groupingKey map[string]string) string {
+	segments := []string{pushgatewayLabelPath("job", job)}
+	for _, name := range slices.Sorted(maps.Keys(groupingKey)) {
+		segments = append(segments, pushgatewayLabelPath(name, groupingKey[name]))
+	}
+	return strings.Join(segments, "/")
+}
+
+// pushgatewayLabelPath returns the URL path of the label `name` with `value`. Values that are
+// empty or contain slashes are base64 encoded, since they can't be path segments otherwise.
+func pushgatewayLabelPath(name, value string) string {
+	if value == "" {
+		// An empty path segment is not allowed, so the Pushgateway expects a single padding character
+		return fmt.Sprintf("%s@base64/=", name)
+	}
+	if strings.Contains(value, "/") {
+		return fmt.Sprintf("%s@base64/%s", name, base64.URLEncoding.EncodeToString([]byte(value)))
+	}
+	return fmt.Sprintf("%s/%s", name, url.PathEscape(value))
+}
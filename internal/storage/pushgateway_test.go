@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushgatewayStorage(t *testing.T) {
+	var content string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/metrics/job/zettelkasten/instance/work/path@base64/L3ZhdWx0L25vdGVz", r.URL.EscapedPath())
+		assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", r.Header.Get("Content-Type"))
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "any-user", username)
+		assert.Equal(t, "any-password", password)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		content = string(body)
+	}))
+	defer server.Close()
+
+	groupingKey := map[string]string{"path": "/vault/notes", "instance": "work"}
+	storage, err := NewPushgatewayStorage(server.URL, "zettelkasten", groupingKey, HTTPOptions{Username: "any-user", Password: "any-password"}, MetricNaming{})
+	require.NoError(t, err)
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{
+		NoteCount: 1,
+		LinkCount: 2,
+		WordCount: 3,
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {LinkCount: 2, WordCount: 3, BacklinkCount: 4},
+		},
+	}
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, time.Now()))
+
+	expected := `# HELP notes_backlink_count Number of links that reference the note
+# TYPE notes_backlink_count gauge
+notes_backlink_count{name="one"} 4
+# HELP notes_link_count Number of links in the note
+# TYPE notes_link_count gauge
+notes_link_count{name="one"} 2
+# HELP notes_word_count Number of words in the note
+# TYPE notes_word_count gauge
+notes_word_count{name="one"} 3
+# HELP total_link_count Number of links in the Zettelkasten
+# TYPE total_link_count gauge
+total_link_count 2
+# HELP total_note_count Number of notes in the Zettelkasten
+# TYPE total_note_count gauge
+total_note_count 1
+# HELP total_word_count Number of words in the Zettelkasten
+# TYPE total_word_count gauge
+total_word_count 3
+`
+	assert.Equal(t, expected, content)
+}
+
+func TestPushgatewayStorage_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("pushed metrics are invalid or inconsistent with existing metrics"))
+	}))
+	defer server.Close()
+
+	storage, err := NewPushgatewayStorage(server.URL, "zettelkasten", nil, HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}}, time.Now())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "inconsistent")
+
+	_, err = storage.LatestTimestamp(context.Background())
+	assert.True(t, errors.Is(err, errors.ErrUnsupported))
+}
+
+func TestPushgatewayGroupPath(t *testing.T) {
+	data := []struct {
+		name        string
+		job         string
+		groupingKey map[string]string
+		expected    string
+	}{
+		{name: "job only", job: "zettelkasten", expected: "job/zettelkasten"},
+		{name: "sorted grouping key", job: "zettelkasten", groupingKey: map[string]string{"vault": "notes", "instance": "work"}, expected: "job/zettelkasten/instance/work/vault/notes"},
+		{name: "empty value", job: "zettelkasten", groupingKey: map[string]string{"instance": ""}, expected: "job/zettelkasten/instance@base64/="},
+		{name: "value with slash", job: "a/b", expected: "job@base64/YS9i"},
+		{name: "value with space", job: "my job", expected: "job/my%20job"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			assert.Equal(t, d.expected, pushgatewayGroupPath(d.job, d.groupingKey))
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// The label holding the metric name in Prometheus time series.
+const metricNameLabel = "__name__"
+
+// RemoteWriteStorage represents the implementation of a metric storage using the Prometheus remote write protocol.
+type RemoteWriteStorage struct {
+	url     string
+	client  *http.Client
+	options HTTPOptions
+	naming  MetricNaming
+}
+
+// NewRemoteWriteStorage creates a new `RemoteWriteStorage` writing to `url` the metrics named according to `naming`.
+func NewRemoteWriteStorage(url string, options HTTPOptions, naming MetricNaming) (RemoteWriteStorage, error) {
+	client, err := options.newHTTPClient()
+	if err != nil {
+		return RemoteWriteStorage{}, err
+	}
+	return RemoteWriteStorage{url: url, client: client, options: options, naming: naming}, nil
+}
+
+// WriteMetrics writes `zettelkastenMetrics` to the remote write endpoint with `timestamp`.
+func (r RemoteWriteStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	points := createInfluxDBPoints(r.naming, zettelkastenMetrics, timestamp)
+	samples, err := createSamples(points)
+	if err != nil {
+		slog.Error("Error creating samples", slog.Any("error", err))
+		return err
+	}
+	staleSamples, err := createStaleSamples(r.naming, zettelkastenMetrics.StaleNotes, timestamp)
+	if err != nil {
+		slog.Error("Error creating stale samples", slog.Any("error", err))
+		return err
+	}
+	samples = append(samples, staleSamples...)
+	content := snappy.Encode(nil, encodeWriteRequest(samples))
+
+	request, err := r.options.newRequest(ctx, r.url, "application/x-protobuf", content)
+	if err != nil {
+		return fmt.Errorf("error creating remote write request: %w", err)
+	}
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	slog.Debug("Writing metrics to remote write endpoint", slog.Int("samples", len(samples)))
+	err = sendRequest(r.client, request)
+	if err != nil {
+		slog.Error("Error writing metrics to remote write endpoint", slog.Any("error", err), slog.String("url", r.url))
+		return err
+	}
+	return nil
+}
+
+// LatestTimestamp is not supported by the remote write storage, since the protocol is write only.
+func (r RemoteWriteStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("remote write storage: %w", errors.ErrUnsupported)
+}
+
+// encodeWriteRequest encodes `samples` as a protobuf remote write `WriteRequest`, with one time series per sample.
+// Reference: https://prometheus.io/docs/specs/remote_write_spec/
+func encodeWriteRequest(samples []sample) []byte {
+	var request []byte
+	for _, s := range samples {
+		var series []byte
+
+		labels := make([][2]string, 0, len(s.labels)+1)
+		labels = append(labels, [2]string{metricNameLabel, s.name})
+		for _, label := range s.labels {
+			labels = append(labels, [2]string{label.Key, label.Value})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+		for _, label := range labels {
+			var encodedLabel []byte
+			encodedLabel = protowire.AppendTag(encodedLabel, 1, protowire.BytesType)
+			encodedLabel = protowire.AppendString(encodedLabel, label[0])
+			encodedLabel = protowire.AppendTag(encodedLabel, 2, protowire.BytesType)
+			encodedLabel = protowire.AppendString(encodedLabel, label[1])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, encodedLabel)
+		}
+
+		var encodedSample []byte
+		encodedSample = protowire.AppendTag(encodedSample, 1, protowire.Fixed64Type)
+		encodedSample = protowire.AppendFixed64(encodedSample, math.Float64bits(s.value))
+		encodedSample = protowire.AppendTag(encodedSample, 2, protowire.VarintType)
+		encodedSample = protowire.AppendVarint(encodedSample, uint64(s.timestamp.UnixMilli()))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, encodedSample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteSeries is a decoded time series from a remote write request.
+type remoteWriteSeries struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+func TestRemoteWriteStorage(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var series []remoteWriteSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal(t, "tenant", r.Header.Get("X-Scope-OrgID"))
+		assert.Equal(t, "Bearer any-token", r.Header.Get("Authorization"))
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		content, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		series = decodeWriteRequest(t, content)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	storage, err := NewRemoteWriteStorage(server.URL, HTTPOptions{BearerToken: "any-token", Headers: map[string]string{"X-Scope-OrgID": "tenant"}}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{
+		NoteCount: 1,
+		LinkCount: 2,
+		WordCount: 3,
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {Links: map[string]uint{"two": 2}, LinkCount: 2, WordCount: 3, BacklinkCount: 4},
+		},
+	}, timestamp)
+	require.NoError(t, err)
+
+	expected := []remoteWriteSeries{
+		{labels: map[string]string{"__name__": "total_link_count"}, value: 2, timestamp: timestamp.UnixMilli()},
+		{labels: map[string]string{"__name__": "total_note_count"}, value: 1, timestamp: timestamp.UnixMilli()},
+		{labels: map[string]string{"__name__": "total_word_count"}, value: 3, timestamp: timestamp.UnixMilli()},
+		{labels: map[string]string{"__name__": "notes_backlink_count", "name": "one"}, value: 4, timestamp: timestamp.UnixMilli()},
+		{labels: map[string]string{"__name__": "notes_link_count", "name": "one"}, value: 2, timestamp: timestamp.UnixMilli()},
+		{labels: map[string]string{"__name__": "notes_word_count", "name": "one"}, value: 3, timestamp: timestamp.UnixMilli()},
+	}
+	assert.Equal(t, expected, series)
+}
+
+func TestRemoteWriteStorage_StaleNotes(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var series []remoteWriteSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		content, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		series = decodeWriteRequest(t, content)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	storage, err := NewRemoteWriteStorage(server.URL, HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}, StaleNotes: map[string]metrics.NoteMetrics{"gone": {}}}, timestamp)
+	require.NoError(t, err)
+
+	stale := make(map[string]uint64)
+	for _, s := range series {
+		if s.labels["name"] == "gone" {
+			stale[s.labels["__name__"]] = math.Float64bits(s.value)
+		}
+	}
+	staleBits := math.Float64bits(staleNaN)
+	expected := map[string]uint64{"notes_backlink_count": staleBits, "notes_link_count": staleBits, "notes_word_count": staleBits}
+	assert.Equal(t, expected, stale)
+}
+
+func TestRemoteWriteStorage_StaleNotesLabels(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var series []remoteWriteSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		content, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		series = decodeWriteRequest(t, content)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	naming := MetricNaming{Prefix: "zk_", Labels: map[string]string{"vault": "work"}, NoteLabels: map[string]string{"type": "type"}}
+	note := metrics.NoteMetrics{
+		Links:              map[string]uint{},
+		WordCount:          3,
+		Frontmatter:        map[string]any{"type": "idea"},
+		OpenTaskCount:      1,
+		CompletedTaskCount: 2,
+		AnchorLinkCount:    1,
+	}
+
+	storage, err := NewRemoteWriteStorage(server.URL, HTTPOptions{}, naming)
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{"gone": note}}, timestamp)
+	require.NoError(t, err)
+	live := noteSeriesLabels(series, "gone")
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}, StaleNotes: map[string]metrics.NoteMetrics{"gone": note}}, timestamp.Add(time.Minute))
+	require.NoError(t, err)
+
+	// Each series of the note ends with a marker with exactly the same labels
+	assert.Len(t, live, 8)
+	assert.ElementsMatch(t, live, noteSeriesLabels(series, "gone"))
+	for _, s := range series {
+		if s.labels["name"] == "gone" {
+			assert.True(t, isStaleNaN(s.value), "%v should be a staleness marker", s.labels)
+		}
+	}
+}
+
+// noteSeriesLabels returns the label sets of the series of the note `name` in `series`.
+func noteSeriesLabels(series []remoteWriteSeries, name string) []map[string]string {
+	labels := make([]map[string]string, 0)
+	for _, s := range series {
+		if s.labels["name"] == name {
+			labels = append(labels, s.labels)
+		}
+	}
+	return labels
+}
+
+func TestRemoteWriteStorage_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("out of order sample"))
+	}))
+	defer server.Close()
+
+	storage, err := NewRemoteWriteStorage(server.URL, HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}}, time.Now())
+	assert.ErrorContains(t, err, "out of order sample")
+}
+
+func TestRemoteWriteStorage_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// The self signed certificate of the server is rejected unless verification is skipped
+	storage, err := NewRemoteWriteStorage(server.URL, HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}}, time.Now())
+	assert.ErrorContains(t, err, "certificate")
+
+	storage, err = NewRemoteWriteStorage(server.URL, HTTPOptions{InsecureSkipVerify: true}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}}, time.Now())
+	assert.NoError(t, err)
+
+	_, err = NewRemoteWriteStorage(server.URL, HTTPOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, MetricNaming{})
+	assert.ErrorContains(t, err, "error reading CA file")
+}
+
+// decodeWriteRequest decodes the time series of a protobuf encoded `WriteRequest`.
+func decodeWriteRequest(t *testing.T, content []byte) []remoteWriteSeries {
+	var result []remoteWriteSeries
+	forEachField(t, content, func(_ protowire.Number, value []byte) {
+		series := remoteWriteSeries{labels: map[string]string{}}
+		forEachField(t, value, func(number protowire.Number, value []byte) {
+			switch number {
+			case 1:
+				var name, labelValue string
+				forEachField(t, value, func(number protowire.Number, value []byte) {
+					if number == 1 {
+						name = string(value)
+					} else {
+						labelValue = string(value)
+					}
+				})
+				series.labels[name] = labelValue
+			case 2:
+				bits, n := protowire.ConsumeFixed64(value[1:])
+				require.GreaterOrEqual(t, n, 0)
+				series.value = math.Float64frombits(bits)
+				timestamp, n := protowire.ConsumeVarint(value[1+n+1:])
+				require.GreaterOrEqual(t, n, 0)
+				series.timestamp = int64(timestamp)
+			}
+		})
+		result = append(result, series)
+	})
+	return result
+}
+
+// forEachField calls `f` for each length delimited field in `content`.
+func forEachField(t *testing.T, content []byte, f func(number protowire.Number, value []byte)) {
+	for len(content) > 0 {
+		number, _, n := protowire.ConsumeTag(content)
+		require.GreaterOrEqual(t, n, 0)
+		content = content[n:]
+		value, n := protowire.ConsumeBytes(content)
+		require.GreaterOrEqual(t, n, 0)
+		content = content[n:]
+		f(number, value)
+	}
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// staleNaN is the value used by Prometheus to mark a series as stale.
+// Reference: https://prometheus.io/docs/specs/remote_write_spec/#stale-markers
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// sample represents a single value of a named metric, as used by Prometheus-like storages.
+type sample struct {
+	name      string
+	labels    []*lp.Tag
+	value     float64
+	timestamp time.Time
+}
+
+// createSamples flattens `points` into one sample per field.
+//
+// Samples are named `<measurement>_<field>`, which is the same naming used by
+// VictoriaMetrics when ingesting InfluxDB line protocol.
+func createSamples(points []*write.Point) ([]sample, error) {
+	samples := make([]sample, 0, len(points)*3)
+	for _, point := range points {
+		for _, field := range point.FieldList() {
+			value, err := fieldValue(field.Value)
+			if err != nil {
+				return nil, fmt.Errorf("error converting field %s of %s: %w", field.Key, point.Name(), err)
+			}
+			samples = append(samples, sample{
+				name:      fmt.Sprintf("%s_%s", point.Name(), field.Key),
+				labels:    point.TagList(),
+				value:     value,
+				timestamp: point.Time(),
+			})
+		}
+	}
+	return samples, nil
+}
+
+// createStaleSamples creates a staleness marker with `timestamp` for each series of the deleted
+// `notes`, named and labelled according to `naming`.
+//
+// The markers are created from the metrics of the last collection of the notes, so that they
+// cover the same series with the same labels as their last samples.
+func createStaleSamples(naming MetricNaming, notes map[string]metrics.NoteMetrics, timestamp time.Time) ([]sample, error) {
+	samples, err := createSamples(createNotePoints(naming, notes, timestamp))
+	if err != nil {
+		return nil, err
+	}
+	for i := range samples {
+		samples[i].value = staleNaN
+	}
+	return samples, nil
+}
+
+// isStaleNaN reports whether `value` is a staleness marker.
+func isStaleNaN(value float64) bool {
+	return math.Float64bits(value) == math.Float64bits(staleNaN)
+}
+
+// fieldValue converts an InfluxDB point field value into a float.
+func fieldValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case uint64:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unsupported field type %T", value)
+	}
+}
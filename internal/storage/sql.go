@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// sqlTable represents a table of the fixed schema shared by the SQL and ClickHouse storages.
+type sqlTable struct {
+	// measurement is the default name of the measurement stored in the table, which is named
+	// after it.
+	measurement string
+	// keys are the text columns identifying the rows of the table along with their timestamp.
+	keys []string
+	// values are the integer columns of the table.
+	values []string
+	// note reports whether the rows of the table belong to a note, having its frontmatter labels.
+	note bool
+}
+
+var (
+	totalTable        = sqlTable{measurement: totalMeasurementName, values: []string{"note_count", "link_count", "word_count"}}
+	notesTable        = sqlTable{measurement: notesMeasurementName, keys: []string{"name"}, values: []string{"link_count", "word_count", "backlink_count"}, note: true}
+	linksTable        = sqlTable{measurement: linksMeasurementName, keys: []string{"source", "target"}, values: []string{"count"}}
+	deletedNotesTable = sqlTable{measurement: deletedNotesMeasurementName, keys: []string{"name"}, note: true}
+	tagsTable         = sqlTable{measurement: tagsMeasurementName, keys: []string{"tag"}, values: []string{"note_count", "word_count", "link_count"}}
+	tagLinksTable     = sqlTable{measurement: tagLinksMeasurementName, keys: []string{"source", "target"}, values: []string{"count"}}
+	tasksTable        = sqlTable{measurement: tasksMeasurementName, values: []string{"open_count", "completed_count"}}
+	noteTasksTable    = sqlTable{measurement: noteTasksMeasurementName, keys: []string{"name"}, values: []string{"open_count", "completed_count"}, note: true}
+	anchorsTable      = sqlTable{measurement: anchorsMeasurementName, values: []string{"link_count", "broken_heading_count", "broken_block_count"}}
+	noteAnchorsTable  = sqlTable{measurement: noteAnchorsMeasurementName, keys: []string{"name"}, values: []string{"link_count", "broken_heading_count", "broken_block_count"}, note: true}
+	activityTable     = sqlTable{measurement: activityMeasurementName, values: []string{
+		"note_count", "average_note_age_seconds",
+		"created_last_day", "created_last_week", "created_last_month",
+		"modified_last_day", "modified_last_week", "modified_last_month",
+	}}
+)
+
+// sqlTables are all tables of the fixed schema.
+var sqlTables = []sqlTable{
+	totalTable, notesTable, linksTable, deletedNotesTable, tagsTable, tagLinksTable,
+	tasksTable, noteTasksTable, anchorsTable, noteAnchorsTable, activityTable,
+}
+
+// keyColumns returns the columns identifying the rows of `table` along with `timestamp`, which
+// include the static labels of `naming`.
+func (t sqlTable) keyColumns(timestamp string, naming MetricNaming) []string {
+	return slices.Concat([]string{timestamp}, t.keys, naming.labelNames())
+}
+
+// columns returns all columns of `table` after `timestamp`, which are its keys and values followed
+// by the static labels of `naming` and by its frontmatter labels for the tables of notes.
+func (t sqlTable) columns(timestamp string, naming MetricNaming) []string {
+	columns := slices.Concat([]string{timestamp}, t.keys, t.values, naming.labelNames())
+	if t.note {
+		columns = append(columns, naming.noteLabelNames()...)
+	}
+	return columns
+}
+
+// sqlDialect represents the differences between the SQL databases supported as storages.
+type sqlDialect struct {
+	// timestamp is the name of the timestamp column.
+	timestamp string
+	// timestampType, textType and integerType are the types of the columns.
+	timestampType, textType, integerType string
+	// placeholder returns the placeholder of the argument at the one-based `position`.
+	placeholder func(position int) string
+}
+
+// schema returns the statements creating the tables of the fixed schema named by `naming`.
+func (d sqlDialect) schema(naming MetricNaming) string {
+	var schema strings.Builder
+	for _, table := range sqlTables {
+		columns := []string{fmt.Sprintf("%s %s NOT NULL", sqlIdentifier(d.timestamp), d.timestampType)}
+		for _, key := range table.keys {
+			columns = append(columns, fmt.Sprintf("%s %s NOT NULL", sqlIdentifier(key), d.textType))
+		}
+		for _, value := range table.values {
+			columns = append(columns, fmt.Sprintf("%s %s NOT NULL", sqlIdentifier(value), d.integerType))
+		}
+		for _, label := range naming.labelNames() {
+			columns = append(columns, fmt.Sprintf("%s %s NOT NULL", sqlIdentifier(label), d.textType))
+		}
+		if table.note {
+			for _, label := range naming.noteLabelNames() {
+				columns = append(columns, fmt.Sprintf("%s %s", sqlIdentifier(label), d.textType))
+			}
+		}
+		keys := sqlIdentifiers(table.keyColumns(d.timestamp, naming))
+		columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(keys, ", ")))
+		fmt.Fprintf(&schema, "CREATE TABLE IF NOT EXISTS %s (\n\t%s\n);\n", sqlIdentifier(naming.measurement(table.measurement)), strings.Join(columns, ",\n\t"))
+	}
+	return schema.String()
+}
+
+// statements returns the statements upserting the rows of the tables named by `naming`.
+func (d sqlDialect) statements(naming MetricNaming) sqlStatements {
+	upsert := func(table sqlTable) string {
+		columns := sqlIdentifiers(table.columns(d.timestamp, naming))
+		keys := sqlIdentifiers(table.keyColumns(d.timestamp, naming))
+		return d.upsert(sqlIdentifier(naming.measurement(table.measurement)), columns, keys)
+	}
+	return sqlStatements{
+		total:        upsert(totalTable),
+		notes:        upsert(notesTable),
+		links:        upsert(linksTable),
+		deletedNotes: upsert(deletedNotesTable),
+		tags:         upsert(tagsTable),
+		tagLinks:     upsert(tagLinksTable),
+		tasks:        upsert(tasksTable),
+		noteTasks:    upsert(noteTasksTable),
+		anchors:      upsert(anchorsTable),
+		noteAnchors:  upsert(noteAnchorsTable),
+		activity:     upsert(activityTable),
+	}
+}
+
+// latestTimestamp returns the query selecting the latest timestamp of the aggregated metrics
+// with the static labels of `naming`, whose values are passed as arguments in sorted order.
+func (d sqlDialect) latestTimestamp(naming MetricNaming) string {
+	query := fmt.Sprintf("SELECT max(%s) FROM %s", sqlIdentifier(d.timestamp), sqlIdentifier(naming.measurement(totalMeasurementName)))
+	conditions := make([]string, 0, len(naming.Labels))
+	for i, label := range naming.labelNames() {
+		conditions = append(conditions, fmt.Sprintf("%s = %s", sqlIdentifier(label), d.placeholder(i+1)))
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return query
+}
+
+// upsert returns the statement inserting the quoted `columns` into the quoted `table`, updating
+// the existing row with the same `keys` instead.
+func (d sqlDialect) upsert(table string, columns, keys []string) string {
+	placeholders := make([]string, len(columns))
+	for i := range placeholders {
+		placeholders[i] = d.placeholder(i + 1)
+	}
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s)", table,
+		strings.Join(columns, ", "), strings.Join(placeholders, ", "), strings.Join(keys, ", "))
+
+	updates := make([]string, 0, len(columns)-len(keys))
+	for _, column := range columns {
+		if !slices.Contains(keys, column) {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		}
+	}
+	if len(updates) == 0 {
+		return statement + " DO NOTHING"
+	}
+	return statement + " DO UPDATE SET " + strings.Join(updates, ", ")
+}
+
+// sqlIdentifiers quotes each of `names` as an SQL identifier.
+func sqlIdentifiers(names []string) []string {
+	identifiers := make([]string, len(names))
+	for i, name := range names {
+		identifiers[i] = sqlIdentifier(name)
+	}
+	return identifiers
+}
+
+// sqlStatements represents the dialect specific statements used to upsert metrics in SQL databases.
+//
+// The arguments of each statement are followed by the values of the static labels in sorted
+// order and, for the rows of notes, by the values of the frontmatter labels in sorted order.
+type sqlStatements struct {
+	// total upserts the aggregated metrics with the arguments (timestamp, note_count, link_count, word_count).
+	total string
+	// notes upserts the metrics of a note with the arguments (timestamp, name, link_count, word_count, backlink_count).
+	notes string
+	// links upserts a link with the arguments (timestamp, source, target, count).
+	links string
+	// deletedNotes upserts a deletion event with the arguments (timestamp, name).
+	deletedNotes string
+	// tags upserts the metrics of a tag with the arguments (timestamp, tag, note_count, word_count, link_count).
+	tags string
+	// tagLinks upserts the links between two tags with the arguments (timestamp, source, target, count).
+	tagLinks string
+	// tasks upserts the aggregated tasks with the arguments (timestamp, open_count, completed_count).
+	tasks string
+	// noteTasks upserts the tasks of a note with the arguments (timestamp, name, open_count, completed_count).
+	noteTasks string
+	// anchors upserts the aggregated anchor links with the arguments (timestamp, link_count,
+	// broken_heading_count, broken_block_count).
+	anchors string
+	// noteAnchors upserts the anchor links of a note with the arguments (timestamp, name, link_count,
+	// broken_heading_count, broken_block_count).
+	noteAnchors string
+	// activity upserts the aggregated note dates with the arguments (timestamp, note_count,
+	// average_note_age_seconds, created_last_day, created_last_week, created_last_month,
+	// modified_last_day, modified_last_week, modified_last_month).
+	activity string
+}
+
+// sqlLabelArgs returns the values of the static labels of `naming` in sorted order.
+func sqlLabelArgs(naming MetricNaming) []any {
+	args := make([]any, 0, len(naming.Labels))
+	for _, label := range naming.labelNames() {
+		args = append(args, naming.Labels[label])
+	}
+	return args
+}
+
+// writeSQLMetrics upserts all rows of `zettelkastenMetrics` in `db` in a single transaction,
+// labelling them according to `naming`.
+func writeSQLMetrics(ctx context.Context, db *sql.DB, statements sqlStatements, naming MetricNaming, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp any) error {
+	labels := sqlLabelArgs(naming)
+	// args returns `values` followed by the values of the static labels
+	args := func(values ...any) []any {
+		return slices.Concat(values, labels)
+	}
+	// noteArgs returns `values` followed by the values of the static labels and of the frontmatter
+	// labels of `note`, which are NULL when missing
+	noteArgs := func(note metrics.NoteMetrics, values ...any) []any {
+		args := slices.Concat(values, labels)
+		for _, label := range naming.noteLabelNames() {
+			value, ok := naming.noteLabelValue(label, note)
+			if !ok {
+				args = append(args, nil)
+				continue
+			}
+			args = append(args, value)
+		}
+		return args
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, statements.total, args(timestamp, zettelkastenMetrics.NoteCount, zettelkastenMetrics.LinkCount, zettelkastenMetrics.WordCount)...)
+	if err != nil {
+		return fmt.Errorf("error inserting total metrics: %w", err)
+	}
+
+	if zettelkastenMetrics.OpenTaskCount+zettelkastenMetrics.CompletedTaskCount > 0 {
+		_, err = tx.ExecContext(ctx, statements.tasks, args(timestamp, zettelkastenMetrics.OpenTaskCount, zettelkastenMetrics.CompletedTaskCount)...)
+		if err != nil {
+			return fmt.Errorf("error inserting task metrics: %w", err)
+		}
+	}
+
+	if zettelkastenMetrics.AnchorLinkCount > 0 {
+		_, err = tx.ExecContext(ctx, statements.anchors, args(timestamp, zettelkastenMetrics.AnchorLinkCount,
+			zettelkastenMetrics.BrokenHeadingLinkCount, zettelkastenMetrics.BrokenBlockLinkCount)...)
+		if err != nil {
+			return fmt.Errorf("error inserting anchor metrics: %w", err)
+		}
+	}
+
+	if activity := zettelkastenMetrics.Activity; activity.NoteCount > 0 {
+		_, err = tx.ExecContext(ctx, statements.activity, args(timestamp, activity.NoteCount, uint(activity.AverageNoteAge/time.Second),
+			activity.CreatedLastDay, activity.CreatedLastWeek, activity.CreatedLastMonth,
+			activity.ModifiedLastDay, activity.ModifiedLastWeek, activity.ModifiedLastMonth)...)
+		if err != nil {
+			return fmt.Errorf("error inserting activity metrics: %w", err)
+		}
+	}
+
+	notesStatement, err := tx.PrepareContext(ctx, statements.notes)
+	if err != nil {
+		return fmt.Errorf("error preparing notes statement: %w", err)
+	}
+	defer func() { _ = notesStatement.Close() }()
+	linksStatement, err := tx.PrepareContext(ctx, statements.links)
+	if err != nil {
+		return fmt.Errorf("error preparing links statement: %w", err)
+	}
+	defer func() { _ = linksStatement.Close() }()
+
+	for name, metric := range zettelkastenMetrics.Notes {
+		_, err = notesStatement.ExecContext(ctx, noteArgs(metric, timestamp, name, metric.LinkCount, metric.WordCount, metric.BacklinkCount)...)
+		if err != nil {
+			return fmt.Errorf("error inserting metrics for note %s: %w", name, err)
+		}
+		if metric.OpenTaskCount+metric.CompletedTaskCount > 0 {
+			_, err = tx.ExecContext(ctx, statements.noteTasks, noteArgs(metric, timestamp, name, metric.OpenTaskCount, metric.CompletedTaskCount)...)
+			if err != nil {
+				return fmt.Errorf("error inserting tasks for note %s: %w", name, err)
+			}
+		}
+		if metric.AnchorLinkCount > 0 {
+			_, err = tx.ExecContext(ctx, statements.noteAnchors, noteArgs(metric, timestamp, name, metric.AnchorLinkCount, metric.BrokenHeadingLinkCount, metric.BrokenBlockLinkCount)...)
+			if err != nil {
+				return fmt.Errorf("error inserting anchor links for note %s: %w", name, err)
+			}
+		}
+		for target, count := range metric.Links {
+			_, err = linksStatement.ExecContext(ctx, args(timestamp, name, target, count)...)
+			if err != nil {
+				return fmt.Errorf("error inserting link from %s to %s: %w", name, target, err)
+			}
+		}
+	}
+
+	for tag, metric := range zettelkastenMetrics.Tags {
+		_, err = tx.ExecContext(ctx, statements.tags, args(timestamp, tag, metric.NoteCount, metric.WordCount, metric.LinkCount)...)
+		if err != nil {
+			return fmt.Errorf("error inserting metrics for tag %s: %w", tag, err)
+		}
+		for target, count := range metric.Links {
+			_, err = tx.ExecContext(ctx, statements.tagLinks, args(timestamp, tag, target, count)...)
+			if err != nil {
+				return fmt.Errorf("error inserting links from tag %s to %s: %w", tag, target, err)
+			}
+		}
+	}
+
+	for name, metric := range zettelkastenMetrics.DeletedNotes {
+		_, err = tx.ExecContext(ctx, statements.deletedNotes, noteArgs(metric, timestamp, name)...)
+		if err != nil {
+			return fmt.Errorf("error inserting deletion of note %s: %w", name, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
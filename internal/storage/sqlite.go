@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// sqliteDialect is the dialect of SQLite. Timestamps are stored as unix seconds.
+var sqliteDialect = sqlDialect{
+	timestamp:     "timestamp",
+	timestampType: "INTEGER",
+	textType:      "TEXT",
+	integerType:   "INTEGER",
+	placeholder:   func(int) string { return "?" },
+}
+
+// SQLiteStorage represents the implementation of a metric storage using a local SQLite database.
+type SQLiteStorage struct {
+	db         *sql.DB
+	naming     MetricNaming
+	statements sqlStatements
+}
+
+// NewSQLiteStorage creates a new `SQLiteStorage` in the database file at `path`, creating its schema if needed.
+//
+// The tables are named and labelled according to `naming`.
+func NewSQLiteStorage(path string, naming MetricNaming) (SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return SQLiteStorage{}, fmt.Errorf("error opening SQLite database: %w", err)
+	}
+	// SQLite only supports a single writer, so there's no point in having more connections.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(sqliteDialect.schema(naming))
+	if err != nil {
+		return SQLiteStorage{}, fmt.Errorf("error creating SQLite schema: %w", err)
+	}
+	return SQLiteStorage{db: db, naming: naming, statements: sqliteDialect.statements(naming)}, nil
+}
+
+// WriteMetrics writes `zettelkastenMetrics` to the database with `timestamp`.
+//
+// Existing rows with the same timestamp are replaced, so writing the same metrics twice is idempotent.
+func (s SQLiteStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	slog.Debug("Writing metrics to SQLite", slog.Int("notes", len(zettelkastenMetrics.Notes)))
+	err := writeSQLMetrics(ctx, s.db, s.statements, s.naming, zettelkastenMetrics, timestamp.Unix())
+	if err != nil {
+		slog.Error("Error writing metrics to SQLite storage", slog.Any("error", err))
+	}
+	return err
+}
+
+// LatestTimestamp returns the timestamp of the latest aggregated metrics with the static labels of the storage in the database.
+func (s SQLiteStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	var latest sql.NullInt64
+	err := s.db.QueryRowContext(ctx, sqliteDialect.latestTimestamp(s.naming), sqlLabelArgs(s.naming)...).Scan(&latest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error querying SQLite database: %w", err)
+	}
+	if !latest.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(latest.Int64, 0), nil
+}
+
+// Close closes the database.
+func (s SQLiteStorage) Close() error {
+	err := s.db.Close()
+	if err != nil {
+		return fmt.Errorf("error closing SQLite database: %w", err)
+	}
+	return nil
+}
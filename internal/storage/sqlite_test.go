@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zettelkasten.db")
+	storage, err := NewSQLiteStorage(path, MetricNaming{})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{
+		NoteCount:              2,
+		LinkCount:              3,
+		WordCount:              15,
+		OpenTaskCount:          2,
+		CompletedTaskCount:     1,
+		AnchorLinkCount:        3,
+		BrokenHeadingLinkCount: 1,
+		BrokenBlockLinkCount:   1,
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {Links: map[string]uint{"two": 2}, LinkCount: 2, WordCount: 10, BacklinkCount: 1, OpenTaskCount: 2, CompletedTaskCount: 1, AnchorLinkCount: 3, BrokenHeadingLinkCount: 1, BrokenBlockLinkCount: 1},
+			"two": {Links: map[string]uint{"one": 1}, LinkCount: 1, WordCount: 5, BacklinkCount: 2},
+		},
+		Tags: map[string]metrics.TagMetrics{
+			"project": {NoteCount: 2, WordCount: 15, LinkCount: 3, Links: map[string]uint{"project": 3}},
+		},
+		Activity:     metrics.ActivityMetrics{NoteCount: 2, AverageNoteAge: time.Hour, CreatedLastDay: 2, CreatedLastWeek: 2, CreatedLastMonth: 2},
+		DeletedNotes: map[string]metrics.NoteMetrics{"three": {}},
+	}
+	latest, err := storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.True(t, latest.IsZero())
+
+	// Writing twice should not duplicate rows
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp.Add(time.Hour)))
+
+	latest, err = storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.True(t, timestamp.Add(time.Hour).Equal(latest))
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	var noteCount, linkCount, wordCount uint
+	err = db.QueryRow("SELECT note_count, link_count, word_count FROM total WHERE timestamp = ?", timestamp.Unix()).Scan(&noteCount, &linkCount, &wordCount)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{2, 3, 15}, []uint{noteCount, linkCount, wordCount})
+
+	var backlinkCount uint
+	err = db.QueryRow("SELECT link_count, word_count, backlink_count FROM notes WHERE timestamp = ? AND name = 'one'", timestamp.Unix()).Scan(&linkCount, &wordCount, &backlinkCount)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{2, 10, 1}, []uint{linkCount, wordCount, backlinkCount})
+
+	var count uint
+	err = db.QueryRow("SELECT count FROM links WHERE timestamp = ? AND source = 'one' AND target = 'two'", timestamp.Unix()).Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), count)
+
+	err = db.QueryRow("SELECT note_count, word_count, link_count FROM tags WHERE timestamp = ? AND tag = 'project'", timestamp.Unix()).Scan(&noteCount, &wordCount, &linkCount)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{2, 15, 3}, []uint{noteCount, wordCount, linkCount})
+	err = db.QueryRow("SELECT count FROM tag_links WHERE timestamp = ? AND source = 'project' AND target = 'project'", timestamp.Unix()).Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), count)
+
+	var openCount, completedCount uint
+	err = db.QueryRow("SELECT open_count, completed_count FROM tasks WHERE timestamp = ?", timestamp.Unix()).Scan(&openCount, &completedCount)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{2, 1}, []uint{openCount, completedCount})
+	err = db.QueryRow("SELECT open_count, completed_count FROM note_tasks WHERE timestamp = ? AND name = 'one'", timestamp.Unix()).Scan(&openCount, &completedCount)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{2, 1}, []uint{openCount, completedCount})
+
+	var brokenHeadingCount, brokenBlockCount uint
+	err = db.QueryRow("SELECT link_count, broken_heading_count, broken_block_count FROM anchors WHERE timestamp = ?", timestamp.Unix()).Scan(&linkCount, &brokenHeadingCount, &brokenBlockCount)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{3, 1, 1}, []uint{linkCount, brokenHeadingCount, brokenBlockCount})
+	err = db.QueryRow("SELECT link_count, broken_heading_count, broken_block_count FROM note_anchors WHERE timestamp = ? AND name = 'one'", timestamp.Unix()).Scan(&linkCount, &brokenHeadingCount, &brokenBlockCount)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{3, 1, 1}, []uint{linkCount, brokenHeadingCount, brokenBlockCount})
+
+	var averageNoteAge, createdLastWeek, modifiedLastWeek uint
+	err = db.QueryRow("SELECT note_count, average_note_age_seconds, created_last_week, modified_last_week FROM activity WHERE timestamp = ?", timestamp.Unix()).Scan(&noteCount, &averageNoteAge, &createdLastWeek, &modifiedLastWeek)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{2, 3600, 2, 0}, []uint{noteCount, averageNoteAge, createdLastWeek, modifiedLastWeek})
+
+	for table, expected := range map[string]int{"total": 2, "notes": 4, "links": 4, "deleted_notes": 2, "tags": 2, "tag_links": 2, "tasks": 2, "note_tasks": 2, "anchors": 2, "note_anchors": 2, "activity": 2} {
+		var rows int
+		err = db.QueryRow("SELECT count(*) FROM " + table).Scan(&rows)
+		require.NoError(t, err)
+		assert.Equal(t, expected, rows, table)
+	}
+}
+
+func TestSQLiteStorage_Close(t *testing.T) {
+	storage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "zettelkasten.db"), MetricNaming{})
+	require.NoError(t, err)
+
+	require.NoError(t, Close(storage))
+
+	_, err = storage.LatestTimestamp(context.Background())
+	assert.ErrorContains(t, err, "database is closed")
+}
+
+func TestSQLiteStorage_Naming(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zettelkasten.db")
+	naming := MetricNaming{
+		Prefix:       "zettelkasten_",
+		Measurements: map[string]string{"total": "vault"},
+		Labels:       map[string]string{"vault": "work"},
+		NoteLabels:   map[string]string{"type": "type"},
+	}
+	storage, err := NewSQLiteStorage(path, naming)
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{
+		NoteCount: 2,
+		LinkCount: 1,
+		WordCount: 15,
+		Notes: map[string]metrics.NoteMetrics{
+			"one": {Links: map[string]uint{"two": 1}, LinkCount: 1, WordCount: 10, Frontmatter: map[string]any{"type": "idea"}},
+			"two": {WordCount: 5, BacklinkCount: 1},
+		},
+	}
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+
+	latest, err := storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.True(t, timestamp.Equal(latest))
+
+	// The latest timestamp is only read from the rows with the same labels
+	other, err := NewSQLiteStorage(path, MetricNaming{Prefix: naming.Prefix, Measurements: naming.Measurements, Labels: map[string]string{"vault": "home"}, NoteLabels: naming.NoteLabels})
+	require.NoError(t, err)
+	latest, err = other.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+	assert.True(t, latest.IsZero())
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	var noteCount uint
+	var vault string
+	err = db.QueryRow("SELECT note_count, vault FROM zettelkasten_vault WHERE timestamp = ?", timestamp.Unix()).Scan(&noteCount, &vault)
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), noteCount)
+	assert.Equal(t, "work", vault)
+
+	var noteType sql.NullString
+	err = db.QueryRow("SELECT vault, type FROM zettelkasten_notes WHERE name = 'one'").Scan(&vault, &noteType)
+	require.NoError(t, err)
+	assert.Equal(t, "work", vault)
+	assert.Equal(t, sql.NullString{String: "idea", Valid: true}, noteType)
+	err = db.QueryRow("SELECT type FROM zettelkasten_notes WHERE name = 'two'").Scan(&noteType)
+	require.NoError(t, err)
+	assert.False(t, noteType.Valid)
+
+	err = db.QueryRow("SELECT vault FROM zettelkasten_links WHERE source = 'one' AND target = 'two'").Scan(&vault)
+	require.NoError(t, err)
+	assert.Equal(t, "work", vault)
+
+	var tables int
+	err = db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'zettelkasten_%'").Scan(&tables)
+	require.NoError(t, err)
+	assert.Equal(t, 0, tables)
+}
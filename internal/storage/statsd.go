@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// StatsDStorage represents the implementation of a metric storage sending gauges to a StatsD server.
+//
+// The StatsD protocol has no support for timestamps, so metrics are always recorded by the
+// server at the time they are received.
+type StatsDStorage struct {
+	address string
+	prefix  string
+	naming  MetricNaming
+}
+
+// NewStatsDStorage creates a new `StatsDStorage` sending gauges with `prefix` to `address` over UDP,
+// named and labelled according to `naming`.
+func NewStatsDStorage(address, prefix string, naming MetricNaming) StatsDStorage {
+	return StatsDStorage{address: address, prefix: prefix, naming: naming}
+}
+
+// WriteMetrics sends `zettelkastenMetrics` as StatsD gauges. The `timestamp` is ignored.
+func (s StatsDStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	points := createInfluxDBPoints(s.naming, zettelkastenMetrics, timestamp)
+	graphiteMetrics, err := createGraphiteMetrics(s.prefix, s.naming, points)
+	if err != nil {
+		slog.Error("Error creating StatsD metrics", slog.Any("error", err))
+		return err
+	}
+
+	lines := make([][]byte, 0, len(graphiteMetrics))
+	for _, m := range graphiteMetrics {
+		lines = append(lines, fmt.Appendf(nil, "%s:%s|g\n", m.path, formatGraphiteValue(m.value)))
+	}
+
+	slog.Debug("Writing metrics to StatsD", slog.Int("metrics", len(lines)))
+	err = sendLines(ctx, GraphiteProtocolUDP, s.address, lines)
+	if err != nil {
+		slog.Error("Error writing metrics to StatsD", slog.Any("error", err), slog.String("address", s.address))
+		return err
+	}
+	return nil
+}
+
+// LatestTimestamp is not supported by the StatsD storage, since StatsD doesn't keep historical metrics.
+func (s StatsDStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("statsd storage: %w", errors.ErrUnsupported)
+}
@@ -1,7 +1,12 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/luissimas/zettelkasten-exporter/internal/config"
@@ -11,18 +16,241 @@ import (
 // Storage represents a storage for metrics.
 type Storage interface {
 	// WriteMetric writes the `zettelkastenMetrics` to the storage.
-	WriteMetrics(zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error
+	//
+	// Writes in flight are interrupted when `ctx` is cancelled.
+	WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error
+	// LatestTimestamp returns the timestamp of the latest metrics in the storage.
+	//
+	// A zero time is returned when the storage has no metrics, and an error wrapping
+	// `errors.ErrUnsupported` when the storage cannot tell which metrics it has.
+	LatestTimestamp(ctx context.Context) (time.Time, error)
 }
 
+// Flusher is implemented by storages that hold writes in memory before sending them.
+type Flusher interface {
+	// Flush sends all pending writes.
+	Flush(ctx context.Context) error
+}
+
+// Flush sends the pending writes of `storage` when it's a `Flusher`, doing nothing otherwise.
+func Flush(ctx context.Context, storage Storage) error {
+	if flusher, ok := storage.(Flusher); ok {
+		return flusher.Flush(ctx)
+	}
+	return nil
+}
+
+// flushChecker is implemented by storages that implement `Flusher` only to forward flushes to
+// other storages, reporting whether any of them holds writes until it's flushed.
+type flushChecker interface {
+	isFlusher() bool
+}
+
+// isFlusher reports whether `storage` holds writes until it's flushed, looking through the
+// storages that only forward flushes.
+func isFlusher(storage Storage) bool {
+	if checker, ok := storage.(flushChecker); ok {
+		return checker.isFlusher()
+	}
+	_, ok := storage.(Flusher)
+	return ok
+}
+
+// Closer is implemented by storages holding connections that must be released on shutdown.
+type Closer interface {
+	// Close releases the connections of the storage.
+	Close() error
+}
+
+// Close releases the connections of `storage` when it's a `Closer`, doing nothing otherwise.
+func Close(storage Storage) error {
+	if closer, ok := storage.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// historylessStorages are the storages that can't write metrics with the timestamps of past
+// commits, so that the historical metrics are written as if they were the latest ones.
+var historylessStorages = []string{config.StoragePrometheus, config.StoragePushgateway, config.StorageStatsD}
+
 // NewStorage creates a new Storage from the given config.
-func NewStorage(cfg config.Config) (Storage, error) {
-	if cfg.VictoriaMetricsURL != "" {
-		return NewVictoriaMetricsStorage(cfg.VictoriaMetricsURL), nil
+//
+// When more than one storage backend is configured, the returned Storage
+// writes the metrics to all of them. Writes to each backend are cancelled after
+// the configured write timeout. When a buffer directory is configured, the
+// writes to each backend are buffered on disk and retried on failures. The per note metrics
+// are reduced or pseudonymised before being written according to the config.
+//
+// Storages serving or holding connections release them when `ctx` is cancelled.
+func NewStorage(ctx context.Context, cfg config.Config) (Storage, error) {
+	naming, err := newMetricNaming(cfg)
+	if err != nil {
+		return nil, err
+	}
+	backends := make([]Backend, 0)
+	for _, name := range cfg.Storages() {
+		if cfg.CollectHistoricalMetrics && slices.Contains(historylessStorages, name) {
+			slog.Warn("Historical metrics are not supported by the storage and will be written as the latest metrics, consider setting CollectHistoricalMetrics to false", slog.String("storage", name))
+		}
+		storage, err := newBackendStorage(ctx, name, cfg, naming)
+		if err != nil {
+			return nil, fmt.Errorf("error creating %s storage: %w", name, err)
+		}
+		if cfg.StorageWriteTimeout > 0 {
+			storage = NewTimeoutStorage(storage, cfg.StorageWriteTimeout)
+		}
+		if cfg.StorageBufferDirectory != "" {
+			// Buffering each backend separately, so that retries don't write again to the backends
+			// that already accepted the metrics
+			storage, err = NewBufferedStorage(storage, filepath.Join(cfg.StorageBufferDirectory, name), cfg.StorageBufferMaxRetries, cfg.StorageBufferInitialBackoff, cfg.StorageBufferMaxBackoff)
+			if err != nil {
+				return nil, err
+			}
+		}
+		failurePolicy := FailurePolicyFailAll
+		if slices.Contains(cfg.StorageBestEffort, name) {
+			failurePolicy = FailurePolicyBestEffort
+		}
+		backends = append(backends, Backend{Name: name, Storage: storage, FailurePolicy: failurePolicy})
+	}
+
+	if len(backends) == 0 {
+		return nil, errors.New("invalid storage config")
+	}
+	var storage Storage = NewMultiStorage(backends...)
+	if len(backends) == 1 && backends[0].FailurePolicy == FailurePolicyFailAll {
+		storage = backends[0].Storage
 	}
 
-	if cfg.InfluxDBURL != "" {
-		return NewInfluxDBStorage(cfg.InfluxDBURL, cfg.InfluxDBOrg, cfg.InfluxDBBucket, cfg.InfluxDBToken), nil
+	if !cfg.NoteSeries || cfg.NoteSeriesLimit > 0 || cfg.NoteNameHash {
+		// Wrapping the buffer so that the filtered notes are never persisted in it
+		storage = NewNoteSeriesStorage(storage, NoteSeriesOptions{
+			Disabled:  !cfg.NoteSeries,
+			Limit:     cfg.NoteSeriesLimit,
+			HashNames: cfg.NoteNameHash,
+			HashSalt:  cfg.NoteNameHashSalt,
+		})
 	}
+	return storage, nil
+}
 
-	return nil, errors.New("invalid storage config")
+// newMetricNaming creates the `MetricNaming` used by the storages from the config.
+func newMetricNaming(cfg config.Config) (MetricNaming, error) {
+	measurements, err := config.ParseKeyValues(cfg.MetricMeasurementNames)
+	if err != nil {
+		return MetricNaming{}, err
+	}
+	labels, err := config.ParseKeyValues(cfg.MetricLabels)
+	if err != nil {
+		return MetricNaming{}, err
+	}
+	noteLabels := make(map[string]string, len(cfg.FrontmatterLabels))
+	for _, key := range cfg.FrontmatterLabels {
+		noteLabels[config.FrontmatterLabelName(key)] = key
+	}
+	return MetricNaming{Prefix: cfg.MetricPrefix, Measurements: measurements, Labels: labels, NoteLabels: noteLabels}, nil
+}
+
+// newBackendStorage creates the storage backend with the given `name` from the config,
+// using `naming` for the storages that support it.
+func newBackendStorage(ctx context.Context, name string, cfg config.Config, naming MetricNaming) (Storage, error) {
+	switch name {
+	case config.StorageVictoriaMetrics:
+		extraLabels, err := config.ParseKeyValues(cfg.VictoriaMetricsExtraLabels)
+		if err != nil {
+			return nil, err
+		}
+		return NewVictoriaMetricsStorage(cfg.VictoriaMetricsURL, extraLabels, cfg.VictoriaMetricsDB, cfg.VictoriaMetricsFormat, cfg.VictoriaMetricsBatchSize, HTTPOptions{
+			Username:           cfg.VictoriaMetricsUsername,
+			Password:           cfg.VictoriaMetricsPassword,
+			BearerToken:        cfg.VictoriaMetricsBearerToken,
+			Timeout:            cfg.VictoriaMetricsTimeout,
+			CAFile:             cfg.VictoriaMetricsCAFile,
+			InsecureSkipVerify: cfg.VictoriaMetricsInsecureSkipVerify,
+			Gzip:               cfg.VictoriaMetricsGzip,
+		}, naming)
+	case config.StorageInfluxDB:
+		switch cfg.InfluxDBVersion {
+		case 1:
+			return NewInfluxDBV1Storage(cfg.InfluxDBURL, cfg.InfluxDBDatabase, cfg.InfluxDBRetentionPolicy, HTTPOptions{
+				Username: cfg.InfluxDBUsername,
+				Password: cfg.InfluxDBPassword,
+			}, naming)
+		case 3:
+			return NewInfluxDBV3Storage(cfg.InfluxDBURL, cfg.InfluxDBDatabase, cfg.InfluxDBToken, HTTPOptions{}, naming)
+		default:
+			return NewInfluxDBStorage(cfg.InfluxDBURL, cfg.InfluxDBOrg, cfg.InfluxDBBucket, cfg.InfluxDBToken, naming), nil
+		}
+	case config.StoragePrometheus:
+		return NewPrometheusStorage(ctx, cfg.PrometheusListenAddress, naming)
+	case config.StoragePrometheusRemoteWrite:
+		headers, err := config.ParseKeyValues(cfg.PrometheusRemoteWriteHeaders)
+		if err != nil {
+			return nil, err
+		}
+		return NewRemoteWriteStorage(cfg.PrometheusRemoteWriteURL, HTTPOptions{
+			Username:           cfg.PrometheusRemoteWriteUsername,
+			Password:           cfg.PrometheusRemoteWritePassword,
+			BearerToken:        cfg.PrometheusRemoteWriteBearerToken,
+			Headers:            headers,
+			Timeout:            cfg.PrometheusRemoteWriteTimeout,
+			CAFile:             cfg.PrometheusRemoteWriteCAFile,
+			InsecureSkipVerify: cfg.PrometheusRemoteWriteInsecureSkipVerify,
+		}, naming)
+	case config.StoragePushgateway:
+		groupingKey, err := config.ParseKeyValues(cfg.PushgatewayGroupingKey)
+		if err != nil {
+			return nil, err
+		}
+		return NewPushgatewayStorage(cfg.PushgatewayURL, cfg.PushgatewayJob, groupingKey, HTTPOptions{
+			Username: cfg.PushgatewayUsername,
+			Password: cfg.PushgatewayPassword,
+		}, naming)
+	case config.StorageOTLP:
+		headers, err := config.ParseKeyValues(cfg.OTLPHeaders)
+		if err != nil {
+			return nil, err
+		}
+		return NewOTLPStorage(cfg.OTLPEndpoint, cfg.OTLPProtocol, HTTPOptions{
+			Headers:            headers,
+			Timeout:            cfg.OTLPTimeout,
+			CAFile:             cfg.OTLPCAFile,
+			InsecureSkipVerify: cfg.OTLPInsecureSkipVerify,
+		}, otlpResourceAttributes(cfg), naming)
+	case config.StorageSQLite:
+		return NewSQLiteStorage(cfg.SQLitePath, naming)
+	case config.StoragePostgres:
+		return NewPostgresStorage(cfg.PostgresURL, cfg.PostgresTimescaleDB, naming)
+	case config.StorageClickHouse:
+		return NewClickHouseStorage(ctx, cfg.ClickHouseURL, cfg.ClickHouseDatabase, HTTPOptions{
+			Username: cfg.ClickHouseUsername,
+			Password: cfg.ClickHousePassword,
+		}, naming)
+	case config.StorageFile:
+		return NewFileStorage(cfg.FileDirectory, cfg.FileFormat, cfg.FileDailyRotation, naming)
+	case config.StorageOpenMetrics:
+		if !cfg.RunOnce {
+			slog.Warn("The OpenMetrics storage keeps all metrics in memory, so it's meant to be used with RunOnce")
+		}
+		return NewOpenMetricsStorage(cfg.OpenMetricsPath, naming)
+	case config.StorageGraphite:
+		return NewGraphiteStorage(cfg.GraphiteAddress, cfg.GraphiteProtocol, cfg.GraphitePrefix, naming)
+	case config.StorageStatsD:
+		return NewStatsDStorage(cfg.StatsDAddress, cfg.StatsDPrefix, naming), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", name)
+	}
+}
+
+// otlpResourceAttributes creates the OTLP resource attributes describing the zettelkasten source in `cfg`.
+func otlpResourceAttributes(cfg config.Config) map[string]string {
+	attributes := map[string]string{"service.name": "zettelkasten-exporter"}
+	if cfg.ZettelkastenGitURL != "" {
+		attributes["zettelkasten.git.url"] = cfg.ZettelkastenGitURL
+		attributes["zettelkasten.git.branch"] = cfg.ZettelkastenGitBranch
+	} else {
+		attributes["zettelkasten.directory"] = cfg.ZettelkastenDirectory
+	}
+	return attributes
 }
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/config"
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStorage_BufferPerBackend(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	directory := t.TempDir()
+	bufferDirectory := t.TempDir()
+	storage, err := NewStorage(context.Background(), config.Config{
+		VictoriaMetricsURL:          server.URL,
+		VictoriaMetricsFormat:       VictoriaMetricsFormatInflux,
+		VictoriaMetricsBatchSize:    1,
+		FileDirectory:               directory,
+		FileFormat:                  FileFormatJSONLines,
+		NoteSeries:                  true,
+		StorageBufferDirectory:      bufferDirectory,
+		StorageBufferInitialBackoff: time.Millisecond,
+		StorageBufferMaxBackoff:     time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	// The first write only fails in VictoriaMetrics, so only it gets the metrics again
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{NoteCount: 1, Notes: map[string]metrics.NoteMetrics{}}
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp.Add(time.Hour)))
+
+	assert.Equal(t, 3, requests)
+	// The written batches are kept in the buffers until the storages are flushed
+	require.NoError(t, Flush(context.Background(), storage))
+	total, err := os.ReadFile(filepath.Join(directory, "total.jsonl"))
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(total)), "\n"), 2)
+	for _, name := range []string{config.StorageVictoriaMetrics, config.StorageFile} {
+		entries, err := os.ReadDir(filepath.Join(bufferDirectory, name))
+		require.NoError(t, err)
+		assert.Empty(t, entries, name)
+	}
+}
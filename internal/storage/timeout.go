@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+)
+
+// TimeoutStorage represents a storage that limits the duration of each write to another storage.
+//
+// Writes and flushes that take longer than the timeout are cancelled and fail with
+// `context.DeadlineExceeded`, so that an unresponsive storage doesn't block the exporter.
+type TimeoutStorage struct {
+	storage Storage
+	timeout time.Duration
+}
+
+// NewTimeoutStorage creates a new `TimeoutStorage` forwarding writes to `storage` with `timeout`.
+func NewTimeoutStorage(storage Storage, timeout time.Duration) TimeoutStorage {
+	return TimeoutStorage{storage: storage, timeout: timeout}
+}
+
+// WriteMetrics writes `zettelkastenMetrics` to the underlying storage, cancelling the write after the timeout.
+func (t TimeoutStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.storage.WriteMetrics(ctx, zettelkastenMetrics, timestamp)
+}
+
+// LatestTimestamp returns the timestamp of the latest metrics in the underlying storage.
+func (t TimeoutStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	return t.storage.LatestTimestamp(ctx)
+}
+
+// Flush sends the pending writes of the underlying storage, cancelling them after the timeout.
+func (t TimeoutStorage) Flush(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return Flush(ctx, t.storage)
+}
+
+// isFlusher reports whether the underlying storage holds writes until it's flushed.
+func (t TimeoutStorage) isFlusher() bool {
+	return isFlusher(t.storage)
+}
+
+// Close releases the connections of the underlying storage.
+func (t TimeoutStorage) Close() error {
+	return Close(t.storage)
+}
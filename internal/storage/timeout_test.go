@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingStorage is a storage whose writes block until the context is cancelled.
+type blockingStorage struct {
+	FakeStorage
+}
+
+func (b *blockingStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *blockingStorage) Flush(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestTimeoutStorage(t *testing.T) {
+	inner := NewFakeStorage()
+	storage := NewTimeoutStorage(&inner, time.Second)
+
+	require.NoError(t, storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 1}, time.Now()))
+	require.NoError(t, storage.Flush(context.Background()))
+
+	assert.Equal(t, []metrics.ZettelkastenMetrics{{NoteCount: 1}}, inner.Metrics)
+	assert.Equal(t, 1, inner.Flushes)
+}
+
+func TestTimeoutStorage_Exceeded(t *testing.T) {
+	storage := NewTimeoutStorage(&blockingStorage{FakeStorage: NewFakeStorage()}, time.Millisecond*10)
+
+	err := storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{}, time.Now())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	err = storage.Flush(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -2,9 +2,17 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
@@ -12,37 +20,252 @@ import (
 	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
 )
 
+// victoriaMetricsLookback is how far back VictoriaMetrics is queried for the latest metrics.
+const victoriaMetricsLookback = "100y"
+
+// The supported formats for writing metrics to VictoriaMetrics.
+const (
+	VictoriaMetricsFormatInflux = "influx"
+	VictoriaMetricsFormatJSON   = "json"
+)
+
+// VictoriaMetricsStorage represents the implementation of a metric storage using VictoriaMetrics.
+//
+// Writes are accumulated in memory and sent in a single request once `batchSize` writes are
+// pending, or when the storage is flushed. Writes retried for a timestamp that is already
+// pending are not added again, so that a failed batch is sent only once.
 type VictoriaMetricsStorage struct {
-	writeUrl string
+	writeUrl  string
+	queryUrl  string
+	format    string
+	batchSize int
+	pending   []*write.Point
+	// timestamps holds the timestamps of the pending writes.
+	timestamps []time.Time
+	client     *http.Client
+	options    HTTPOptions
+	naming     MetricNaming
+}
+
+// victoriaMetricsQueryResponse represents the response of the VictoriaMetrics instant query API.
+type victoriaMetricsQueryResponse struct {
+	Data struct {
+		Result []struct {
+			Value [2]any `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
 }
 
-func NewVictoriaMetricsStorage(url string) VictoriaMetricsStorage {
-	return VictoriaMetricsStorage{writeUrl: fmt.Sprintf("%s/api/v2/write", url)}
+// victoriaMetricsImportSeries represents a time series in the VictoriaMetrics JSON line format.
+// Reference: https://docs.victoriametrics.com/#json-line-format
+type victoriaMetricsImportSeries struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []float64         `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
 }
 
-func (v VictoriaMetricsStorage) WriteMetrics(zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
-	// NOTE: we encode the metrics in the InfluxDB line protocol and write them to the VictoriaMetrics write endpoint.
-	// Reference: https://docs.victoriametrics.com/#how-to-send-data-from-influxdb-compatible-agents-such-as-telegraf
-	points := createInfluxDBPoints(zettelkastenMetrics, timestamp)
-	content, err := encodePoints(points)
+// NewVictoriaMetricsStorage creates a new `VictoriaMetricsStorage` writing metrics in `format`,
+// with up to `batchSize` writes sent in each request.
+//
+// The `extraLabels` are added to all written metrics by VictoriaMetrics, and
+// `db`, when not empty, is added as the `db` label. Metrics are named and labelled
+// according to `naming` before being sent.
+func NewVictoriaMetricsStorage(baseUrl string, extraLabels map[string]string, db string, format string, batchSize int, options HTTPOptions, naming MetricNaming) (*VictoriaMetricsStorage, error) {
+	var writePath string
+	switch format {
+	case VictoriaMetricsFormatInflux:
+		writePath = "/api/v2/write"
+	case VictoriaMetricsFormatJSON:
+		writePath = "/api/v1/import"
+	default:
+		return nil, fmt.Errorf("unsupported VictoriaMetrics format %q", format)
+	}
+	writeUrl, err := url.Parse(fmt.Sprintf("%s%s", baseUrl, writePath))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing VictoriaMetrics URL: %w", err)
+	}
+	query := writeUrl.Query()
+	for _, name := range slices.Sorted(maps.Keys(extraLabels)) {
+		query.Add("extra_label", fmt.Sprintf("%s=%s", name, extraLabels[name]))
+	}
+	if db != "" {
+		if format == VictoriaMetricsFormatInflux {
+			query.Set("db", db)
+		} else {
+			// The db query parameter is only supported by the InfluxDB endpoints
+			query.Add("extra_label", fmt.Sprintf("db=%s", db))
+		}
+	}
+	writeUrl.RawQuery = query.Encode()
+
+	queryUrl, err := url.Parse(fmt.Sprintf("%s/api/v1/query", baseUrl))
 	if err != nil {
-		slog.Error("Error encoding points into line procotol", slog.Any("error", err))
+		return nil, fmt.Errorf("error parsing VictoriaMetrics URL: %w", err)
+	}
+	queryUrl.RawQuery = url.Values{"query": {latestTimestampQuery(naming, extraLabels, db)}}.Encode()
+
+	client, err := options.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &VictoriaMetricsStorage{
+		writeUrl:  writeUrl.String(),
+		queryUrl:  queryUrl.String(),
+		format:    format,
+		batchSize: max(batchSize, 1),
+		client:    client,
+		options:   options,
+		naming:    naming,
+	}, nil
+}
+
+// WriteMetrics adds `zettelkastenMetrics` with `timestamp` to the pending batch, sending it to
+// VictoriaMetrics once it holds `batchSize` writes.
+//
+// When metrics with `timestamp` are already pending, they are only sent again.
+func (v *VictoriaMetricsStorage) WriteMetrics(ctx context.Context, zettelkastenMetrics metrics.ZettelkastenMetrics, timestamp time.Time) error {
+	if !slices.ContainsFunc(v.timestamps, timestamp.Equal) {
+		v.pending = append(v.pending, createInfluxDBPoints(v.naming, zettelkastenMetrics, timestamp)...)
+		v.timestamps = append(v.timestamps, timestamp)
+	}
+	if len(v.timestamps) < v.batchSize {
+		return nil
+	}
+	return v.Flush(ctx)
+}
+
+// Flush sends the pending batch to VictoriaMetrics.
+//
+// The batch is kept when sending it fails, so that it's retried on the next flush.
+func (v *VictoriaMetricsStorage) Flush(ctx context.Context) error {
+	if len(v.timestamps) == 0 {
+		return nil
+	}
+
+	var content []byte
+	var err error
+	if v.format == VictoriaMetricsFormatJSON {
+		content, err = encodeImportLines(v.pending)
+	} else {
+		// NOTE: we encode the metrics in the InfluxDB line protocol and write them to the VictoriaMetrics write endpoint.
+		// Reference: https://docs.victoriametrics.com/#how-to-send-data-from-influxdb-compatible-agents-such-as-telegraf
+		content, err = encodePoints(v.pending, lp.UintSupport)
+	}
+	if err != nil {
+		slog.Error("Error encoding metrics", slog.Any("error", err), slog.String("format", v.format))
 		return err
 	}
-	slog.Debug("Writing metrics to VictoriaMetrics", slog.String("content", string(content)))
-	_, err = http.Post(v.writeUrl, "application/x-www-form-urlencoded", bytes.NewBuffer(content))
+	slog.Debug("Writing metrics to VictoriaMetrics", slog.Int("writes", len(v.timestamps)), slog.Int("bytes", len(content)))
+	request, err := v.options.newRequest(ctx, v.writeUrl, "text/plain; charset=utf-8", content)
+	if err != nil {
+		slog.Error("Error creating request", slog.Any("error", err), slog.String("url", v.writeUrl))
+		return err
+	}
+	err = sendRequest(v.client, request)
 	if err != nil {
 		slog.Error("Error sending POST request to endpoint", slog.Any("error", err), slog.String("url", v.writeUrl))
 		return err
 	}
+
+	v.pending = nil
+	v.timestamps = nil
 	return nil
 }
 
-// encodePoints encodes the given `points` into InfluxDB's line protocol.
-func encodePoints(points []*write.Point) ([]byte, error) {
+// isFlusher reports whether writes are held until the storage is flushed, which is only the
+// case when they are sent in batches.
+func (v *VictoriaMetricsStorage) isFlusher() bool {
+	return v.batchSize > 1
+}
+
+// LatestTimestamp returns the timestamp of the latest aggregated metrics in VictoriaMetrics.
+func (v *VictoriaMetricsStorage) LatestTimestamp(ctx context.Context) (time.Time, error) {
+	request, err := v.options.newGetRequest(ctx, v.queryUrl)
+	if err != nil {
+		return time.Time{}, err
+	}
+	body, err := fetchResponse(v.client, request)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error querying VictoriaMetrics: %w", err)
+	}
+
+	var response victoriaMetricsQueryResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error decoding VictoriaMetrics response: %w", err)
+	}
+	if len(response.Data.Result) == 0 {
+		return time.Time{}, nil
+	}
+	value, ok := response.Data.Result[0].Value[1].(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unexpected value in VictoriaMetrics response: %v", response.Data.Result[0].Value[1])
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing latest timestamp: %w", err)
+	}
+	return time.UnixMilli(int64(math.Round(seconds * 1000))), nil
+}
+
+// latestTimestampQuery creates the MetricsQL query returning the timestamp of the latest
+// aggregated metrics written with `naming`, `extraLabels` and `db`.
+func latestTimestampQuery(naming MetricNaming, extraLabels map[string]string, db string) string {
+	labels := naming.tags(make(map[string]string))
+	maps.Copy(labels, extraLabels)
+	if db != "" {
+		labels["db"] = db
+	}
+	matchers := make([]string, 0, len(labels))
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		matchers = append(matchers, fmt.Sprintf("%s=%s", name, strconv.Quote(labels[name])))
+	}
+	return fmt.Sprintf("max(tlast_over_time(%s_note_count{%s}[%s]))", naming.measurement(totalMeasurementName), strings.Join(matchers, ","), victoriaMetricsLookback)
+}
+
+// encodeImportLines encodes `points` into the VictoriaMetrics JSON line format, with one line
+// holding all values of each time series.
+func encodeImportLines(points []*write.Point) ([]byte, error) {
+	samples, err := createSamples(points)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make(map[string]*victoriaMetricsImportSeries)
+	keys := make([]string, 0)
+	for _, s := range samples {
+		key := s.name + formatLabels(s)
+		current, ok := series[key]
+		if !ok {
+			current = &victoriaMetricsImportSeries{Metric: map[string]string{metricNameLabel: s.name}}
+			for _, label := range s.labels {
+				current.Metric[label.Key] = label.Value
+			}
+			series[key] = current
+			keys = append(keys, key)
+		}
+		current.Values = append(current.Values, s.value)
+		current.Timestamps = append(current.Timestamps, s.timestamp.UnixMilli())
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, key := range keys {
+		err = encoder.Encode(series[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// encodePoints encodes the given `points` into InfluxDB's line protocol with millisecond precision.
+//
+// Unsigned integer fields are encoded as signed integers unless `fieldTypeSupport` includes `lp.UintSupport`.
+func encodePoints(points []*write.Point, fieldTypeSupport lp.FieldTypeSupport) ([]byte, error) {
 	var buffer bytes.Buffer
 	e := lp.NewEncoder(&buffer)
-	e.SetFieldTypeSupport(lp.UintSupport)
+	e.SetFieldTypeSupport(fieldTypeSupport)
 	e.FailOnFieldErr(true)
 	e.SetPrecision(time.Millisecond)
 	slog.Debug("Encoding points", slog.Any("points", points))
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luissimas/zettelkasten-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVictoriaMetricsStorage(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/write", r.URL.Path)
+		assert.Equal(t, []string{"owner=me", "vault=work"}, r.URL.Query()["extra_label"])
+		assert.Equal(t, "zettelkasten", r.URL.Query().Get("db"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "any-user", username)
+		assert.Equal(t, "any-password", password)
+		reader, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		body = string(content)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	storage, err := NewVictoriaMetricsStorage(
+		server.URL,
+		map[string]string{"vault": "work", "owner": "me"},
+		"zettelkasten",
+		VictoriaMetricsFormatInflux,
+		1,
+		HTTPOptions{Username: "any-user", Password: "any-password", Gzip: true},
+		MetricNaming{},
+	)
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 1, LinkCount: 2, WordCount: 3, Notes: map[string]metrics.NoteMetrics{}}, timestamp)
+	require.NoError(t, err)
+
+	assert.Equal(t, "total link_count=2u,note_count=1u,word_count=3u 1716978600000\n", body)
+}
+
+func TestVictoriaMetricsStorage_MetricNaming(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var body, query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/query" {
+			query = r.URL.Query().Get("query")
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+			return
+		}
+		content, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body = string(content)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	naming := MetricNaming{Prefix: "zettelkasten_", Measurements: map[string]string{"total": "vault"}, Labels: map[string]string{"owner": "team-a"}}
+	storage, err := NewVictoriaMetricsStorage(server.URL, nil, "", VictoriaMetricsFormatInflux, 1, HTTPOptions{}, naming)
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{NoteCount: 1, LinkCount: 2, WordCount: 3, Notes: map[string]metrics.NoteMetrics{
+		"one": {LinkCount: 2, WordCount: 3, BacklinkCount: 4},
+	}}, timestamp)
+	require.NoError(t, err)
+	_, err = storage.LatestTimestamp(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, "zettelkasten_vault,owner=team-a link_count=2u,note_count=1u,word_count=3u 1716978600000\n"+
+		"zettelkasten_notes,name=one,owner=team-a backlink_count=4u,link_count=2u,word_count=3u 1716978600000\n", body)
+	assert.Equal(t, `max(tlast_over_time(zettelkasten_vault_note_count{owner="team-a"}[100y]))`, query)
+}
+
+func TestVictoriaMetricsStorage_JSONBatches(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var requests []string
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/import", r.URL.Path)
+		assert.Equal(t, []string{"vault=work", "db=zettelkasten"}, r.URL.Query()["extra_label"])
+		assert.Empty(t, r.URL.Query().Get("db"))
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		content, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, string(content))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	storage, err := NewVictoriaMetricsStorage(server.URL, map[string]string{"vault": "work"}, "zettelkasten", VictoriaMetricsFormatJSON, 2, HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	for i := range 3 {
+		zettelkastenMetrics := metrics.ZettelkastenMetrics{NoteCount: uint(i), Notes: map[string]metrics.NoteMetrics{}}
+		require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp.Add(time.Duration(i)*time.Hour)))
+	}
+
+	// The first two writes are sent together, each series holding the values of both
+	assert.Equal(t, []string{
+		`{"metric":{"__name__":"total_link_count"},"values":[0,0],"timestamps":[1716978600000,1716982200000]}` + "\n" +
+			`{"metric":{"__name__":"total_note_count"},"values":[0,1],"timestamps":[1716978600000,1716982200000]}` + "\n" +
+			`{"metric":{"__name__":"total_word_count"},"values":[0,0],"timestamps":[1716978600000,1716982200000]}` + "\n",
+	}, requests)
+
+	// A failed flush keeps the pending write for the next flush
+	failing = true
+	assert.Error(t, storage.Flush(context.Background()))
+	failing = false
+	require.NoError(t, storage.Flush(context.Background()))
+	require.Len(t, requests, 2)
+	assert.Contains(t, requests[1], `{"metric":{"__name__":"total_note_count"},"values":[2],"timestamps":[1716985800000]}`)
+	require.NoError(t, storage.Flush(context.Background()))
+	assert.Len(t, requests, 2)
+}
+
+func TestVictoriaMetricsStorage_RetriedWrites(t *testing.T) {
+	timestamp := time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC)
+	var requests []string
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		content, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, string(content))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	storage, err := NewVictoriaMetricsStorage(server.URL, nil, "", VictoriaMetricsFormatInflux, 1, HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	zettelkastenMetrics := metrics.ZettelkastenMetrics{NoteCount: 1, Notes: map[string]metrics.NoteMetrics{
+		"one": {LinkCount: 2},
+	}}
+	assert.Error(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+	require.NoError(t, storage.WriteMetrics(context.Background(), zettelkastenMetrics, timestamp))
+
+	// The retried write holds each sample once
+	assert.Equal(t, []string{
+		"total link_count=0u,note_count=1u,word_count=0u 1716978600000\n" +
+			"notes,name=one backlink_count=0u,link_count=2u,word_count=0u 1716978600000\n",
+	}, requests)
+}
+
+func TestNewVictoriaMetricsStorage_UnsupportedFormat(t *testing.T) {
+	_, err := NewVictoriaMetricsStorage("http://localhost:8428", nil, "", "prometheus", 1, HTTPOptions{}, MetricNaming{})
+	assert.Error(t, err)
+}
+
+func TestVictoriaMetricsStorage_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("storage is read only"))
+	}))
+	defer server.Close()
+
+	storage, err := NewVictoriaMetricsStorage(server.URL, nil, "", VictoriaMetricsFormatInflux, 1, HTTPOptions{}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}}, time.Now())
+	assert.ErrorContains(t, err, "503")
+	assert.ErrorContains(t, err, "storage is read only")
+}
+
+func TestVictoriaMetricsStorage_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer any-token", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+	require.NoError(t, err)
+
+	storage, err := NewVictoriaMetricsStorage(server.URL, nil, "", VictoriaMetricsFormatInflux, 1, HTTPOptions{BearerToken: "any-token", CAFile: caFile, Timeout: time.Second}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}}, time.Now())
+	assert.NoError(t, err)
+
+	storage, err = NewVictoriaMetricsStorage(server.URL, nil, "", VictoriaMetricsFormatInflux, 1, HTTPOptions{BearerToken: "any-token"}, MetricNaming{})
+	require.NoError(t, err)
+	err = storage.WriteMetrics(context.Background(), metrics.ZettelkastenMetrics{Notes: map[string]metrics.NoteMetrics{}}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestVictoriaMetricsStorage_LatestTimestamp(t *testing.T) {
+	data := []struct {
+		name     string
+		response string
+		expected time.Time
+	}{
+		{
+			name:     "with metrics",
+			response: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1717000000,"1716978600"]}]}}`,
+			expected: time.Date(2024, 5, 29, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "without metrics",
+			response: `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			expected: time.Time{},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodGet, r.Method)
+				assert.Equal(t, "/api/v1/query", r.URL.Path)
+				assert.Equal(t, `max(tlast_over_time(total_note_count{db="zettelkasten",vault="work"}[100y]))`, r.URL.Query().Get("query"))
+				assert.Equal(t, "Bearer any-token", r.Header.Get("Authorization"))
+				_, _ = w.Write([]byte(d.response))
+			}))
+			defer server.Close()
+
+			storage, err := NewVictoriaMetricsStorage(server.URL, map[string]string{"vault": "work"}, "zettelkasten", VictoriaMetricsFormatInflux, 1, HTTPOptions{BearerToken: "any-token"}, MetricNaming{})
+			require.NoError(t, err)
+			latest, err := storage.LatestTimestamp(context.Background())
+			require.NoError(t, err)
+			assert.True(t, d.expected.Equal(latest), "expected %s, got %s", d.expected, latest)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package zettelkasten
 
 import (
+	"context"
 	"io/fs"
 	"time"
 )
@@ -22,6 +23,13 @@ func (f FakeZettelkasten) GetRoot() fs.FS {
 	return f.fs
 }
 
-func (f FakeZettelkasten) WalkHistory(walkFunc WalkFunc) error {
-	return walkFunc(f.fs, time.Now())
+func (f FakeZettelkasten) WalkHistory(ctx context.Context, since time.Time, walkFunc WalkFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := time.Now()
+	if !now.After(since) {
+		return nil
+	}
+	return walkFunc(f.fs, now)
 }
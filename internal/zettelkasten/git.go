@@ -1,10 +1,12 @@
 package zettelkasten
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"net/url"
 	"os"
 	"os/exec"
@@ -18,16 +20,94 @@ type GitZettelkasten struct {
 	url      string
 	branch   string
 	token    string
+	cache    *gitDatesCache
+}
+
+// gitDatesCache holds the dates of the files in the history up to the `head` commit, so that
+// only the commits pulled since then are read on each collection.
+type gitDatesCache struct {
+	head  string
+	dates map[string]FileDates
 }
 
 // NewGitZettelkasten creates a new GitZettelkasten.
 func NewGitZettelkasten(url, branch, token string) GitZettelkasten {
-	return GitZettelkasten{rootPath: "/tmp/zettelkasten-exporter", url: url, branch: branch, token: token}
+	return GitZettelkasten{rootPath: "/tmp/zettelkasten-exporter", url: url, branch: branch, token: token, cache: &gitDatesCache{}}
+}
+
+// gitCommit represents a commit in the zettelkasten history along with the files it changed.
+type gitCommit struct {
+	hash  string
+	date  time.Time
+	files []string
+}
+
+// gitFS is the root of the zettelkasten git repository with the dates of its files taken from the history.
+type gitFS struct {
+	fs.FS
+	dates map[string]FileDates
+}
+
+// FileDates returns the dates of the first and latest commits changing the file at `name`.
+func (g gitFS) FileDates(name string) (FileDates, bool) {
+	dates, ok := g.dates[name]
+	return dates, ok
 }
 
-// GetRoot retrieves the root of the zettelkasten git repository
+// GetRoot retrieves the root of the zettelkasten git repository.
+//
+// The dates of its files are taken from the commits changing them. When the history can't be read
+// the root falls back to the modification time of the files.
 func (g GitZettelkasten) GetRoot() fs.FS {
-	return os.DirFS(g.rootPath)
+	root := os.DirFS(g.rootPath)
+	dates, err := g.fileDates()
+	if err != nil {
+		slog.Warn("Error reading zettelkasten history, using file modification times", slog.Any("error", err))
+		return root
+	}
+	return gitFS{FS: root, dates: dates}
+}
+
+// fileDates returns the dates of the files changed in the history of the checked out commit.
+//
+// The dates are cached along with the commit they were read up to, and only the commits after
+// it are read on the following calls. The whole history is read again when that commit is no
+// longer part of it, such as after a force push.
+func (g GitZettelkasten) fileDates() (map[string]FileDates, error) {
+	head, err := g.execInRoot("git", "rev-parse", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("error reading HEAD commit: %w", err)
+	}
+	head = strings.TrimSpace(head)
+
+	cache := g.cache
+	if cache == nil {
+		cache = &gitDatesCache{}
+	}
+	if cache.head != head {
+		var commits []gitCommit
+		if cache.head != "" && g.isAncestor(cache.head) {
+			commits, err = g.commits(fmt.Sprintf("%s..%s", cache.head, head))
+		} else {
+			cache.dates = make(map[string]FileDates)
+			commits, err = g.commits()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, commit := range commits {
+			updateFileDates(cache.dates, commit)
+		}
+		cache.head = head
+	}
+	// Returning a copy, since the cached dates are updated on the following calls
+	return maps.Clone(cache.dates), nil
+}
+
+// isAncestor reports whether `commit` is part of the history of the checked out commit.
+func (g GitZettelkasten) isAncestor(commit string) bool {
+	_, err := g.execInRoot("git", "merge-base", "--is-ancestor", commit, "HEAD")
+	return err == nil
 }
 
 // Ensure makes sure that the git repository is valid and updated with the latest changes from the remote.
@@ -63,39 +143,131 @@ func (g GitZettelkasten) Ensure() error {
 	return nil
 }
 
-// WalkHistory calls `walkFunc` for each point in the zettelkasten history.
-func (g GitZettelkasten) WalkHistory(walkFunc WalkFunc) error {
-	log, err := g.execInRoot("git", "log", "--reverse", "--pretty=format:%h %ad", "--date=iso")
+// WalkHistory calls `walkFunc` for each commit in the zettelkasten history committed after `since`,
+// preceded by the last commit up to `since`, if any.
+//
+// The repository is reset to the latest commit of the branch afterwards, even when the walk
+// fails or is interrupted by cancelling `ctx`.
+func (g GitZettelkasten) WalkHistory(ctx context.Context, since time.Time, walkFunc WalkFunc) error {
+	err := g.walkCommits(ctx, since, walkFunc)
+	_, resetErr := g.execInRoot("git", "reset", "--hard", fmt.Sprintf("origin/%s", g.branch))
+	if resetErr != nil {
+		resetErr = fmt.Errorf("error reseting repository: %w", resetErr)
+	}
+	return errors.Join(err, resetErr)
+}
+
+// walkCommits checks out each commit committed after `since` in order, calling `walkFunc` on them.
+//
+// The last commit up to `since` is walked first, so that the state of the collection already in
+// the storage can be restored.
+//
+// Commits are compared by their committer date, since unlike the author date it's set again when
+// commits are rebased or cherry-picked, so it follows the order of the history.
+func (g GitZettelkasten) walkCommits(ctx context.Context, since time.Time, walkFunc WalkFunc) error {
+	commits, err := g.commits()
 	if err != nil {
 		return fmt.Errorf("error walking zettelkasten history: %w", err)
 	}
-	lines := strings.SplitSeq(log, "\n")
-	for line := range lines {
-		splited := strings.SplitN(line, " ", 2)
-		commit := splited[0]
-		date, err := time.Parse("2006-01-02 15:04:05 -0700", splited[1])
-		if err != nil {
-			return fmt.Errorf("error parsing commit date: %s", err)
+	dates := make(map[string]FileDates)
+	var stored *gitCommit
+	for _, commit := range commits {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("error walking history: %w", err)
 		}
-		slog.Info("Walking commit", slog.String("commit", commit), slog.Time("date", date))
-		start := time.Now()
-		_, err = g.execInRoot("git", "reset", "--hard", commit)
-		if err != nil {
-			return fmt.Errorf("error reseting repository %w", err)
+		if !commit.date.After(since) {
+			slog.Debug("Skipping commit already in storage", slog.String("commit", commit.hash), slog.Time("date", commit.date))
+			updateFileDates(dates, commit)
+			stored = &commit
+			continue
+		}
+		if stored != nil {
+			slog.Info("Walking last commit in storage", slog.String("commit", stored.hash), slog.Time("date", stored.date))
+			err = g.walkCommit(*stored, dates, walkFunc)
+			if err != nil {
+				return err
+			}
+			stored = nil
 		}
-		err = walkFunc(g.GetRoot(), date)
+
+		slog.Info("Walking commit", slog.String("commit", commit.hash), slog.Time("date", commit.date))
+		start := time.Now()
+		updateFileDates(dates, commit)
+		err = g.walkCommit(commit, dates, walkFunc)
 		if err != nil {
-			return fmt.Errorf("error walking history: %w", err)
+			return err
 		}
-		slog.Info("Walked commit", slog.String("commit", commit), slog.Duration("duration", time.Since(start)))
+		slog.Info("Walked commit", slog.String("commit", commit.hash), slog.Duration("duration", time.Since(start)))
 	}
-	_, err = g.execInRoot("git", "reset", "--hard", fmt.Sprintf("origin/%s", g.branch))
+	return nil
+}
+
+// walkCommit checks out `commit` and calls `walkFunc` on it with the file `dates`.
+func (g GitZettelkasten) walkCommit(commit gitCommit, dates map[string]FileDates, walkFunc WalkFunc) error {
+	_, err := g.execInRoot("git", "reset", "--hard", commit.hash)
 	if err != nil {
-		return fmt.Errorf("error reseting repository: %w", err)
+		return fmt.Errorf("error reseting repository %w", err)
+	}
+	err = walkFunc(gitFS{FS: os.DirFS(g.rootPath), dates: dates}, commit.date)
+	if err != nil {
+		return fmt.Errorf("error walking history: %w", err)
 	}
 	return nil
 }
 
+// commits lists the commits of the checked out branch from the oldest to the latest, dated by their committer date.
+//
+// When given, only the commits in `revisionRange` are listed.
+func (g GitZettelkasten) commits(revisionRange ...string) ([]gitCommit, error) {
+	arg := []string{"-c", "core.quotePath=false", "log", "--reverse", "--name-only", "--pretty=format:%x00%h %cd", "--date=iso"}
+	log, err := g.execInRoot("git", append(arg, revisionRange...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading git log: %w", err)
+	}
+	return parseGitLog(log)
+}
+
+// parseGitLog parses the output of `git log --name-only` with each commit header prefixed by a NUL byte.
+func parseGitLog(log string) ([]gitCommit, error) {
+	var commits []gitCommit
+	for entry := range strings.SplitSeq(log, "\x00") {
+		lines := strings.Split(strings.TrimSpace(entry), "\n")
+		if lines[0] == "" {
+			continue
+		}
+		splited := strings.SplitN(lines[0], " ", 2)
+		if len(splited) != 2 {
+			return nil, fmt.Errorf("invalid commit line: %q", lines[0])
+		}
+		date, err := time.Parse("2006-01-02 15:04:05 -0700", splited[1])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing commit date: %w", err)
+		}
+		commit := gitCommit{hash: splited[0], date: date}
+		for _, file := range lines[1:] {
+			if file != "" {
+				commit.files = append(commit.files, file)
+			}
+		}
+		commits = append(commits, commit)
+	}
+	return commits, nil
+}
+
+// updateFileDates updates the dates of the files changed in `commit`.
+func updateFileDates(dates map[string]FileDates, commit gitCommit) {
+	for _, file := range commit.files {
+		fileDates, ok := dates[file]
+		if !ok || commit.date.Before(fileDates.Created) {
+			fileDates.Created = commit.date
+		}
+		if commit.date.After(fileDates.Modified) {
+			fileDates.Modified = commit.date
+		}
+		dates[file] = fileDates
+	}
+}
+
 func (g GitZettelkasten) cloneRepository() error {
 	authenticatedUrl, err := makeAuthenticatedUrl(g.url, g.token)
 	if err != nil {
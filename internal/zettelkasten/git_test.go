@@ -1,9 +1,16 @@
 package zettelkasten
 
 import (
+	"context"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMakeAuthenticatedURL(t *testing.T) {
@@ -35,3 +42,121 @@ func TestMakeAuthenticatedURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseGitLog(t *testing.T) {
+	log := "\x00a1b2c3d 2024-05-29 10:00:00 +0000\none.md\ntwo.md\n\n\x00e4f5a6b 2024-05-30 12:30:00 -0300\none.md\n\n\x00c7d8e9f 2024-05-31 08:00:00 +0000"
+	result, err := parseGitLog(log)
+	assert.NoError(t, err)
+	expected := []gitCommit{
+		{hash: "a1b2c3d", date: time.Date(2024, 5, 29, 10, 0, 0, 0, time.UTC), files: []string{"one.md", "two.md"}},
+		{hash: "e4f5a6b", date: time.Date(2024, 5, 30, 15, 30, 0, 0, time.UTC), files: []string{"one.md"}},
+		{hash: "c7d8e9f", date: time.Date(2024, 5, 31, 8, 0, 0, 0, time.UTC)},
+	}
+	assert.Len(t, result, len(expected))
+	for i, commit := range result {
+		assert.Equal(t, expected[i].hash, commit.hash)
+		assert.True(t, expected[i].date.Equal(commit.date), "expected %s, got %s", expected[i].date, commit.date)
+		assert.Equal(t, expected[i].files, commit.files)
+	}
+
+	_, err = parseGitLog("\x00a1b2c3d yesterday")
+	assert.Error(t, err)
+}
+
+func TestUpdateFileDates(t *testing.T) {
+	first := time.Date(2024, 5, 29, 10, 0, 0, 0, time.UTC)
+	second := time.Date(2024, 5, 30, 10, 0, 0, 0, time.UTC)
+	dates := make(map[string]FileDates)
+	updateFileDates(dates, gitCommit{hash: "a1b2c3d", date: second, files: []string{"one.md", "two.md"}})
+	updateFileDates(dates, gitCommit{hash: "e4f5a6b", date: first, files: []string{"one.md"}})
+
+	assert.Equal(t, map[string]FileDates{
+		"one.md": {Created: first, Modified: second},
+		"two.md": {Created: second, Modified: second},
+	}, dates)
+}
+
+func TestWalkCommits(t *testing.T) {
+	directory := t.TempDir()
+	git := func(env []string, arg ...string) {
+		cmd := exec.Command("git", arg...)
+		cmd.Dir = directory
+		cmd.Env = append(os.Environ(), env...)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	commit := func(file, authorDate, committerDate string) {
+		require.NoError(t, os.WriteFile(filepath.Join(directory, file), []byte(file), 0o644))
+		git(nil, "add", file)
+		git([]string{"GIT_AUTHOR_DATE=" + authorDate, "GIT_COMMITTER_DATE=" + committerDate}, "-c", "user.name=any", "-c", "user.email=any@example.com", "commit", "-m", file)
+	}
+	git(nil, "init", "--quiet")
+	commit("one.md", "2024-05-29T10:00:00Z", "2024-05-29T10:00:00Z")
+	commit("two.md", "2024-05-30T10:00:00Z", "2024-05-30T10:00:00Z")
+	// A rebased commit keeps its older author date
+	commit("three.md", "2024-05-20T10:00:00Z", "2024-05-31T10:00:00Z")
+
+	walked := make(map[time.Time][]string)
+	timestamps := make([]time.Time, 0)
+	g := GitZettelkasten{rootPath: directory}
+	since := time.Date(2024, 5, 30, 10, 0, 0, 0, time.UTC)
+	err := g.walkCommits(context.Background(), since, func(root fs.FS, timestamp time.Time) error {
+		files, err := fs.Glob(root, "*.md")
+		require.NoError(t, err)
+		walked[timestamp.UTC()] = files
+		timestamps = append(timestamps, timestamp.UTC())
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The last commit in storage is walked before the new ones
+	assert.Equal(t, []time.Time{since, time.Date(2024, 5, 31, 10, 0, 0, 0, time.UTC)}, timestamps)
+	assert.Equal(t, []string{"one.md", "two.md"}, walked[timestamps[0]])
+	assert.Equal(t, []string{"one.md", "three.md", "two.md"}, walked[timestamps[1]])
+}
+
+func TestGetRoot_FileDates(t *testing.T) {
+	directory := t.TempDir()
+	git := func(env []string, arg ...string) {
+		cmd := exec.Command("git", arg...)
+		cmd.Dir = directory
+		cmd.Env = append(os.Environ(), env...)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	commit := func(file, content, date string) {
+		require.NoError(t, os.WriteFile(filepath.Join(directory, file), []byte(content), 0o644))
+		git(nil, "add", file)
+		git([]string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date}, "-c", "user.name=any", "-c", "user.email=any@example.com", "commit", "-m", file)
+	}
+	fileDates := func(root fs.FS, name string) FileDates {
+		dates, ok := root.(gitFS).FileDates(name)
+		require.True(t, ok, name)
+		return FileDates{Created: dates.Created.UTC(), Modified: dates.Modified.UTC()}
+	}
+	first := time.Date(2024, 5, 29, 10, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+	third := second.Add(24 * time.Hour)
+	git(nil, "init", "--quiet")
+	commit("one.md", "one", first.Format(time.RFC3339))
+
+	g := GitZettelkasten{rootPath: directory, cache: &gitDatesCache{}}
+	root := g.GetRoot()
+	assert.Equal(t, FileDates{Created: first, Modified: first}, fileDates(root, "one.md"))
+
+	// The commits pulled since the last collection update the cached dates
+	commit("one.md", "one changed", second.Format(time.RFC3339))
+	commit("two.md", "two", second.Format(time.RFC3339))
+	root = g.GetRoot()
+	assert.Equal(t, FileDates{Created: first, Modified: second}, fileDates(root, "one.md"))
+	assert.Equal(t, FileDates{Created: second, Modified: second}, fileDates(root, "two.md"))
+
+	// A rewritten history is read again
+	git(nil, "reset", "--quiet", "--hard", "HEAD~2")
+	commit("three.md", "three", third.Format(time.RFC3339))
+	root = g.GetRoot()
+	assert.Equal(t, FileDates{Created: first, Modified: first}, fileDates(root, "one.md"))
+	assert.Equal(t, FileDates{Created: third, Modified: third}, fileDates(root, "three.md"))
+	_, ok := root.(gitFS).FileDates("two.md")
+	assert.False(t, ok)
+}
@@ -1,6 +1,7 @@
 package zettelkasten
 
 import (
+	"context"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -42,7 +43,14 @@ func (l LocalZettelkasten) Ensure() error {
 	return nil
 }
 
-// WalkHistory calls `walkFunc` for each point in the zettelkasten history.
-func (l LocalZettelkasten) WalkHistory(walkFunc WalkFunc) error {
-	return walkFunc(l.GetRoot(), time.Now())
+// WalkHistory calls `walkFunc` for the current state of the zettelkasten, since it has no history.
+func (l LocalZettelkasten) WalkHistory(ctx context.Context, since time.Time, walkFunc WalkFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := time.Now()
+	if !now.After(since) {
+		return nil
+	}
+	return walkFunc(l.GetRoot(), now)
 }
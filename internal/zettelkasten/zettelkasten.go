@@ -1,6 +1,7 @@
 package zettelkasten
 
 import (
+	"context"
 	"io/fs"
 	"time"
 
@@ -13,14 +14,35 @@ type Zettelkasten interface {
 	Ensure() error
 	// GetRoot retrieves the root of the Zettelkasten directory structure.
 	GetRoot() fs.FS
-	// WalkHistory walks the history of the Zettelkasten, calling `walkFunc` on each point in the history.
-	WalkHistory(walkFunc WalkFunc) error
+	// WalkHistory walks the history of the Zettelkasten, calling `walkFunc` on each point in the history after `since`.
+	//
+	// When there are points after `since`, the last point up to `since` is walked first, so that the
+	// state of the collection already in the storage can be restored.
+	//
+	// The walk stops with the error of `ctx` when it's cancelled.
+	WalkHistory(ctx context.Context, since time.Time, walkFunc WalkFunc) error
 }
 
 // WalkFunc is the type of function called by `Zettelkasten.WalkHistory` to
 // process all points in the history of the zettelkasten.
 type WalkFunc func(root fs.FS, timestamp time.Time) error
 
+// FileDates represents when a file of the Zettelkasten was created and last modified.
+type FileDates struct {
+	Created  time.Time
+	Modified time.Time
+}
+
+// DatedFS is a Zettelkasten root that knows when its files were created and last modified,
+// such as the checkout of a git repository.
+//
+// Roots that don't implement it fall back to the modification time of their files.
+type DatedFS interface {
+	fs.FS
+	// FileDates returns the dates of the file at `name`, reporting whether they are known.
+	FileDates(name string) (FileDates, bool)
+}
+
 // NewZettelkasten creates a new Zettelkasten from the given config.
 func NewZettelkasten(cfg config.Config) Zettelkasten {
 	if cfg.ZettelkastenGitURL != "" {
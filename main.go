@@ -24,7 +24,10 @@ func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel}))
 	slog.SetDefault(logger)
 
-	metricsStorage, err := storage.NewStorage(cfg)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	metricsStorage, err := storage.NewStorage(ctx, cfg)
 	if err != nil {
 		slog.Error("Error creating storage", slog.Any("error", err))
 		os.Exit(1)
@@ -33,10 +36,11 @@ func main() {
 	zet := zettelkasten.NewZettelkasten(cfg)
 	exporter := exporter.NewExporter(cfg, zet, metricsStorage)
 
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
-
-	if err := exporter.Start(ctx); err != nil {
+	err = exporter.Start(ctx)
+	if closeErr := storage.Close(metricsStorage); closeErr != nil {
+		slog.Warn("Error closing storage", slog.Any("error", closeErr))
+	}
+	if err != nil {
 		slog.Error("Error on exporter", slog.Any("error", err))
 		os.Exit(1)
 	}